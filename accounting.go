@@ -0,0 +1,58 @@
+package resolver
+
+import (
+	"net"
+	"sync"
+)
+
+// connCounts - the number of open upstream connections per transport
+type connCounts struct {
+	mu sync.Mutex
+	m  map[string]int
+}
+
+// add changes the number of connections of transport by delta, a nil counter counts nothing
+func (c *connCounts) add(transport string, delta int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = make(map[string]int)
+	}
+	c.m[transport] += delta
+}
+
+// get returns a copy of the counts
+func (c *connCounts) get() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := make(map[string]int, len(c.m))
+	for transport, n := range c.m {
+		ret[transport] = n
+	}
+	return ret
+}
+
+// countedConn - a connection counted as open until it is closed
+type countedConn struct {
+	net.Conn
+	once      sync.Once
+	counts    *connCounts
+	transport string
+}
+
+// newCountedConn counts conn of transport as open
+func newCountedConn(conn net.Conn, counts *connCounts, transport string) net.Conn {
+	counts.add(transport, 1)
+	return &countedConn{Conn: conn, counts: counts, transport: transport}
+}
+
+// Close ...
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		c.counts.add(c.transport, -1)
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,286 @@
+package resolver
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultApexLabels - the default number of trailing labels forming the apex of a host name
+	defaultApexLabels = 2
+
+	// defaultApexMinHosts - the default number of hosts of an apex coalescing applies from
+	defaultApexMinHosts = 4
+)
+
+// ApexCoalescing - settings of WithApexCoalescing
+type ApexCoalescing struct {
+	// Labels - the number of trailing labels of host names forming their apex, e.g. 2 makes
+	// example.com the apex of www.example.com and api.eu.example.com. 2 if not set
+	Labels int
+
+	// MinHosts - refreshes of hosts of an apex are coalesced once it has at least this many
+	// maintained hosts, 4 if not set
+	MinHosts int
+
+	// Window - a refresh of a host of an apex is moved up to this time earlier to join a refresh
+	// of another host of the apex, so the refreshes go in batches instead of one by one. Refreshes
+	// are never moved later, so addresses are not served past their TTLs. Zero disables moving
+	Window time.Duration
+
+	// MaxConcurrent - the max number of refreshes of hosts of an apex run at once, a batch is run
+	// this many at a time instead of as a burst. Zero means no limit besides WithRefreshWorkers
+	MaxConcurrent int
+
+	// SharedConn - address queries of refreshes of hosts of an apex are sent over one TCP
+	// connection per nameserver kept open between refreshes, instead of a UDP exchange each.
+	// Nameservers with designated encrypted transports are queried over them as usual
+	SharedConn bool
+}
+
+// apexCoalescer - the state of WithApexCoalescing, guarded by the scheduler mutex
+// except conns and coalesced
+type apexCoalescer struct {
+	cfg ApexCoalescing
+
+	// hosts - the number of hosts scheduled per apex
+	hosts map[string]int
+
+	// slots - the times of scheduled refreshes per apex in ascending order
+	slots map[string][]time.Time
+
+	// running - the number of running refreshes per apex
+	running map[string]int
+
+	// conns - shared connections per apex if SharedConn is set
+	conns sync.Map
+
+	// coalesced - the number of refreshes moved to join a batch
+	coalesced uint64
+}
+
+// newApexCoalescer ...
+func newApexCoalescer(cfg ApexCoalescing) *apexCoalescer {
+	if cfg.Labels <= 0 {
+		cfg.Labels = defaultApexLabels
+	}
+	if cfg.MinHosts <= 0 {
+		cfg.MinHosts = defaultApexMinHosts
+	}
+	return &apexCoalescer{
+		cfg:     cfg,
+		hosts:   make(map[string]int),
+		slots:   make(map[string][]time.Time),
+		running: make(map[string]int),
+	}
+}
+
+// apexOf returns the apex of hostName: its last labels
+func apexOf(hostName string, labels int) string {
+	name := normalizeName(hostName)
+	i := len(name)
+	for ; labels > 0; labels-- {
+		i = strings.LastIndexByte(name[:i], '.')
+		if i < 0 {
+			return name
+		}
+	}
+	return name[i+1:]
+}
+
+// active reports whether refreshes of the apex are coalesced
+func (c *apexCoalescer) active(apex string) bool {
+	return c.hosts[apex] >= c.cfg.MinHosts
+}
+
+// align returns the time of the latest scheduled refresh of the apex within Window before at,
+// at itself if there is none, and records the refresh. Slots which passed are dropped
+func (c *apexCoalescer) align(apex string, at, now time.Time) time.Time {
+	slots := c.slots[apex]
+	i := sort.Search(len(slots), func(i int) bool { return !slots[i].Before(now) })
+	slots = slots[i:]
+
+	// the first slot after at, the one before it is the latest not after at
+	j := sort.Search(len(slots), func(i int) bool { return slots[i].After(at) })
+	if c.cfg.Window > 0 && j > 0 && !slots[j-1].Before(at.Add(-c.cfg.Window)) {
+		if slots[j-1].Before(at) {
+			atomic.AddUint64(&c.coalesced, 1)
+		}
+		c.slots[apex] = slots
+		return slots[j-1]
+	}
+	slots = append(slots, time.Time{})
+	copy(slots[j+1:], slots[j:])
+	slots[j] = at
+	c.slots[apex] = slots
+	return at
+}
+
+// add counts a scheduled host of the apex
+func (c *apexCoalescer) add(apex string) {
+	c.hosts[apex]++
+}
+
+// started counts a running refresh of the apex
+func (c *apexCoalescer) started(apex string) {
+	c.running[apex]++
+}
+
+// finished uncounts a running refresh of the apex
+func (c *apexCoalescer) finished(apex string) {
+	if c.running[apex]--; c.running[apex] <= 0 {
+		delete(c.running, apex)
+	}
+}
+
+// remove uncounts a host of the apex, the state of the apex is dropped with its last host,
+// refreshes running over its shared connections finish first
+func (c *apexCoalescer) remove(apex string) {
+	if c.hosts[apex]--; c.hosts[apex] > 0 {
+		return
+	}
+	delete(c.hosts, apex)
+	delete(c.slots, apex)
+	if v, ok := c.conns.LoadAndDelete(apex); ok {
+		v.(*sharedConns).close()
+	}
+}
+
+// limited reports whether refreshes of the apex may not start now because of MaxConcurrent
+func (c *apexCoalescer) limited(apex string) bool {
+	return c.cfg.MaxConcurrent > 0 && c.active(apex) && c.running[apex] >= c.cfg.MaxConcurrent
+}
+
+// context returns ctx carrying the shared connections of the apex if its queries share them
+func (c *apexCoalescer) context(ctx context.Context, apex string, counts *connCounts) context.Context {
+	if !c.cfg.SharedConn {
+		return ctx
+	}
+	v, _ := c.conns.LoadOrStore(apex, &sharedConns{counts: counts})
+	return context.WithValue(ctx, sharedConnsKey{}, v)
+}
+
+// closeConns closes all shared connections
+func (c *apexCoalescer) closeConns() {
+	c.conns.Range(func(apex, v interface{}) bool {
+		c.conns.Delete(apex)
+		v.(*sharedConns).close()
+		return true
+	})
+}
+
+// sharedConnsKey - the context key of shared connections
+type sharedConnsKey struct{}
+
+// sharedConns - TCP connections to nameservers shared by queries of an apex, one per address
+type sharedConns struct {
+	counts *connCounts
+
+	mu     sync.Mutex
+	conns  map[string]*sharedConn
+	closed bool
+}
+
+// sharedConn - a TCP connection used by one query at a time
+type sharedConn struct {
+	mu   sync.Mutex
+	conn *dns.Conn
+}
+
+// sharedConnsFrom ...
+func sharedConnsFrom(ctx context.Context) *sharedConns {
+	sc, _ := ctx.Value(sharedConnsKey{}).(*sharedConns)
+	return sc
+}
+
+// get returns the connection to addr, creating an unconnected one if there is none,
+// nil if the connections are closed
+func (sc *sharedConns) get(addr string) *sharedConn {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.closed {
+		return nil
+	}
+	if sc.conns == nil {
+		sc.conns = make(map[string]*sharedConn)
+	}
+	c := sc.conns[addr]
+	if c == nil {
+		c = &sharedConn{}
+		sc.conns[addr] = c
+	}
+	return c
+}
+
+// exchange sends m to addr over the shared connection, dialing it if it is not open.
+// A connection closed by the nameserver while idle is dialed again once. If the connections are closed
+// m is sent over a connection of its own
+func (sc *sharedConns) exchange(ctx context.Context, addr string, m *dns.Msg) (*dns.Msg, error) {
+	c := sc.get(addr)
+	if c == nil {
+		return exchangeNet(ctx, sc.counts, TransportTCP, addr, m)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	client := &dns.Client{Net: TransportTCP}
+	if deadline, ok := ctx.Deadline(); ok {
+		client.Timeout = time.Until(deadline)
+	}
+	for attempt := 0; ; attempt++ {
+		reused := c.conn != nil
+		if !reused {
+			conn, err := client.DialContext(ctx, addr)
+			if err != nil {
+				return nil, err
+			}
+			c.conn = conn
+			sc.counts.add(TransportTCP, 1)
+		}
+		in, _, err := client.ExchangeWithConn(m, c.conn)
+		if err == nil {
+			return checkResponse(m, in)
+		}
+		c.close(sc.counts)
+		if !reused || attempt > 0 || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+}
+
+// close closes the connection if it is open, c.mu must be held
+func (c *sharedConn) close(counts *connCounts) {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+		counts.add(TransportTCP, -1)
+	}
+}
+
+// close closes all connections
+func (sc *sharedConns) close() {
+	sc.mu.Lock()
+	conns := sc.conns
+	sc.conns, sc.closed = nil, true
+	sc.mu.Unlock()
+	for _, c := range conns {
+		c.mu.Lock()
+		c.close(sc.counts)
+		c.mu.Unlock()
+	}
+}
+
+// WithApexCoalescing - coalesces refreshes of hosts sharing a zone apex, e.g. many names of one
+// service or CDN zone, to reduce bursts toward the same nameservers: refreshes are moved earlier
+// to run in batches, batches are run at most MaxConcurrent at a time and optionally over one TCP
+// connection per nameserver, see ApexCoalescing. Applies to hosts scheduled after this call
+func (r *Resolver) WithApexCoalescing(cfg ApexCoalescing) *Resolver {
+	r.scheduler.setApex(newApexCoalescer(cfg))
+	return r
+}
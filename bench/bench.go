@@ -0,0 +1,38 @@
+// Package bench provides reproducible benchmarks of the resolver against the fake upstream server
+// of resolvertest, run them with go test -bench . ./bench, and a small load harness, see Load
+package bench
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ndmsystems/go-dns-caching-resolver/resolvertest"
+)
+
+// hostNames returns n host names of the benchmark zone
+func hostNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("h%d.bench.test", i)
+	}
+	return names
+}
+
+// newServer starts a fake upstream server answering A and AAAA queries of names with ttl
+func newServer(names []string, ttl int) (*resolvertest.Server, error) {
+	s, err := resolvertest.NewServer()
+	if err != nil {
+		return nil, err
+	}
+
+	var zone strings.Builder
+	for i, name := range names {
+		fmt.Fprintf(&zone, "%s. %d IN A 10.%d.%d.%d\n", name, ttl, i>>16&255, i>>8&255, i&255)
+		fmt.Fprintf(&zone, "%s. %d IN AAAA 2001:db8::%x\n", name, ttl, i)
+	}
+	if err = s.AddRecords(zone.String()); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
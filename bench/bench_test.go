@@ -0,0 +1,148 @@
+package bench
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	resolver "github.com/ndmsystems/go-dns-caching-resolver"
+	"github.com/ndmsystems/go-dns-caching-resolver/resolvertest"
+)
+
+// waitFor waits up to 30 seconds until cond is true
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(30 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+// BenchmarkLookupHotPath measures GetNextIP of a resolved host from parallel goroutines
+func BenchmarkLookupHotPath(b *testing.B) {
+	names := hostNames(1)
+	s, err := newServer(names, 300)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+
+	r := resolver.New("bench", nil).WithNameservers(s.Addr)
+	defer r.Stop()
+	r.GetNextIP(names[0])
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if r.GetNextIP(names[0]) == "" {
+				b.Error("empty address")
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkRefreshStorm measures refreshing of 1000 hosts expiring at once, each iteration moves
+// a fake clock past the TTL and waits until all hosts are refreshed
+func BenchmarkRefreshStorm(b *testing.B) {
+	const hosts = 1000
+	names := hostNames(hosts)
+	s, err := newServer(names, 60)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+
+	clock := resolvertest.NewClock(time.Now())
+	r := resolver.NewWithClock("bench", nil, clock).WithNameservers(s.Addr)
+	defer r.Stop()
+	for _, name := range names {
+		r.AddHost(name)
+	}
+	for _, name := range names {
+		r.GetNextIP(name)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// A and AAAA are refreshed separately
+		want := r.Stats().Refreshes + 2*hosts
+		clock.Advance(61 * time.Second)
+		if !waitFor(func() bool { return r.Stats().Refreshes >= want }) {
+			b.Fatal("hosts are not refreshed")
+		}
+	}
+}
+
+// BenchmarkHostChurn10k measures creating implicit hosts while deleting old ones,
+// so 10000 hosts are maintained at any time
+func BenchmarkHostChurn10k(b *testing.B) {
+	const window = 10000
+	names := hostNames(window * 2)
+	s, err := newServer(names, 300)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+
+	r := resolver.New("bench", nil).WithNameservers(s.Addr)
+	defer r.Stop()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.GetNextIP(names[i%len(names)])
+		if i >= window {
+			r.DelHost(names[(i-window)%len(names)])
+		}
+	}
+}
+
+// BenchmarkServerQPS measures A queries answered from the cache by ServeDNS over UDP from parallel clients
+func BenchmarkServerQPS(b *testing.B) {
+	names := hostNames(100)
+	s, err := newServer(names, 300)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+
+	r := resolver.New("bench", nil).WithNameservers(s.Addr)
+	defer r.Stop()
+	for _, name := range names {
+		r.GetNextIP(name)
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	srv := &dns.Server{PacketConn: pc, Handler: r}
+	go srv.ActivateAndServe()
+	defer srv.Shutdown()
+	addr := pc.LocalAddr().String()
+
+	var next uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		c := new(dns.Client)
+		m := new(dns.Msg)
+		for pb.Next() {
+			name := names[atomic.AddUint64(&next, 1)%uint64(len(names))]
+			m.SetQuestion(dns.Fqdn(name), dns.TypeA)
+			in, _, err := c.Exchange(m, addr)
+			if err != nil || len(in.Answer) == 0 {
+				b.Error("no answer", err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package bench
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	resolver "github.com/ndmsystems/go-dns-caching-resolver"
+)
+
+// LoadConfig - settings of a load run
+type LoadConfig struct {
+	// Hosts - the number of distinct host names looked up
+	Hosts int
+
+	// TTL - the TTL of records of the fake upstream server in seconds
+	TTL int
+
+	// Concurrency - the number of goroutines looking up hosts
+	Concurrency int
+
+	// Duration - the duration of the run
+	Duration time.Duration
+
+	// UpstreamDelay - a delay of every answer of the fake upstream server
+	UpstreamDelay time.Duration
+
+	// Seed - the seed of the random choice of hosts, runs with the same seed look up the same hosts
+	Seed int64
+}
+
+// LoadReport - results of a load run
+type LoadReport struct {
+	// Lookups - the number of GetNextIP calls, Empty - ones returned no address
+	Lookups uint64
+	Empty   uint64
+
+	// UpstreamQueries - the number of queries received by the fake upstream server
+	UpstreamQueries int
+
+	// P50, P99, Max - latencies of lookups
+	P50 time.Duration
+	P99 time.Duration
+	Max time.Duration
+
+	Stats resolver.Stats
+}
+
+// Load runs lookups of random hosts against a resolver using the fake upstream server
+// until cfg.Duration passes or ctx is done
+func Load(ctx context.Context, cfg LoadConfig) (LoadReport, error) {
+	if cfg.Hosts <= 0 {
+		cfg.Hosts = 1000
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 60
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 8
+	}
+
+	names := hostNames(cfg.Hosts)
+	s, err := newServer(names, cfg.TTL)
+	if err != nil {
+		return LoadReport{}, err
+	}
+	defer s.Close()
+	s.SetDelay(cfg.UpstreamDelay)
+
+	r := resolver.New("load", nil).WithNameservers(s.Addr)
+	defer r.Stop()
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	var (
+		mu        sync.Mutex
+		report    LoadReport
+		latencies []time.Duration
+		wg        sync.WaitGroup
+	)
+	for w := 0; w < cfg.Concurrency; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			var lookups, empty uint64
+			local := make([]time.Duration, 0, 1024)
+			for ctx.Err() == nil {
+				start := time.Now()
+				if r.GetNextIP(names[rnd.Intn(len(names))]) == "" {
+					empty++
+				}
+				local = append(local, time.Since(start))
+				lookups++
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Lookups += lookups
+			report.Empty += empty
+			latencies = append(latencies, local...)
+		}(cfg.Seed + int64(w))
+	}
+	wg.Wait()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	if n := len(latencies); n > 0 {
+		report.P50 = latencies[n/2]
+		report.P99 = latencies[n*99/100]
+		report.Max = latencies[n-1]
+	}
+	report.UpstreamQueries = s.Queries()
+	report.Stats = r.Stats()
+	return report, nil
+}
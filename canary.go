@@ -0,0 +1,147 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultCanaryInterval - the default interval of canary queries
+	defaultCanaryInterval = 10 * time.Minute
+
+	// defaultCanaryZone - the default zone canary names are made in
+	defaultCanaryZone = "com"
+
+	// canaryLabelLen - the length of the random label of a canary name
+	canaryLabelLen = 20
+)
+
+// Canary - settings of hijack detection, see WithCanary
+type Canary struct {
+	// Interval - the interval of canary queries, 10 minutes if zero
+	Interval time.Duration
+
+	// Zone - the zone random names are made in, "com" if empty. The zone must not have a wildcard record
+	Zone string
+
+	// OnHijack - if set, it is called when a nameserver starts or stops answering canary names
+	// with addresses. The function is called synchronously and must not block
+	OnHijack func(ev HijackEvent)
+}
+
+// HijackEvent - a change of a nameserver behavior found by canary queries
+type HijackEvent struct {
+	Nameserver string
+
+	// Name - the canary name queried
+	Name string
+
+	// Hijacking - true if the nameserver answered the nonexistent name with addresses,
+	// false if it answers correctly again
+	Hijacking bool
+
+	// Addrs - forged addresses returned
+	Addrs []net.IP
+
+	Time time.Time
+}
+
+// WithCanary - enables hijack detection: each nameserver set by WithNameservers is periodically queried
+// for a random nonexistent name, a nameserver answering it with addresses (NXDOMAIN rewriting
+// or a captive portal) gets the NameserverHijacking state and is tried after the other nameservers
+// until it answers a canary query correctly
+func (r *Resolver) WithCanary(c Canary) *Resolver {
+	if c.Interval <= 0 {
+		c.Interval = defaultCanaryInterval
+	}
+	if c.Zone == "" {
+		c.Zone = defaultCanaryZone
+	}
+	r.scheduler.goroutines.spawn("canary", "", func() { r.canaryLoop(c) })
+	return r
+}
+
+// canaryLoop ...
+func (r *Resolver) canaryLoop(c Canary) {
+	ticker := r.clock.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	r.checkCanary(c)
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case <-ticker.C():
+			r.checkCanary(c)
+		}
+	}
+}
+
+// checkCanary queries each nameserver for a random name and updates their hijacking flags
+func (r *Resolver) checkCanary(c Canary) {
+	for _, nServer := range r.dnsClient.getNameServers() {
+		name := r.canaryName(c.Zone)
+		ctx, cancel := context.WithTimeout(context.Background(), r.dnsClient.queryTimeout(nServer))
+		in, _, err := r.dnsClient.exchange(ctx, nServer, name, dns.TypeA, false)
+		cancel()
+		if err != nil || in.Rcode == dns.RcodeServerFailure || in.Rcode == dns.RcodeRefused {
+			continue
+		}
+
+		var addrs []net.IP
+		for _, rr := range in.Answer {
+			if a, ok := rr.(*dns.A); ok {
+				addrs = append(addrs, a.A)
+			}
+		}
+		hijacking := len(addrs) > 0
+		if !r.clientCfg.nsStats.setHijacking(nServer, hijacking, r.clock.Now()) {
+			continue
+		}
+
+		if hijacking {
+			logError(r.logger, r.tag, "Nameserver answers nonexistent names, demoted:", nServer, name, addrs)
+		} else {
+			logInfo(r.logger, r.tag, "Nameserver answers nonexistent names correctly again:", nServer)
+		}
+		if c.OnHijack != nil {
+			c.OnHijack(HijackEvent{
+				Nameserver: nServer,
+				Name:       name,
+				Hijacking:  hijacking,
+				Addrs:      addrs,
+				Time:       r.clock.Now(),
+			})
+		}
+	}
+}
+
+// canaryName returns a random name in zone
+func (r *Resolver) canaryName(zone string) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+	label := make([]byte, canaryLabelLen)
+	for i := range label {
+		label[i] = letters[r.clientCfg.rnd.intn(len(letters))]
+	}
+	return string(label) + "." + zone
+}
+
+// demoteHijacking moves nameservers answering canary names to the end of nameServers
+func (d *dnsClient) demoteHijacking(nameServers []string) []string {
+	if !d.cfg.nsStats.anyHijacking() {
+		return nameServers
+	}
+	ret := make([]string, 0, len(nameServers))
+	var demoted []string
+	for _, nServer := range nameServers {
+		if d.cfg.nsStats.isHijacking(nServer) {
+			demoted = append(demoted, nServer)
+		} else {
+			ret = append(ret, nServer)
+		}
+	}
+	return append(ret, demoted...)
+}
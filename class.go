@@ -0,0 +1,104 @@
+package resolver
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// HostClass - a priority class of a host controlling its refreshes and eviction
+type HostClass int
+
+const (
+	// ClassNormal - the default class
+	ClassNormal HostClass = iota
+	// ClassCritical - hosts which must never serve expired addresses: they are refreshed
+	// ahead of expiry before other hosts, never suspended by WithLazyRefresh and evicted last
+	ClassCritical
+	// ClassBackground - bulk hosts refreshed after all other hosts and evicted first
+	ClassBackground
+)
+
+// criticalPrefetchDivisor - ClassCritical hosts are refreshed when 1/criticalPrefetchDivisor of their TTL is left
+const criticalPrefetchDivisor = 10
+
+// String ...
+func (c HostClass) String() string {
+	switch c {
+	case ClassNormal:
+		return "normal"
+	case ClassCritical:
+		return "critical"
+	case ClassBackground:
+		return "background"
+	}
+	return "unknown"
+}
+
+// evictionRank returns the order of eviction of the class under memory pressure, lower ranks go first
+func (c HostClass) evictionRank() int {
+	switch c {
+	case ClassBackground:
+		return 0
+	case ClassCritical:
+		return 2
+	}
+	return 1
+}
+
+// getClass ...
+func (h *host) getClass() HostClass {
+	return HostClass(atomic.LoadInt32(&h.class))
+}
+
+// setClass ...
+func (h *host) setClass(c HostClass) {
+	atomic.StoreInt32(&h.class, int32(c))
+}
+
+// refreshDelay returns the time until the next refresh of addresses valid for ttl seconds,
+// ClassCritical hosts are refreshed ahead of expiry
+func (h *host) refreshDelay(ttl uint32) time.Duration {
+	if h.getClass() == ClassCritical && ttl > retryIntervalSec {
+		prefetch := ttl / criticalPrefetchDivisor
+		if prefetch < 1 {
+			prefetch = 1
+		}
+		ttl -= prefetch
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// hostClass returns the class of hostName set by SetHostClass or by the policy, r.mu must be held
+func (r *Resolver) hostClass(hostName string, policy *Policy) HostClass {
+	if c, ok := r.classes[hostName]; ok {
+		return c
+	}
+	if policy != nil {
+		return policy.Class
+	}
+	return ClassNormal
+}
+
+// SetHostClass sets the priority class of host with name hostName, it applies to the maintained
+// host at once and to the host added later, and takes precedence over Policy.Class
+func (r *Resolver) SetHostClass(hostName string, class HostClass) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.classes == nil {
+		r.classes = make(map[string]HostClass)
+	}
+	r.classes[hostName] = class
+	if h, ok := r.hosts[hostName]; ok && !h.static {
+		h.setClass(class)
+	}
+}
+
+// HostClass returns the priority class of host with name hostName, ClassNormal if it is not maintained
+func (r *Resolver) HostClass(hostName string) HostClass {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if h, ok := r.hosts[hostName]; ok {
+		return h.getClass()
+	}
+	return ClassNormal
+}
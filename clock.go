@@ -0,0 +1,47 @@
+package resolver
+
+import (
+	"time"
+)
+
+// Clock - a source of time used for TTL expiration, refresh timers, stale hosts deletion and backoff,
+// see NewWithClock
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker - a ticker created by Clock
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock - a Clock backed by the time package
+type realClock struct{}
+
+// Now ...
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// After ...
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// NewTicker ...
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// realTicker ...
+type realTicker struct {
+	*time.Ticker
+}
+
+// C ...
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
@@ -0,0 +1,233 @@
+// Command resolver-diag validates DNS behavior on a device with the code paths of the resolver library:
+//
+//	resolver-diag probe [-ns list] [-type A] name - queries every nameserver directly
+//	resolver-diag time [-ns list] [-n 100] name... - times uncached, first and cached lookups
+//	resolver-diag selftest [-ns list] [-json] - checks reachability and capabilities of nameservers
+//	resolver-diag cache -admin url [stats|hosts|nameservers|diagnostics|zone|export host] - inspects
+//	the cache of a running resolver through its DebugHandler mounted at url
+//
+// Nameservers default to the system ones
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	resolver "github.com/ndmsystems/go-dns-caching-resolver"
+)
+
+const usage = `usage:
+  resolver-diag probe [-ns list] [-type A] [-timeout 5s] name
+  resolver-diag time [-ns list] [-n 100] [-timeout 5s] name...
+  resolver-diag selftest [-ns list] [-json] [-timeout 30s]
+  resolver-diag cache -admin url [stats|hosts|nameservers|diagnostics|zone|export host]
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "probe":
+		err = probe(os.Args[2:])
+	case "time":
+		err = timeLookups(os.Args[2:])
+	case "selftest":
+		err = selfTest(os.Args[2:])
+	case "cache":
+		err = cache(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "resolver-diag:", err)
+		os.Exit(1)
+	}
+}
+
+// newResolver returns a resolver querying nameservers of the comma-separated list, the system ones if empty
+func newResolver(list string) (*resolver.Resolver, error) {
+	r := resolver.New("resolver-diag", nil)
+	if list == "" {
+		nameServers, err := resolver.SystemNameservers(true)
+		if err != nil {
+			return nil, err
+		}
+		return r.WithNameservers(nameServers...), nil
+	}
+	return r.WithNameservers(strings.Split(list, ",")...), nil
+}
+
+// probe ...
+func probe(args []string) error {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	ns := fs.String("ns", "", "comma-separated nameservers, the system ones if empty")
+	qtypeName := fs.String("type", "A", "query type")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout of the probe")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("probe needs one name")
+	}
+	qtype, ok := dns.StringToType[strings.ToUpper(*qtypeName)]
+	if !ok {
+		return fmt.Errorf("unknown query type %q", *qtypeName)
+	}
+
+	r, err := newResolver(*ns)
+	if err != nil {
+		return err
+	}
+	defer r.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	for _, p := range r.ProbeNameservers(ctx, fs.Arg(0), qtype) {
+		fmt.Printf("%s %s rtt=%s", p.Nameserver, p.Transport, p.RTT.Round(time.Microsecond))
+		if p.Err != nil {
+			fmt.Printf(" error: %v\n", p.Err)
+			continue
+		}
+		fmt.Printf(" rcode=%s ad=%t answers=%d\n", p.Rcode, p.AuthenticatedData, len(p.Answers))
+		for _, rr := range p.Answers {
+			fmt.Println("  " + rr)
+		}
+	}
+	return nil
+}
+
+// timeLookups ...
+func timeLookups(args []string) error {
+	fs := flag.NewFlagSet("time", flag.ExitOnError)
+	ns := fs.String("ns", "", "comma-separated nameservers, the system ones if empty")
+	n := fs.Int("n", 100, "the number of cached lookups averaged")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout of each host")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("time needs host names")
+	}
+
+	r, err := newResolver(*ns)
+	if err != nil {
+		return err
+	}
+	defer r.Stop()
+
+	for _, hostName := range fs.Args() {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		t := r.TimeLookup(ctx, hostName, *n)
+		cancel()
+
+		fmt.Printf("%s uncached=%s first=%s hit=%s\n", t.Host,
+			t.Uncached.Round(time.Microsecond), t.First.Round(time.Microsecond), t.Hit.Round(time.Nanosecond))
+		if t.UncachedErr != nil {
+			fmt.Printf("  uncached error: %v\n", t.UncachedErr)
+		}
+		if t.Result.Err != nil {
+			fmt.Printf("  error: %v\n", t.Result.Err)
+			continue
+		}
+		fmt.Printf("  ip4=%v ip6=%v ttl=%s source=%s/%s\n", t.Result.IP4, t.Result.IP6, t.Result.TTL,
+			t.Result.Source4.Nameserver, t.Result.Source4.Transport)
+	}
+	return nil
+}
+
+// selfTest ...
+func selfTest(args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	ns := fs.String("ns", "", "comma-separated nameservers, the system ones if empty")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of the self-test")
+	fs.Parse(args)
+
+	r, err := newResolver(*ns)
+	if err != nil {
+		return err
+	}
+	defer r.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	rep := r.SelfTest(ctx)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			return err
+		}
+	} else {
+		for _, u := range rep.Upstreams {
+			fmt.Printf("%s reachability=%s", u.Nameserver, u.Reachability.Status)
+			if u.Answered > 0 {
+				fmt.Printf(" transport=%s rtt=%s", u.Transport, u.RTT.Round(time.Microsecond))
+			}
+			fmt.Println()
+			checks := []resolver.Check{u.Reachability, u.EDNS, u.TCP, u.DNSSEC}
+			for i, name := range []string{"reachability", "edns", "tcp", "dnssec"} {
+				if c := checks[i]; c.Status != resolver.CheckSkipped {
+					fmt.Println(strings.TrimRight(fmt.Sprintf("  %s: %s %s", name, c.Status, c.Detail), " "))
+				}
+			}
+		}
+	}
+	if !rep.OK() {
+		return fmt.Errorf("no nameserver is reachable")
+	}
+	return nil
+}
+
+// cache ...
+func cache(args []string) error {
+	fs := flag.NewFlagSet("cache", flag.ExitOnError)
+	admin := fs.String("admin", "", "the URL the DebugHandler of the resolver is mounted at")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of the request")
+	fs.Parse(args)
+	if *admin == "" {
+		return fmt.Errorf("cache needs -admin")
+	}
+
+	what, query := "stats", url.Values{}
+	if fs.NArg() > 0 {
+		what = fs.Arg(0)
+	}
+	switch what {
+	case "stats", "hosts", "nameservers", "diagnostics", "zone":
+	case "export":
+		if fs.NArg() != 2 {
+			return fmt.Errorf("export needs a host name")
+		}
+		query.Set("host", fs.Arg(1))
+	default:
+		return fmt.Errorf("unknown cache view %q", what)
+	}
+
+	u := strings.TrimSuffix(*admin, "/") + "/" + what
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	client := http.Client{Timeout: *timeout}
+	resp, err := client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
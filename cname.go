@@ -0,0 +1,165 @@
+package resolver
+
+import (
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// CNAMELink - a CNAME record of the chain a host is resolved through
+type CNAMELink struct {
+	Name   string
+	Target string
+	TTL    uint32
+}
+
+// cnameChain - the CNAME chain of the last answer of a host
+type cnameChain struct {
+	mu    sync.RWMutex
+	links []CNAMELink
+}
+
+// set replaces the chain and returns the previous one
+func (c *cnameChain) set(links []CNAMELink) []CNAMELink {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old := c.links
+	c.links = links
+	return old
+}
+
+// get ...
+func (c *cnameChain) get() []CNAMELink {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.links
+}
+
+// cnameIndex - hosts of a resolver by the names their CNAME chains go through
+type cnameIndex struct {
+	mu   sync.Mutex
+	deps map[string]map[*host]bool
+}
+
+// update moves h from the names of the old chain to the names of the new one
+func (x *cnameIndex) update(h *host, old, cur []CNAMELink) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, link := range old {
+		if hosts := x.deps[link.Target]; hosts != nil {
+			delete(hosts, h)
+			if len(hosts) == 0 {
+				delete(x.deps, link.Target)
+			}
+		}
+	}
+	for _, link := range cur {
+		if x.deps == nil {
+			x.deps = make(map[string]map[*host]bool)
+		}
+		if x.deps[link.Target] == nil {
+			x.deps[link.Target] = make(map[*host]bool)
+		}
+		x.deps[link.Target][h] = true
+	}
+}
+
+// dependents returns hosts other than h resolved through any of names, stopped hosts are dropped
+func (x *cnameIndex) dependents(h *host, names []string) []*host {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var ret []*host
+	seen := make(map[*host]bool)
+	for _, name := range names {
+		for dep := range x.deps[name] {
+			if dep.isStopped() {
+				delete(x.deps[name], dep)
+				continue
+			}
+			if dep != h && !seen[dep] {
+				seen[dep] = true
+				ret = append(ret, dep)
+			}
+		}
+	}
+	return ret
+}
+
+// CNAMEChain returns the CNAME records the last answer for host with name hostName went through,
+// nil if the name is not an alias or the host is not maintained
+func (r *Resolver) CNAMEChain(hostName string) []CNAMELink {
+	r.mu.RLock()
+	h := r.hosts[hostOnly(hostName)]
+	r.mu.RUnlock()
+
+	if h == nil {
+		return nil
+	}
+	return append([]CNAMELink(nil), h.chain.get()...)
+}
+
+// cnameLinks returns CNAME records of an answer in lower case without trailing dots
+// and the min TTL of them, ttl is unchanged if there are no such records
+func cnameLinks(answer []dns.RR, ttl uint32) ([]CNAMELink, uint32) {
+	var links []CNAMELink
+	for _, rr := range answer {
+		cname, ok := rr.(*dns.CNAME)
+		if !ok {
+			continue
+		}
+		links = append(links, CNAMELink{
+			Name:   normalizeName(cname.Hdr.Name),
+			Target: normalizeName(cname.Target),
+			TTL:    cname.Hdr.Ttl,
+		})
+		if cname.Hdr.Ttl < ttl {
+			ttl = cname.Hdr.Ttl
+		}
+	}
+	return links, ttl
+}
+
+// updateChain records the CNAME chain of the last answer and refreshes at once other hosts resolved
+// through the names the chain went or goes through if the chain or the addresses changed,
+// so aliases sharing a target follow its changes together instead of waiting for their TTLs
+func (h *host) updateChain(links []CNAMELink, changed bool) {
+	old := h.chain.set(links)
+	if h.cnames == nil {
+		return
+	}
+	var names []string
+	if !sameChain(old, links) {
+		h.cnames.update(h, old, links)
+		for _, link := range old {
+			names = append(names, link.Target)
+		}
+		changed = true
+	}
+	if changed {
+		names = append(names, normalizeName(h.hostName))
+		for _, link := range links {
+			names = append(names, link.Target)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	now := h.clock.Now()
+	for _, dep := range h.cnames.dependents(h, names) {
+		logInfo(h.logger, h.tag, "Refreshing host", dep.logName(), "resolved through the changed alias of", h.logName())
+		dep.scheduler.reschedule(dep, FamilyAll, now)
+	}
+}
+
+// sameChain reports whether chains go through the same names
+func sameChain(a, b []CNAMELink) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Target != b[i].Target {
+			return false
+		}
+	}
+	return true
+}
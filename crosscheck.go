@@ -0,0 +1,166 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+)
+
+// CrossCheck - settings of the cross-check mode, see WithCrossCheck
+type CrossCheck struct {
+	// Nameservers - nameservers compared with the one answered, all nameservers of the resolving
+	// client are compared if empty
+	Nameservers []string
+
+	// PreferIntersection - on divergence keep only addresses returned by all nameservers answered,
+	// the answer of the first nameserver is kept if there are no such addresses
+	PreferIntersection bool
+
+	// OnDivergence - if set, it is called with divergent answers, synchronously with the resolution
+	OnDivergence func(d Divergence)
+}
+
+// CrossCheckAnswer - addresses returned by a nameserver
+type CrossCheckAnswer struct {
+	Nameserver string
+	IP4        []net.IP
+	IP6        []net.IP
+}
+
+// Divergence - different sets of addresses returned by nameservers for a host
+type Divergence struct {
+	Host string
+
+	// Answers - answers of the nameservers answered, the first one is used for the host
+	// unless PreferIntersection is set
+	Answers []CrossCheckAnswer
+}
+
+// crossChecker - the cross-check mode state of a resolver
+type crossChecker struct {
+	mu sync.RWMutex
+	cc *CrossCheck
+
+	// divergences - the number of divergent answers found
+	divergences uint64
+}
+
+// get ...
+func (c *crossChecker) get() *CrossCheck {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cc
+}
+
+// WithCrossCheck - enables the cross-check mode: every resolution of a host is repeated with other
+// nameservers and answers are compared regardless of the order of addresses, divergent answers
+// are logged, counted in Stats and passed to OnDivergence. Useful to detect DNS hijacking, it multiplies
+// the number of queries. Answers required to be DNSSEC-validated are not cross-checked
+func (r *Resolver) WithCrossCheck(cc CrossCheck) *Resolver {
+	cc.Nameservers = parseNameServers(r.tag, cc.Nameservers, r.logger)
+	r.clientCfg.crossCheck.mu.Lock()
+	defer r.clientCfg.crossCheck.mu.Unlock()
+	r.clientCfg.crossCheck.cc = &cc
+	return r
+}
+
+// crossCheck compares addresses l of host with answers of the other nameservers,
+// returns the addresses to use
+func (d *dnsClient) crossCheck(ctx context.Context, host string, family Family, l hostLookup) hostLookup {
+	cc := d.cfg.crossCheck.get()
+	if cc == nil {
+		return l
+	}
+	answered := l.src4.Nameserver
+	if answered == "" {
+		answered = l.src6.Nameserver
+	}
+	nameServers := cc.Nameservers
+	if len(nameServers) == 0 {
+		nameServers = d.getNameServers()
+	}
+
+	answers := []CrossCheckAnswer{{Nameserver: answered, IP4: l.ip4, IP6: l.ip6}}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, nServer := range nameServers {
+		if nServer == answered {
+			continue
+		}
+		wg.Add(1)
+		go func(nServer string) {
+			defer wg.Done()
+			other, err := d.dnsLookupHost(ctx, nServer, host, family, false)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			answers = append(answers, CrossCheckAnswer{Nameserver: nServer, IP4: other.ip4, IP6: other.ip6})
+			mu.Unlock()
+		}(nServer)
+	}
+	wg.Wait()
+
+	if !divergent(answers) {
+		return l
+	}
+	atomic.AddUint64(&d.cfg.crossCheck.divergences, 1)
+	logError(d.logger, d.cfg.tag, "Nameservers answered differently for host", d.cfg.privacy.redact(host), answers)
+	if cc.OnDivergence != nil {
+		cc.OnDivergence(Divergence{Host: host, Answers: answers})
+	}
+	if cc.PreferIntersection {
+		if ip4 := intersectIPs(answers, func(a CrossCheckAnswer) []net.IP { return a.IP4 }); len(ip4) > 0 {
+			l.ip4 = ip4
+		}
+		if ip6 := intersectIPs(answers, func(a CrossCheckAnswer) []net.IP { return a.IP6 }); len(ip6) > 0 {
+			l.ip6 = ip6
+		}
+	}
+	return l
+}
+
+// divergent reports whether answers contain different sets of addresses
+func divergent(answers []CrossCheckAnswer) bool {
+	first4, first6 := toAddrs(answers[0].IP4), toAddrs(answers[0].IP6)
+	for _, a := range answers[1:] {
+		if !sameAddrs(first4, toAddrs(a.IP4)) || !sameAddrs(first6, toAddrs(a.IP6)) {
+			return true
+		}
+	}
+	return false
+}
+
+// intersectIPs returns addresses selected by ipList present in all answers in the order of the first one
+func intersectIPs(answers []CrossCheckAnswer, ipList func(a CrossCheckAnswer) []net.IP) []net.IP {
+	count := make(map[netip.Addr]int)
+	for _, a := range answers {
+		seen := make(map[netip.Addr]bool)
+		for _, addr := range toAddrs(ipList(a)) {
+			if !seen[addr] {
+				seen[addr] = true
+				count[addr]++
+			}
+		}
+	}
+	var ret []net.IP
+	for _, ip := range ipList(answers[0]) {
+		if count[ipKey(ip)] == len(answers) {
+			ret = append(ret, ip)
+		}
+	}
+	return ret
+}
+
+// toAddrs ...
+func toAddrs(ipList []net.IP) []netip.Addr {
+	ret := make([]netip.Addr, 0, len(ipList))
+	for _, ip := range ipList {
+		ret = append(ret, ipKey(ip))
+	}
+	return ret
+}
@@ -0,0 +1,376 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// ddrName - the name nameservers designate encrypted resolvers at, RFC 9462
+	ddrName = "_dns.resolver.arpa."
+
+	// ddrTimeout - the timeout of the discovery of a nameserver including TLS handshakes
+	ddrTimeout = 10 * time.Second
+
+	// ddrMinTtl - the min interval of discoveries of a nameserver
+	ddrMinTtl = time.Minute
+
+	// ddrRetry - the interval of discoveries of a nameserver which designates no verified resolvers
+	ddrRetry = time.Hour
+
+	// ddrAlpnDoT, ddrPortDoT - the ALPN and the default port of DNS over TLS
+	ddrAlpnDoT = "dot"
+	ddrPortDoT = 853
+
+	// ddrPortDoH - the default port of DNS over HTTPS
+	ddrPortDoH = 443
+)
+
+var errDDRUnsupported = errors.New("designated resolver has no supported protocols")
+
+// DesignatedResolver - an encrypted resolver designated by a nameserver, see WithDDR
+type DesignatedResolver struct {
+	// Nameserver - the nameserver which designated the resolver, queries to it are sent to the resolver
+	Nameserver string
+
+	// Transport - TransportTLS or TransportHTTPS
+	Transport string
+
+	// Addr - the address the resolver is dialed at, in the ip:port form
+	Addr string
+
+	// ServerName - the name the certificate of the resolver is verified for
+	ServerName string
+
+	// Path - the path of DNS over HTTPS queries
+	Path string `json:",omitempty"`
+
+	// Expires - the time the designation is discovered again
+	Expires time.Time
+}
+
+// designated - a verified designated resolver with clients to query it
+type designated struct {
+	DesignatedResolver
+
+	dnsClient  *dns.Client
+	httpClient *http.Client
+	url        string
+	conns      *connCounts
+}
+
+// ddrEntry - the state of the discovery of a nameserver
+type ddrEntry struct {
+	des         *designated
+	expire      time.Time
+	discovering bool
+}
+
+// ddrState - the DDR mode, config is nil when it is disabled
+type ddrState struct {
+	mu     sync.RWMutex
+	config *tls.Config
+	m      map[string]*ddrEntry
+}
+
+// WithDDR - enables Discovery of Designated Resolvers (RFC 9462): each nameserver is queried for SVCB
+// records of _dns.resolver.arpa in background and queries to it are sent over DNS over TLS or
+// DNS over HTTPS to the resolver it designates, once the certificate of the resolver is verified
+// to cover the address of the nameserver. Designations are discovered again after their TTL.
+// config sets root CAs and other TLS settings, the system roots are used if it is nil
+func (r *Resolver) WithDDR(config *tls.Config) *Resolver {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	s := &r.clientCfg.ddr
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config.Clone()
+	s.m = make(map[string]*ddrEntry)
+	return r
+}
+
+// DesignatedResolvers returns verified encrypted resolvers used instead of the nameservers, see WithDDR
+func (r *Resolver) DesignatedResolvers() []DesignatedResolver {
+	s := &r.clientCfg.ddr
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var ret []DesignatedResolver
+	for _, e := range s.m {
+		if e.des != nil {
+			ret = append(ret, e.des.DesignatedResolver)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Nameserver < ret[j].Nameserver
+	})
+	return ret
+}
+
+// designated returns the resolver designated by nServer, nil if there is none. The discovery
+// of nServer is started if it was not discovered yet or the designation is expired
+func (d *dnsClient) designated(nServer string) *designated {
+	s := &d.cfg.ddr
+	now := d.cfg.clock.Now()
+
+	s.mu.RLock()
+	if s.config == nil {
+		s.mu.RUnlock()
+		return nil
+	}
+	e := s.m[nServer]
+	if e != nil && (e.discovering || now.Before(e.expire)) {
+		s.mu.RUnlock()
+		return e.des
+	}
+	s.mu.RUnlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e = s.m[nServer]
+	if e == nil {
+		e = &ddrEntry{}
+		s.m[nServer] = e
+	}
+	if !e.discovering && !now.Before(e.expire) {
+		e.discovering = true
+		go d.discover(nServer, s.config)
+	}
+	return e.des
+}
+
+// discover queries nServer for designated resolvers and keeps the first one verified
+func (d *dnsClient) discover(nServer string, config *tls.Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), ddrTimeout)
+	defer cancel()
+
+	var (
+		des *designated
+		ttl = ddrRetry
+	)
+	in, _, err := d.exchange(ctx, nServer, ddrName, dns.TypeSVCB, false)
+	if err == nil && in.Rcode == dns.RcodeSuccess {
+		var svcbs []*dns.SVCB
+		for _, rr := range in.Answer {
+			if svcb, ok := rr.(*dns.SVCB); ok && svcb.Priority > 0 {
+				svcbs = append(svcbs, svcb)
+			}
+		}
+		sort.SliceStable(svcbs, func(i, j int) bool {
+			return svcbs[i].Priority < svcbs[j].Priority
+		})
+		for _, svcb := range svcbs {
+			if des, err = d.verifyDesignated(ctx, nServer, svcb, config); err == nil {
+				ttl = time.Duration(svcb.Hdr.Ttl) * time.Second
+				break
+			}
+			logError(d.logger, d.cfg.tag, "Designated resolver is not verified:", nServer, svcb.Target, err)
+		}
+	}
+	if ttl < ddrMinTtl {
+		ttl = ddrMinTtl
+	}
+	now := d.cfg.clock.Now()
+	if des != nil {
+		des.Expires = now.Add(ttl)
+	}
+
+	s := &d.cfg.ddr
+	s.mu.Lock()
+	e := s.m[nServer]
+	prev := e.des
+	e.des, e.expire, e.discovering = des, now.Add(ttl), false
+	s.mu.Unlock()
+
+	switch {
+	case des != nil && (prev == nil || prev.Addr != des.Addr || prev.Transport != des.Transport):
+		logInfo(d.logger, d.cfg.tag, "Nameserver upgraded to designated resolver:", nServer, des.Transport, des.Addr, des.ServerName)
+	case des == nil && prev != nil:
+		logInfo(d.logger, d.cfg.tag, "Nameserver designates no verified resolvers anymore:", nServer)
+	}
+}
+
+// verifyDesignated returns the resolver of svcb if its certificate covers the address of nServer
+func (d *dnsClient) verifyDesignated(ctx context.Context, nServer string, svcb *dns.SVCB, config *tls.Config) (*designated, error) {
+	var (
+		alpn      []string
+		port      uint16
+		path      string
+		addrs     []net.IP
+		transport string
+	)
+	for _, kv := range svcb.Value {
+		switch v := kv.(type) {
+		case *dns.SVCBAlpn:
+			alpn = v.Alpn
+		case *dns.SVCBPort:
+			port = v.Port
+		case *dns.SVCBDoHPath:
+			path = v.Template
+		case *dns.SVCBIPv4Hint:
+			addrs = append(addrs, v.Hint...)
+		case *dns.SVCBIPv6Hint:
+			addrs = append(addrs, v.Hint...)
+		}
+	}
+	var protos []string
+	for _, proto := range alpn {
+		switch {
+		case proto == ddrAlpnDoT && transport == "":
+			transport, protos = TransportTLS, []string{ddrAlpnDoT}
+		case (proto == "h2" || proto == "http/1.1") && path != "" && transport != TransportTLS:
+			transport = TransportHTTPS
+			protos = append(protos, proto)
+		}
+	}
+	if transport == "" {
+		return nil, errDDRUnsupported
+	}
+	if port == 0 {
+		port = ddrPortDoT
+		if transport == TransportHTTPS {
+			port = ddrPortDoH
+		}
+	}
+
+	serverName := strings.TrimSuffix(svcb.Target, ".")
+	if serverName == "" {
+		return nil, errors.New("designated resolver has no name")
+	}
+	if len(addrs) == 0 {
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			in, _, err := d.exchange(ctx, nServer, svcb.Target, qtype, false)
+			if err != nil {
+				continue
+			}
+			for _, rr := range in.Answer {
+				switch a := rr.(type) {
+				case *dns.A:
+					addrs = append(addrs, a.A)
+				case *dns.AAAA:
+					addrs = append(addrs, a.AAAA)
+				}
+			}
+		}
+	}
+
+	nsHost, _, err := net.SplitHostPort(nameServerAddr(nServer))
+	if err != nil {
+		return nil, err
+	}
+	cfg := config.Clone()
+	cfg.ServerName = serverName
+	cfg.NextProtos = protos
+
+	err = fmt.Errorf("designated resolver %s has no addresses", serverName)
+	for _, ip := range addrs {
+		addr := net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+		if err = verifyDesignatedCert(ctx, addr, nsHost, cfg); err != nil {
+			continue
+		}
+		des := &designated{DesignatedResolver: DesignatedResolver{
+			Nameserver: nServer,
+			Transport:  transport,
+			Addr:       addr,
+			ServerName: serverName,
+		}, conns: &d.cfg.conns}
+		if transport == TransportTLS {
+			des.dnsClient = &dns.Client{Net: "tcp-tls", TLSConfig: cfg}
+			return des, nil
+		}
+		if i := strings.IndexByte(path, '{'); i >= 0 {
+			path = path[:i]
+		}
+		des.Path = path
+		des.url = "https://" + net.JoinHostPort(serverName, strconv.Itoa(int(port))) + path
+		dialer := &net.Dialer{}
+		des.httpClient = &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				conn, err := dialer.DialContext(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				return newCountedConn(conn, des.conns, TransportHTTPS), nil
+			},
+			TLSClientConfig:   cfg,
+			ForceAttemptHTTP2: true,
+			IdleConnTimeout:   90 * time.Second,
+		}}
+		return des, nil
+	}
+	return nil, err
+}
+
+// verifyDesignatedCert connects to addr and checks the certificate is valid for the name of config
+// and the address nsHost of the designating nameserver
+func verifyDesignatedCert(ctx context.Context, addr, nsHost string, config *tls.Config) error {
+	dialer := &tls.Dialer{Config: config}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return fmt.Errorf("designated resolver %s sent no certificates", addr)
+	}
+	return certs[0].VerifyHostname(nsHost)
+}
+
+// exchange sends m to the designated resolver checking the response matches the query
+func (des *designated) exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	if des.dnsClient != nil {
+		des.conns.add(TransportTLS, 1)
+		in, _, err := des.dnsClient.ExchangeContext(ctx, m, des.Addr)
+		des.conns.add(TransportTLS, -1)
+		if err != nil {
+			return nil, err
+		}
+		return checkResponse(m, in)
+	}
+
+	// the id of DNS over HTTPS queries is zero to keep them cacheable, RFC 8484
+	q := m.Copy()
+	q.Id = 0
+	body, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, des.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+	resp, err := des.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("designated resolver %s answered %s", des.url, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, dohMaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	in := new(dns.Msg)
+	if err := in.Unpack(b); err != nil {
+		return nil, err
+	}
+	in.Id = m.Id
+	return checkResponse(m, in)
+}
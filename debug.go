@@ -0,0 +1,171 @@
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DebugHandler returns an http.Handler exposing resolver internals:
+//
+//	/wire?host=<name>[&format=text] - captured exchanges for a host, see WithWireCapture
+//	/stats - resolver statistics including goroutines, pending queries and connections, see Stats
+//	/diagnostics - goroutines, the scheduler state and refreshes of hosts as text, see DiagnosticsDump
+//	/names?hash=<hash> - the name of a hash written with NamesHashed, see UnhashName
+//	/nameservers - statistics of nameservers, see NameserverStats
+//	/hosts - states of maintained hosts, see HostStats
+//	/zone - addresses of all hosts as zone file records, see DumpZone
+//	/export?host=<name> - records of a host as zone file text, see ExportRRs
+//	/history?host=<name> - the last changes of addresses of a host, see WithHistory
+//	/store/queries, /store/changes?[host=<name>][&since=<RFC3339>][&until=<RFC3339>][&limit=<n>] -
+//	query logs and address changes kept by the store set by WithHistoryStore
+func (r *Resolver) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/wire", r.debugWire)
+	mux.HandleFunc("/history", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, r.History(req.URL.Query().Get("host")))
+	})
+	mux.HandleFunc("/store/queries", r.debugStore)
+	mux.HandleFunc("/store/changes", r.debugStore)
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, r.Stats())
+	})
+	mux.HandleFunc("/diagnostics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		r.DiagnosticsDump(w)
+	})
+	mux.HandleFunc("/names", func(w http.ResponseWriter, req *http.Request) {
+		name, ok := r.UnhashName(req.URL.Query().Get("hash"))
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		writeJSON(w, name)
+	})
+	mux.HandleFunc("/nameservers", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, r.NameserverStats())
+	})
+	mux.HandleFunc("/hosts", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, r.HostStats())
+	})
+	mux.HandleFunc("/zone", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		r.DumpZone(w)
+	})
+	mux.HandleFunc("/export", func(w http.ResponseWriter, req *http.Request) {
+		rrs := r.ExportRRs(req.URL.Query().Get("host"))
+		if rrs == nil {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, rr := range rrs {
+			rr.Header().Name = r.clientCfg.privacy.redact(rr.Header().Name)
+			if cname, ok := rr.(*dns.CNAME); ok {
+				cname.Target = r.clientCfg.privacy.redact(cname.Target)
+			}
+			fmt.Fprintln(w, rr)
+		}
+	})
+	return mux
+}
+
+// debugWire ...
+func (r *Resolver) debugWire(w http.ResponseWriter, req *http.Request) {
+	exchanges := r.WireCapture(req.URL.Query().Get("host"))
+
+	if req.URL.Query().Get("format") != "text" {
+		writeJSON(w, exchanges)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, ex := range exchanges {
+		fmt.Fprintf(w, ";; %s %s %s\n", ex.Time.Format("2006-01-02T15:04:05.000Z07:00"), ex.Transport, ex.Nameserver)
+		for _, b := range [][]byte{ex.Request, ex.Response} {
+			if b == nil {
+				continue
+			}
+			m := new(dns.Msg)
+			if err := m.Unpack(b); err != nil {
+				fmt.Fprintf(w, ";; unpack error: %s\n", err)
+				continue
+			}
+			fmt.Fprintln(w, m.String())
+		}
+		if ex.Error != "" {
+			fmt.Fprintf(w, ";; error: %s\n", ex.Error)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// debugStore ...
+func (r *Resolver) debugStore(w http.ResponseWriter, req *http.Request) {
+	r.mu.RLock()
+	store := r.store
+	r.mu.RUnlock()
+	if store == nil {
+		http.Error(w, "no history store", http.StatusNotFound)
+		return
+	}
+
+	q, err := parseHistoryQuery(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// records keep names written per WithNamePrivacy
+	if q.Host != "" {
+		q.Host = r.clientCfg.privacy.redact(q.Host)
+	}
+	var v interface{}
+	if strings.HasSuffix(req.URL.Path, "/queries") {
+		v, err = store.Queries(q)
+	} else {
+		v, err = store.Changes(q)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, v)
+}
+
+// parseHistoryQuery ...
+func parseHistoryQuery(values url.Values) (HistoryQuery, error) {
+	q := HistoryQuery{Host: values.Get("host")}
+	var err error
+	if s := values.Get("since"); s != "" {
+		if q.Since, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, fmt.Errorf("invalid since: %w", err)
+		}
+	}
+	if s := values.Get("until"); s != "" {
+		if q.Until, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, fmt.Errorf("invalid until: %w", err)
+		}
+	}
+	if s := values.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil {
+			return q, fmt.Errorf("invalid limit: %w", err)
+		}
+	}
+	return q, nil
+}
+
+// writeJSON ...
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,103 @@
+package resolver
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// DefaultTag - the tag of the default resolver created by Default
+const DefaultTag = "default"
+
+var (
+	defaultOnce     sync.Once
+	defaultResolver *Resolver
+)
+
+// SetDefault - sets the resolver returned by Default and the package-level functions.
+// Only the first call of SetDefault or Default has effect, returns false if the default resolver is already set
+func SetDefault(r *Resolver) bool {
+	set := false
+	defaultOnce.Do(func() {
+		defaultResolver = r
+		set = true
+	})
+	return set
+}
+
+// Default returns the process-wide resolver, if SetDefault was not called it is created
+// on the first call using the system resolver and no logging
+func Default() *Resolver {
+	defaultOnce.Do(func() {
+		defaultResolver = New(DefaultTag, nil)
+	})
+	return defaultResolver
+}
+
+// AddHost adds a host to maintaining by the default resolver
+func AddHost(hostName string) {
+	Default().AddHost(hostName)
+}
+
+// AddHostAwait adds a host to maintaining by the default resolver and waits for its addresses,
+// see Resolver.AddHostAwait
+func AddHostAwait(ctx context.Context, hostName string) ([]net.IP, []net.IP, error) {
+	return Default().AddHostAwait(ctx, hostName)
+}
+
+// WrapTransport returns rt dialing through the default resolver, see Resolver.WrapTransport
+func WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	return Default().WrapTransport(rt)
+}
+
+// DelHost deletes a host from maintaining by the default resolver
+func DelHost(hostName string) {
+	Default().DelHost(hostName)
+}
+
+// GetNextIP returns next IPv4 for host with name hostName from the default resolver
+func GetNextIP(hostName string, opts ...QueryOption) string {
+	return Default().GetNextIP(hostName, opts...)
+}
+
+// GetNextIPWithIdx returns next IPv4 and index for host with name hostName from the default resolver
+func GetNextIPWithIdx(hostName string, opts ...QueryOption) (string, int) {
+	return Default().GetNextIPWithIdx(hostName, opts...)
+}
+
+// GetNextIP6 returns next IPv6 for host with name hostName from the default resolver
+func GetNextIP6(hostName string, opts ...QueryOption) string {
+	return Default().GetNextIP6(hostName, opts...)
+}
+
+// GetNextIP6WithIdx returns next IPv6 and index for host with name hostName from the default resolver
+func GetNextIP6WithIdx(hostName string, opts ...QueryOption) (string, int) {
+	return Default().GetNextIP6WithIdx(hostName, opts...)
+}
+
+// GetNextHostPort returns next IPv4 for host of hostPort with the port from the default resolver
+func GetNextHostPort(hostPort string, opts ...QueryOption) string {
+	return Default().GetNextHostPort(hostPort, opts...)
+}
+
+// GetIPs returns a list of IPv4 and IPv6 from the default resolver
+func GetIPs(hostName string) ([]net.IP, []net.IP) {
+	return Default().GetIPs(hostName)
+}
+
+// GetIPsStr returns a string list of IPv4 and IPv6 from the default resolver
+func GetIPsStr(hostName string) ([]string, []string) {
+	return Default().GetIPsStr(hostName)
+}
+
+// LookupSRV makes LookupSRV request using the default resolver
+func LookupSRV(service, proto, name string) (string, []*net.SRV, error) {
+	return Default().LookupSRV(service, proto, name)
+}
+
+// Dump dumps into writer all hosts of the default resolver with theirs ips
+func Dump(w io.Writer) {
+	Default().Dump(w)
+}
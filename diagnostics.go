@@ -0,0 +1,173 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// goroutines - long-running goroutines of a resolver by role, each one runs with pprof labels
+// "resolver" (the tag), "role" and "host" if it serves a host, so profiles and gops stacks show them
+type goroutines struct {
+	tag string
+
+	mu    sync.Mutex
+	roles map[string]int
+}
+
+// newGoroutines ...
+func newGoroutines(tag string) *goroutines {
+	return &goroutines{tag: tag, roles: make(map[string]int)}
+}
+
+// spawn runs fn on a new goroutine labeled with role and hostName, hostName may be empty.
+// Goroutines it starts inherit the labels
+func (g *goroutines) spawn(role, hostName string, fn func()) {
+	labels := pprof.Labels("resolver", g.tag, "role", role)
+	if hostName != "" {
+		labels = pprof.Labels("resolver", g.tag, "role", role, "host", hostName)
+	}
+	g.mu.Lock()
+	g.roles[role]++
+	g.mu.Unlock()
+	go pprof.Do(context.Background(), labels, func(context.Context) {
+		defer func() {
+			g.mu.Lock()
+			g.roles[role]--
+			g.mu.Unlock()
+		}()
+		fn()
+	})
+}
+
+// counts returns the numbers of running goroutines by role
+func (g *goroutines) counts() map[string]int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ret := make(map[string]int, len(g.roles))
+	for role, n := range g.roles {
+		if n > 0 {
+			ret[role] = n
+		}
+	}
+	return ret
+}
+
+// hostDiagnostics - the refresh state of a host
+type hostDiagnostics struct {
+	name      string
+	h         *host
+	scheduled []string
+	running   []string
+	suspended int
+}
+
+// DiagnosticsDump writes a text report for diagnosing hangs: goroutines of the resolver by role,
+// the state of the scheduler and for every host its readiness, scheduled, running and suspended
+// refreshes, the last refresh, expiration of addresses and the last error. Goroutines are labeled
+// with pprof labels resolver, role and host, so the goroutine profile shows what they serve
+func (r *Resolver) DiagnosticsDump(w io.Writer) {
+	s := r.scheduler
+	now := r.clock.Now()
+
+	fmt.Fprintf(w, "resolver %q at %s, stopped: %t\n", r.tag, now.Format(time.RFC3339), r.Stopped())
+
+	fmt.Fprintln(w, "\ngoroutines:")
+	roles := s.goroutines.counts()
+	names := make([]string, 0, len(roles))
+	for role := range roles {
+		names = append(names, role)
+	}
+	sort.Strings(names)
+	for _, role := range names {
+		fmt.Fprintf(w, "  %s: %d\n", role, roles[role])
+	}
+
+	r.mu.RLock()
+	hosts := make([]*host, 0, len(r.hosts))
+	for _, h := range r.hosts {
+		hosts = append(hosts, h)
+	}
+	r.mu.RUnlock()
+
+	diags := make([]hostDiagnostics, 0, len(hosts))
+	byHost := make(map[*host]*hostDiagnostics, len(hosts))
+	s.mu.Lock()
+	queued, due, running, workers, paused := len(s.queue), len(s.due), s.running, s.workers, s.paused
+	for _, h := range hosts {
+		d := hostDiagnostics{name: h.logName(), h: h, suspended: len(h.suspended)}
+		for _, t := range h.tasks {
+			state := "at " + t.at.Format(time.RFC3339)
+			if t.due {
+				state = "due since " + t.at.Format(time.RFC3339)
+			}
+			d.scheduled = append(d.scheduled, t.describe()+" "+state)
+		}
+		diags = append(diags, d)
+	}
+	for i := range diags {
+		byHost[diags[i].h] = &diags[i]
+	}
+	for t, start := range s.active {
+		if d, ok := byHost[t.h]; ok {
+			d.running = append(d.running, fmt.Sprintf("%s for %s", t.describe(), now.Sub(start).Round(time.Millisecond)))
+		}
+	}
+	s.mu.Unlock()
+
+	fmt.Fprintln(w, "\nscheduler:")
+	fmt.Fprintf(w, "  paused: %t, queued: %d, due: %d, running: %d/%d, waiters: %d, refreshes: %d, suspensions: %d\n",
+		paused, queued, due, running, workers, atomic.LoadInt64(&s.waiters), s.getRefreshes(), s.getSuspensions())
+
+	sort.Slice(diags, func(i, j int) bool {
+		return diags[i].name < diags[j].name
+	})
+	fmt.Fprintf(w, "\nhosts: %d\n", len(diags))
+	for _, d := range diags {
+		h := d.h
+		fmt.Fprintf(w, "  %s: ready: %t, explicit: %t, static: %t, class: %s\n",
+			d.name, h.isReady(), h.isExplicitlyAdded(), h.static, h.getClass())
+		if h.static {
+			continue
+		}
+		if t := atomic.LoadInt64(&h.refreshTime); t > 0 {
+			fmt.Fprintf(w, "    last refresh: %s ago\n", now.Sub(time.Unix(t, 0)).Round(time.Second))
+		}
+		for _, family := range []Family{FamilyV4, FamilyV6} {
+			if e := h.expiry(family); !e.IsZero() {
+				fmt.Fprintf(w, "    %s expires in %s\n", family, e.Sub(now).Round(time.Second))
+			}
+		}
+		for _, t := range d.scheduled {
+			fmt.Fprintf(w, "    scheduled: %s\n", t)
+		}
+		for _, t := range d.running {
+			fmt.Fprintf(w, "    running: %s\n", t)
+		}
+		if d.suspended > 0 {
+			fmt.Fprintf(w, "    suspended: %d\n", d.suspended)
+		}
+		if err := h.getErr(); err != nil {
+			fmt.Fprintf(w, "    error: %v\n", h.logErr(err))
+		}
+	}
+}
+
+// describe returns what the task refreshes
+func (t *refreshTask) describe() string {
+	what := t.family.String()
+	if t.qtype != dns.TypeNone {
+		what = dns.TypeToString[t.qtype]
+	}
+	if t.initial {
+		what += " initial"
+	}
+	return what
+}
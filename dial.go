@@ -0,0 +1,166 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// errNoAddresses ...
+var errNoAddresses = errors.New("no addresses")
+
+// WithDialAutoAdd - makes hosts dialed by DialContext at least threshold times explicitly added,
+// so they are refreshed proactively and never deleted as old. Zero disables promotion
+func (r *Resolver) WithDialAutoAdd(threshold int) *Resolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dialAutoAdd = uint64(threshold)
+	return r
+}
+
+// DialContext connects to the address on the named network like net.Dialer.DialContext resolving
+// the host through the cache. Addresses are tried in rotation order, IPv4 first for "tcp" and "udp",
+// addresses marked bad are skipped unless all of them are bad. Waiting for the first resolution
+// of the host stops when ctx is done
+func (r *Resolver) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return r.dial(ctx, network, address, 0)
+}
+
+// dial is DialContext marking addresses failed to connect bad for cooldown, zero cooldown disables marking
+func (r *Resolver) dial(ctx context.Context, network, address string, cooldown time.Duration) (net.Conn, error) {
+	hostName, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var d net.Dialer
+	if net.ParseIP(hostName) != nil {
+		return d.DialContext(ctx, network, address)
+	}
+
+	// the pipeline waits for the first resolution of the host until ctx is done
+	l := apiLookup(hostName, dns.TypeNone)
+	l.sourceOnly = true
+	res := r.resolve(ctx, l)
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	var addrs []net.IPAddr
+	h := res.host
+	switch {
+	case h != nil:
+		r.countDial(hostName, h)
+		addrs = h.dialAddrs(network)
+	case res.rewrite != nil:
+		addrs = familyAddrs(network, res.rewrite.ip4.getAddrList(), res.rewrite.ip6.getAddrList())
+	default:
+		addrs = familyAddrs(network, ipAddrs(res.ip4), ipAddrs(res.ip6))
+	}
+
+	lastErr := error(&net.AddrError{Err: errNoAddresses.Error(), Addr: hostName})
+	for _, addr := range addrs {
+		start := r.clock.Now()
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(addr.String(), port))
+		if err == nil {
+			r.addrRTTs.observe(ipKey(addr.IP).WithZone(addr.Zone), r.clock.Now().Sub(start))
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+		if cooldown > 0 && h != nil && h.markBad(addr.IP, cooldown) {
+			logInfo(r.logger, r.tag, "Address failed to connect, marked bad:", r.clientCfg.privacy.redact(hostName), addr.String(),
+				r.clientCfg.privacy.redactErr(err, hostName))
+		}
+	}
+	return nil, lastErr
+}
+
+// MarkIPBad removes ip of host with name hostName from rotation for cooldown, GetNextIP* skip it
+// unless all the host addresses are bad. The mark survives refreshes, reports whether the host has the address
+func (r *Resolver) MarkIPBad(hostName, ip string, cooldown time.Duration) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+
+	r.mu.RLock()
+	h := r.hosts[hostName]
+	r.mu.RUnlock()
+
+	if h == nil {
+		return false
+	}
+	return h.markBad(addr, cooldown)
+}
+
+// countDial counts a dial to the host promoting it to explicitly added above the threshold
+func (r *Resolver) countDial(hostName string, h *host) {
+	r.mu.RLock()
+	threshold := r.dialAutoAdd
+	r.mu.RUnlock()
+
+	if dials := h.countDial(); threshold > 0 && dials >= threshold && h.promote() {
+		logInfo(r.logger, r.tag, "Host made explicit after dials:", r.clientCfg.privacy.redact(hostName), dials)
+	}
+}
+
+// dialFamilies returns families of addresses to dial for network in order
+func dialFamilies(network string) []Family {
+	switch network {
+	case "tcp4", "udp4", "ip4":
+		return []Family{FamilyV4}
+	case "tcp6", "udp6", "ip6":
+		return []Family{FamilyV6}
+	}
+	return []Family{FamilyV4, FamilyV6}
+}
+
+// familyAddrs returns addresses to dial for network of the ones not served by a host
+func familyAddrs(network string, ip4, ip6 []net.IPAddr) []net.IPAddr {
+	var ret []net.IPAddr
+	for _, family := range dialFamilies(network) {
+		if family == FamilyV4 {
+			ret = append(ret, ip4...)
+		} else {
+			ret = append(ret, ip6...)
+		}
+	}
+	return ret
+}
+
+// dialAddrs returns addresses to dial for network, each family starting at its next rotation index.
+// Addresses marked bad are skipped unless all of them are bad like with getNextIPWithIndex
+func (h *host) dialAddrs(network string) []net.IPAddr {
+	h.resume()
+	ip4, ip6 := h.getIPAddrs()
+	now := h.clock.Now()
+	var ret, bad []net.IPAddr
+	for _, family := range dialFamilies(network) {
+		list := ip4
+		if family == FamilyV6 {
+			list = ip6
+		}
+		if len(list) == 0 {
+			continue
+		}
+		_, idx := h.getNextIPWithIndex(family, false)
+		for i := range list {
+			addr := list[(idx+i)%len(list)]
+			if h.ip4.isMarkedBad(addr.IP, now) || h.ip6.isMarkedBad(addr.IP, now) {
+				bad = append(bad, addr)
+				continue
+			}
+			ret = append(ret, addr)
+		}
+	}
+	if len(ret) == 0 {
+		return bad
+	}
+	return ret
+}
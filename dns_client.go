@@ -2,7 +2,8 @@ package resolver
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
 	"math"
 	"net"
 	"strings"
@@ -13,16 +14,35 @@ import (
 	"github.com/miekg/dns"
 	logApi "github.com/ndmsystems/go/api/log"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
-	defaultTtl = 60 // 60 sec
+	defaultTtl   = 60 // 60 sec
+	retryBackoff = 100 * time.Millisecond
+	ednsBufSize  = 1232
+
+	// maxResponseRRs - the max number of records accepted in a response
+	maxResponseRRs = 512
+)
+
+var (
+	// ErrDNSSECInsecure - an answer for a host requiring DNSSEC was not validated
+	ErrDNSSECInsecure = errors.New("dnssec: insecure answer")
+
+	// ErrDNSSECBogus - an answer for a host requiring DNSSEC failed validation
+	ErrDNSSECBogus = errors.New("dnssec: bogus answer")
+
+	errNoNameServers    = errors.New("no nameservers configured")
+	errQuestionMismatch = errors.New("response question does not match the query")
+	errTooManyRecords   = errors.New("too many records in response")
+	errServerFailure    = errors.New("nameserver answered SERVFAIL or REFUSED")
 )
 
 // iDnsClient ...
 type iDnsClient interface {
 	setNameServers(nameServers []string)
-	lookupHost(ctx context.Context, host string) ([]net.IP, []net.IP, uint32, error)
+	lookupHost(ctx context.Context, host string, family Family, secure bool) (hostLookup, error)
 }
 
 // dnsClient ...
@@ -31,84 +51,317 @@ type dnsClient struct {
 	nsCounter   uint64
 	nameServers []string
 	logger      logApi.Logger
+	cfg         *clientConfig
+	flight      singleflight.Group
+}
+
+// clientConfig - settings shared by all dns clients of a resolver
+type clientConfig struct {
+	// hooks - functions called around upstream queries
+	hooks lookupHooks
+
+	// retries - the number of extra attempts to the same nameserver before trying the next one
+	retries int32
+
+	// ednsFallbacks - the number of queries repeated without EDNS on FORMERR or NOTIMP
+	ednsFallbacks uint64
+
+	// tcpFallbacks - the number of queries repeated over TCP on truncated or suspicious UDP responses
+	tcpFallbacks uint64
+
+	// unexpectedAnswers - the number of answers rejected by Policy.ExpectedPrefixes
+	unexpectedAnswers uint64
+
+	// tag - the tag of the resolver
+	tag string
+
+	// clock - a source of time
+	clock Clock
+
+	// qlog - the query log
+	qlog *queryLog
+
+	// wire - captured raw exchanges
+	wire *wireCapture
+
+	// nsStats - query statistics per nameserver
+	nsStats nameserverStats
+
+	// outage - failures tracking of the outage mode
+	outage outage
+
+	// strategy - the order nameservers are tried in
+	strategy NameserverStrategy
+
+	// rnd - the random source of the resolver, see WithRandSource
+	rnd lockedRand
+
+	// crossCheck - the cross-check mode
+	crossCheck crossChecker
+
+	// merge - how answers of all nameservers are merged, see WithAnswerMerge
+	merge MergeStrategy
+
+	// llmnr - set to 1 when LLMNR is used for single-label names nameservers do not resolve
+	llmnr int32
+
+	// netbios - set to 1 when NetBIOS-NS is used for single-label names nameservers and LLMNR do not resolve
+	netbios int32
+
+	// ddr - encrypted resolvers designated by the nameservers, see WithDDR
+	ddr ddrState
+
+	// privacy - how host names are logged, see WithNamePrivacy
+	privacy namePrivacy
+
+	// conns - open upstream connections per transport
+	conns connCounts
+
+	// pending - the number of upstream queries in flight
+	pending int64
+
+	// anchors - trust anchors of the resolver, queries for names under a negative trust anchor
+	// are sent with the CD bit
+	anchors *trustAnchors
+
+	// sysResolver - a resolver of the system used when no nameservers are set,
+	// it is not shared with other resolvers
+	sysResolver *net.Resolver
+}
+
+// getRetries ...
+func (c *clientConfig) getRetries() int {
+	return int(atomic.LoadInt32(&c.retries))
 }
 
 // newDnsClient ...
-func newDnsClient(logger logApi.Logger) *dnsClient {
+func newDnsClient(logger logApi.Logger, cfg *clientConfig) *dnsClient {
 	return &dnsClient{
 		logger: logger,
+		cfg:    cfg,
 	}
 }
 
 // setNameServers ...
 func (d *dnsClient) setNameServers(nameServers []string) {
-	ns := parseNameServers(nameServers)
+	ns := parseNameServers(d.cfg.tag, nameServers, d.logger)
 	d.Lock()
 	defer d.Unlock()
 	d.nameServers = ns
 }
 
-// lookupHost ...
-func (d *dnsClient) lookupHost(ctx context.Context, host string) ([]net.IP, []net.IP, uint32, error) {
+// getNameServers returns a copy of the nameservers
+func (d *dnsClient) getNameServers() []string {
+	d.RLock()
+	defer d.RUnlock()
+	return append([]string(nil), d.nameServers...)
+}
+
+// hostLookup - a result of lookupHost
+type hostLookup struct {
+	ip4, ip6   []net.IP
+	ttl4, ttl6 uint32
+
+	// src4, src6 - where IPv4 and IPv6 addresses were obtained from
+	src4, src6 Source
+
+	// chain - CNAME records the answer went through, their TTLs limit ttl4 and ttl6
+	chain []CNAMELink
+
+	// nxdomain - the name does not exist, an answer was NXDOMAIN or the system resolver found no host
+	nxdomain bool
+}
+
+// lookupHost returns IPv4 and IPv6 addresses of host of family, their ttls and sources,
+// if secure is set only answers validated by a DNSSEC-aware nameserver are accepted
+func (d *dnsClient) lookupHost(ctx context.Context, host string, family Family, secure bool) (hostLookup, error) {
 	d.RLock()
 	nsCnt := len(d.nameServers)
 	d.RUnlock()
 
+	l := hostLookup{ttl4: defaultTtl, ttl6: defaultTtl}
+	if nsCnt == 0 && secure {
+		return l, ErrDNSSECInsecure
+	}
 	if nsCnt == 0 {
-		ips := make(map[bool][]net.IP)
-		addrs, err := net.LookupHost(host)
+		d.cfg.hooks.callBefore(host, dns.TypeNone)
+		start := time.Now()
+		addrs, err := d.cfg.sysResolver.LookupHost(ctx, host)
+		d.cfg.hooks.callAfter(host, dns.TypeNone, nil, err, time.Since(start))
 		if err != nil {
-			return nil, nil, defaultTtl, nil
+			// the system resolver does not tell a name without addresses from a nonexistent one
+			l.nxdomain = true
+			return l, nil
 		}
 		for _, addr := range addrs {
 			if netIP := net.ParseIP(addr); netIP != nil {
 				isV6 := strings.Contains(addr, ":")
-				ips[isV6] = append(ips[isV6], netIP)
+				if (isV6 && !family.hasV6()) || (!isV6 && !family.hasV4()) {
+					continue
+				}
+				if isV6 {
+					l.ip6 = append(l.ip6, netIP)
+				} else {
+					l.ip4 = append(l.ip4, netIP)
+				}
 			}
 		}
-		return ips[false], ips[true], defaultTtl, nil
+		l.src4 = Source{Transport: TransportSystem, Time: d.cfg.clock.Now()}
+		l.src6 = l.src4
+		return l, nil
+	}
+
+	var err error
+	if merge := d.cfg.getMerge(); merge != MergeNone && nsCnt > 1 && !secure {
+		l, err = d.mergeLookupHost(ctx, host, family, merge)
+	} else {
+		err = d.tryNameServers(ctx, func(nServer string) (err error) {
+			l, err = d.dnsLookupHost(ctx, nServer, host, family, secure)
+			return err
+		})
+		if err == nil && !secure {
+			l = d.crossCheck(ctx, host, family, l)
+		}
+	}
+	if len(l.ip4) == 0 && len(l.ip6) == 0 && !secure && d.cfg.useLLMNR(host) {
+		if ll, ok := d.llmnrLookupHost(ctx, host, family); ok {
+			return ll, nil
+		}
+	}
+	if len(l.ip4) == 0 && len(l.ip6) == 0 && !secure && family.hasV4() && d.cfg.useNetBIOS(host) {
+		if nl, ok := d.netbiosLookupHost(ctx, host); ok {
+			return nl, nil
+		}
 	}
 
+	return l, err
+}
+
+// lookupHostShared is lookupHost with concurrent lookups of the same host, family and
+// security requirement joined into one upstream resolution, the returned slices are shared
+// between the callers and must not be modified
+func (d *dnsClient) lookupHostShared(ctx context.Context, host string, family Family, secure bool) (hostLookup, error) {
+	key := fmt.Sprintf("%s/%d/%t", host, family, secure)
+	v, err, _ := d.flight.Do(key, func() (interface{}, error) {
+		return d.lookupHost(ctx, host, family, secure)
+	})
+	return v.(hostLookup), err
+}
+
+// lookupRecords returns answer records of qtype for qname, their minimal ttl and source
+func (d *dnsClient) lookupRecords(ctx context.Context, qname string, qtype uint16) ([]dns.RR, uint32, Source, error) {
 	var (
-		ip4, ip6 []net.IP
-		ttl      uint32
-		err      error
+		rrs []dns.RR
+		ttl uint32
+		src Source
 	)
+	err := d.tryNameServers(ctx, func(nServer string) error {
+		ctx, cancel := context.WithTimeout(ctx, d.queryTimeout(nServer))
+		defer cancel()
 
-	for i := 0; i < nsCnt; i++ {
-		d.RLock()
-		nsIdx := int(atomic.LoadUint64(&d.nsCounter)) % len(d.nameServers)
-		nServer := d.nameServers[nsIdx]
-		d.RUnlock()
+		in, s, err := d.exchange(ctx, nServer, qname, qtype, false)
+		if err != nil {
+			return err
+		}
+		src = s
 
-		ip4, ip6, ttl, err = d.dnsLookupHost(ctx, nServer, host)
-		if err == nil {
-			break
+		rrs, ttl = rrs[:0], math.MaxUint32
+		for _, rr := range in.Answer {
+			if rr.Header().Rrtype != qtype {
+				continue
+			}
+			rrs = append(rrs, rr)
+			if rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+		ttl = floorTtl(ttl)
+		return nil
+	})
+
+	return rrs, ttl, src, err
+}
+
+// floorTtl returns defaultTtl if ttl is less than it or unset (math.MaxUint32)
+func floorTtl(ttl uint32) uint32 {
+	if ttl < defaultTtl || ttl == math.MaxUint32 {
+		return defaultTtl
+	}
+	return ttl
+}
+
+// tryNameServers calls fn with nameservers in the order of the strategy until it succeeds,
+// with NameserverRoundRobin the nameserver which failed is skipped for the next calls.
+// Each nameserver is retried with backoff as configured by WithRetriesPerNameserver
+func (d *dnsClient) tryNameServers(ctx context.Context, fn func(nServer string) error) error {
+	nameServers := d.nameServersOrder()
+	if len(nameServers) == 0 {
+		return errNoNameServers
+	}
+
+	var err error
+	for _, nServer := range nameServers {
+		if err = d.tryNameServer(ctx, nServer, fn); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
 		}
 		atomic.AddUint64(&d.nsCounter, 1)
 	}
 
-	return ip4, ip6, ttl, err
+	return err
+}
+
+// tryNameServer calls fn with nServer until it succeeds or the retries are exhausted
+func (d *dnsClient) tryNameServer(ctx context.Context, nServer string, fn func(nServer string) error) error {
+	err := fn(nServer)
+	backoff := retryBackoff
+	for i := d.cfg.getRetries(); err != nil && i > 0; i-- {
+		select {
+		case <-ctx.Done():
+			return err
+		case <-d.cfg.clock.After(backoff):
+		}
+		backoff *= 2
+		err = fn(nServer)
+	}
+	return err
 }
 
 // dnsLookupHost ...
-func (d *dnsClient) dnsLookupHost(ctx context.Context, nServer, host string) ([]net.IP, []net.IP, uint32, error) {
-	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+func (d *dnsClient) dnsLookupHost(ctx context.Context, nServer, host string, family Family, secure bool) (hostLookup, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.queryTimeout(nServer))
 	defer cancel()
 
 	var ip4, ip6 []net.IP
 	var ttl4, ttl6 uint32 = math.MaxUint32, math.MaxUint32
+	var src4, src6 Source
+	var chain4, chain6 []CNAMELink
+	var nx4, nx6 bool
 
-	g, _ := errgroup.WithContext(ctx)
+	g, gCtx := errgroup.WithContext(ctx)
 
 	// get IPv4 addresses
 	g.Go(func() error {
-		m := new(dns.Msg)
-		m.SetQuestion(dns.Fqdn(host), dns.TypeA)
-		in, err := dns.Exchange(m, nServer+":53")
+		if !family.hasV4() {
+			return nil
+		}
+		in, src, err := d.exchange(gCtx, nServer, host, dns.TypeA, secure)
 		if err != nil {
 			return err
 		}
+		src4 = src
+		if secure {
+			if err = checkSecure(in); err != nil {
+				return err
+			}
+		} else if d.cfg.outage.enabled() && isFailure(in, nil) {
+			// keep last-known-good addresses to serve them in an outage
+			return errServerFailure
+		}
+		nx4 = in.Rcode == dns.RcodeNameError
+		chain4, ttl4 = cnameLinks(in.Answer, ttl4)
 		for _, rr := range in.Answer {
 			if dnsRec, ok := rr.(*dns.A); ok {
 				ip4 = append(ip4, dnsRec.A)
@@ -122,12 +375,24 @@ func (d *dnsClient) dnsLookupHost(ctx context.Context, nServer, host string) ([]
 
 	// get IPv6 addresses
 	g.Go(func() error {
-		m := new(dns.Msg)
-		m.SetQuestion(dns.Fqdn(host), dns.TypeAAAA)
-		in, err := dns.Exchange(m, nServer+":53")
+		if !family.hasV6() {
+			return nil
+		}
+		in, src, err := d.exchange(gCtx, nServer, host, dns.TypeAAAA, secure)
 		if err != nil {
 			return err
 		}
+		src6 = src
+		if secure {
+			if err = checkSecure(in); err != nil {
+				return err
+			}
+		} else if d.cfg.outage.enabled() && isFailure(in, nil) {
+			// keep last-known-good addresses to serve them in an outage
+			return errServerFailure
+		}
+		nx6 = in.Rcode == dns.RcodeNameError
+		chain6, ttl6 = cnameLinks(in.Answer, ttl6)
 		for _, rr := range in.Answer {
 			if dnsRec, ok := rr.(*dns.AAAA); ok {
 				ip6 = append(ip6, dnsRec.AAAA)
@@ -139,15 +404,175 @@ func (d *dnsClient) dnsLookupHost(ctx context.Context, nServer, host string) ([]
 		return nil
 	})
 
-	var ttl uint32 = defaultTtl
-	if ttl4 > defaultTtl && ttl4 != math.MaxUint32 {
-		ttl = ttl4
+	if err := g.Wait(); err != nil {
+		return hostLookup{ttl4: defaultTtl, ttl6: defaultTtl}, err
+	}
+
+	chain := chain4
+	if len(chain) == 0 {
+		chain = chain6
+	}
+	return hostLookup{
+		ip4:      ip4,
+		ip6:      ip6,
+		ttl4:     floorTtl(ttl4),
+		ttl6:     floorTtl(ttl6),
+		src4:     src4,
+		src6:     src6,
+		chain:    chain,
+		nxdomain: nx4 || nx6,
+	}, nil
+}
+
+// query sends a query for name and qtype to the nameservers until one of them answers,
+// dnssecOK sets the DO bit to request DNSSEC records
+func (d *dnsClient) query(ctx context.Context, name string, qtype uint16, dnssecOK bool) (*dns.Msg, error) {
+	m := d.newQuery(name, qtype, dnssecOK)
+
+	var in *dns.Msg
+	err := d.tryNameServers(ctx, func(nServer string) (err error) {
+		ctx, cancel := context.WithTimeout(ctx, d.queryTimeout(nServer))
+		defer cancel()
+		in, _, err = d.exchangeMsg(ctx, nServer, m)
+		return err
+	})
+	return in, err
+}
+
+// exchange sends a query for name and qtype to the nameserver nServer,
+// dnssecOK sets the DO bit to request DNSSEC records
+func (d *dnsClient) exchange(ctx context.Context, nServer, name string, qtype uint16, dnssecOK bool) (*dns.Msg, Source, error) {
+	return d.exchangeMsg(ctx, nServer, d.newQuery(name, qtype, dnssecOK))
+}
+
+// newQuery returns a query for name and qtype, the CD bit is set for names under a negative trust anchor
+// so validating nameservers answer them even if validation fails
+func (d *dnsClient) newQuery(name string, qtype uint16, dnssecOK bool) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.SetEdns0(ednsBufSize, dnssecOK)
+	m.CheckingDisabled = d.cfg.anchors != nil && d.cfg.anchors.isNegative(name)
+	return m
+}
+
+// checkSecure returns an error if the response was not validated by the nameserver
+func checkSecure(in *dns.Msg) error {
+	if in.Rcode == dns.RcodeServerFailure {
+		return ErrDNSSECBogus
+	}
+	if !in.AuthenticatedData {
+		return ErrDNSSECInsecure
+	}
+	return nil
+}
+
+// exchangeMsg sends m to the nameserver nServer, returns the response and its source
+func (d *dnsClient) exchangeMsg(ctx context.Context, nServer string, m *dns.Msg) (*dns.Msg, Source, error) {
+	name, qtype := m.Question[0].Name, m.Question[0].Qtype
+
+	d.cfg.hooks.callBefore(name, qtype)
+	atomic.AddInt64(&d.cfg.pending, 1)
+	start := time.Now()
+	var (
+		in        *dns.Msg
+		transport string
+		err       error
+	)
+	if des := d.designated(nServer); des != nil {
+		transport = des.Transport
+		in, err = des.exchange(ctx, m)
+	} else {
+		in, transport, err = d.exchangeWithFallback(ctx, nameServerAddr(nServer), m)
+	}
+	rtt := time.Since(start)
+	atomic.AddInt64(&d.cfg.pending, -1)
+	d.cfg.hooks.callAfter(name, qtype, in, err, rtt)
+	d.recordQuery(nServer, in, err, rtt)
+	d.cfg.qlog.logQuery(d.cfg.tag, nServer, transport, m, in, err, rtt)
+	d.cfg.wire.capture(nServer, transport, m, in, err)
+
+	return in, Source{Nameserver: nServer, Transport: transport, Time: d.cfg.clock.Now()}, err
+}
+
+// exchangeWithFallback sends m over UDP repeating it without EDNS if the server does not support it
+// and over TCP if the response is truncated, mismatches the query or is malformed.
+// Queries of coalesced apexes with shared connections are sent over them, see ApexCoalescing.
+// Returns the transport of the last attempt
+func (d *dnsClient) exchangeWithFallback(ctx context.Context, addr string, m *dns.Msg) (*dns.Msg, string, error) {
+	if sc := sharedConnsFrom(ctx); sc != nil {
+		in, err := sc.exchange(ctx, addr, m)
+		return in, TransportTCP, err
+	}
+	transport := TransportUDP
+	in, err := exchangeNet(ctx, &d.cfg.conns, transport, addr, m)
+	if err == nil && (in.Rcode == dns.RcodeFormatError || in.Rcode == dns.RcodeNotImplemented) && m.IsEdns0() != nil {
+		atomic.AddUint64(&d.cfg.ednsFallbacks, 1)
+		m = stripEdns0(m)
+		in, err = exchangeNet(ctx, &d.cfg.conns, TransportUDP, addr, m)
+	}
+
+	if (err == nil && in.Truncated) || isSuspiciousErr(err) {
+		atomic.AddUint64(&d.cfg.tcpFallbacks, 1)
+		transport = TransportTCP
+		in, err = exchangeNet(ctx, &d.cfg.conns, transport, addr, m)
+	}
+
+	return in, transport, err
+}
+
+// exchangeNet sends m to addr over network checking the response matches the query,
+// the connection is counted in counts while it is open
+func exchangeNet(ctx context.Context, counts *connCounts, network, addr string, m *dns.Msg) (*dns.Msg, error) {
+	c := &dns.Client{Net: network, UDPSize: ednsBufSize}
+	counts.add(network, 1)
+	in, _, err := c.ExchangeContext(ctx, m, addr)
+	counts.add(network, -1)
+	if err != nil {
+		return nil, err
 	}
-	if ttl6 > defaultTtl && ttl6 != math.MaxUint32 && ttl6 < ttl4 {
-		ttl = ttl6
+	return checkResponse(m, in)
+}
+
+// checkResponse returns in if it is an acceptable response to m
+func checkResponse(m, in *dns.Msg) (*dns.Msg, error) {
+	if len(in.Answer)+len(in.Ns)+len(in.Extra) > maxResponseRRs {
+		return nil, errTooManyRecords
 	}
+	if len(in.Question) != 1 || !strings.EqualFold(in.Question[0].Name, m.Question[0].Name) ||
+		in.Question[0].Qtype != m.Question[0].Qtype || in.Question[0].Qclass != m.Question[0].Qclass {
+		return nil, errQuestionMismatch
+	}
+	return in, nil
+}
 
-	return ip4, ip6, ttl, g.Wait()
+// isSuspiciousErr reports whether err means the response was malformed or did not match the query,
+// which may be a sign of spoofing
+func isSuspiciousErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, errQuestionMismatch) || errors.Is(err, dns.ErrId) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var dnsErr *dns.Error
+	return errors.As(err, &dnsErr)
+}
+
+// stripEdns0 returns a copy of m without the OPT record
+func stripEdns0(m *dns.Msg) *dns.Msg {
+	m = m.Copy()
+	extra := m.Extra[:0]
+	for _, rr := range m.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+	m.Extra = extra
+	return m
 }
 
 // LookupSRV ...
@@ -163,48 +588,60 @@ func (d *dnsClient) lookupSRV(service, proto, name string) (string, []*net.SRV,
 	var (
 		cname string
 		srvs  []*net.SRV
-		err   error
 	)
-
-	for i := 0; i < nsCnt; i++ {
-		d.RLock()
-		nsIdx := int(atomic.LoadUint64(&d.nsCounter)) % len(d.nameServers)
-		nServer := d.nameServers[nsIdx]
-		d.RUnlock()
-
+	err := d.tryNameServers(context.Background(), func(nServer string) (err error) {
 		cname, srvs, err = d.dnsLookupSRV(nServer, service, proto, name)
-		if err == nil {
-			break
-		}
-		atomic.AddUint64(&d.nsCounter, 1)
-	}
+		return err
+	})
 
 	return cname, srvs, err
 }
 
 func (d *dnsClient) dnsLookupSRV(nServer, service, proto, name string) (string, []*net.SRV, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), d.queryTimeout(nServer))
 	defer cancel()
 
 	r := &net.Resolver{
 		PreferGo: true,
 		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			d := net.Dialer{Timeout: 2 * time.Second}
-			return d.DialContext(ctx, network, nServer+":53")
+			d := net.Dialer{Timeout: maxQueryTimeout}
+			return d.DialContext(ctx, network, nameServerAddr(nServer))
 		},
 	}
-	return r.LookupSRV(ctx, service, proto, name)
+
+	qname := "_" + service + "._" + proto + "." + name
+	d.cfg.hooks.callBefore(qname, dns.TypeSRV)
+	start := time.Now()
+	cname, srvs, err := r.LookupSRV(ctx, service, proto, name)
+	rtt := time.Since(start)
+	d.cfg.hooks.callAfter(qname, dns.TypeSRV, nil, err, rtt)
+	d.recordQuery(nServer, nil, err, rtt)
+
+	return cname, srvs, err
 }
 
-// parseNameServers ...
-func parseNameServers(nameServers []string) []string {
+// parseNameServers accepts nameservers as IP addresses or ip:port ([ipv6]:port) pairs,
+// invalid nameservers are logged and skipped
+func parseNameServers(tag string, nameServers []string, logger logApi.Logger) []string {
 	ret := make([]string, 0, len(nameServers))
 	for _, ns := range nameServers {
-		if addr := net.ParseIP(ns); addr == nil {
-			log.Printf("nameserver %s is not valid\n", ns)
+		if addr := net.ParseIP(ns); addr != nil {
+			ret = append(ret, ns)
+			continue
+		}
+		if host, port, err := net.SplitHostPort(ns); err == nil && net.ParseIP(host) != nil && port != "" {
+			ret = append(ret, ns)
 			continue
 		}
-		ret = append(ret, ns)
+		logError(logger, tag, "Nameserver is not valid:", ns)
 	}
 	return ret
 }
+
+// nameServerAddr returns the address to dial nameserver nServer at, port 53 is used if none is set
+func nameServerAddr(nServer string) string {
+	if net.ParseIP(nServer) != nil {
+		return net.JoinHostPort(nServer, "53")
+	}
+	return nServer
+}
@@ -0,0 +1,98 @@
+package resolver
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// dohMediaType - the media type of DNS messages over HTTPS, RFC 8484
+	dohMediaType = "application/dns-message"
+
+	// dohMaxMsgSize - the max size of a query accepted over HTTPS
+	dohMaxMsgSize = dns.MaxMsgSize
+)
+
+// DoHHandler returns an http.Handler serving DNS over HTTPS (RFC 8484) at /dns-query with GET and POST,
+// queries are answered like by ServeDNS. TLS is left to the http.Server the handler is served by
+func (r *Resolver) DoHHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dns-query", r.serveDoH)
+	return mux
+}
+
+// serveDoH ...
+func (r *Resolver) serveDoH(w http.ResponseWriter, req *http.Request) {
+	var (
+		buf []byte
+		err error
+	)
+	switch req.Method {
+	case http.MethodGet:
+		buf, err = base64.RawURLEncoding.DecodeString(req.URL.Query().Get("dns"))
+	case http.MethodPost:
+		if req.Header.Get("Content-Type") != dohMediaType {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		buf, err = io.ReadAll(io.LimitReader(req.Body, dohMaxMsgSize+1))
+		if err == nil && len(buf) > dohMaxMsgSize {
+			http.Error(w, "query too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil || len(buf) == 0 {
+		http.Error(w, "invalid query", http.StatusBadRequest)
+		return
+	}
+
+	q := new(dns.Msg)
+	if err = q.Unpack(buf); err != nil {
+		http.Error(w, "invalid query", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), serverTimeout)
+	defer cancel()
+	var client net.Addr
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		client = &net.IPAddr{IP: net.ParseIP(host)}
+	}
+	resp := r.viewResolver(client).answer(ctx, q)
+
+	out, err := resp.Pack()
+	if err != nil {
+		logError(r.logger, r.tag, "Error packing DoH answer for", req.RemoteAddr, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", dohMediaType)
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", minTtl(resp)))
+	w.Write(out)
+}
+
+// minTtl returns the min TTL of records of a response, zero if there are none
+func minTtl(m *dns.Msg) uint32 {
+	var ttl uint32
+	first := true
+	for _, section := range [][]dns.RR{m.Answer, m.Ns} {
+		for _, rr := range section {
+			if first || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+				first = false
+			}
+		}
+	}
+	return ttl
+}
@@ -0,0 +1,29 @@
+package resolver
+
+import (
+	"crypto/tls"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultDoTAddr - the address DNS over TLS is served at by default
+const DefaultDoTAddr = ":853"
+
+// DoTServer returns a server of DNS over TLS (RFC 7858) at addr answering queries like ServeDNS,
+// DefaultDoTAddr is used if addr is empty. The "dot" ALPN protocol is added to a copy of config
+// if it has no protocols set. The server is started by ListenAndServe and stopped by Shutdown
+func (r *Resolver) DoTServer(addr string, config *tls.Config) *dns.Server {
+	if addr == "" {
+		addr = DefaultDoTAddr
+	}
+	if config != nil && len(config.NextProtos) == 0 {
+		config = config.Clone()
+		config.NextProtos = []string{"dot"}
+	}
+	return &dns.Server{
+		Addr:      addr,
+		Net:       "tcp-tls",
+		TLSConfig: config,
+		Handler:   r,
+	}
+}
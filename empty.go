@@ -0,0 +1,113 @@
+package resolver
+
+import (
+	"net"
+	"time"
+)
+
+// defaultEmptyWait - the time GetNextIP* wait for addresses with EmptyWait if Policy.EmptyWait is not set
+const defaultEmptyWait = 5 * time.Second
+
+// EmptyAction - what GetNextIP* do when a host has no usable addresses, see Policy.OnEmpty
+type EmptyAction int
+
+const (
+	// EmptyReturn - an empty string is returned, it is the default
+	EmptyReturn EmptyAction = iota
+	// EmptyWait - the call blocks until a refresh of the host gives it addresses
+	// or Policy.EmptyWait elapses, then an empty string is returned
+	EmptyWait
+	// EmptyFallback - the first address of Policy.EmptyFallback of the family of the call is returned
+	// with index -1, an empty string if there is none
+	EmptyFallback
+)
+
+// String ...
+func (a EmptyAction) String() string {
+	switch a {
+	case EmptyReturn:
+		return "return"
+	case EmptyWait:
+		return "wait"
+	case EmptyFallback:
+		return "fallback"
+	}
+	return "unknown"
+}
+
+// onEmpty ...
+func (p *Policy) onEmpty() EmptyAction {
+	if p == nil {
+		return EmptyReturn
+	}
+	return p.OnEmpty
+}
+
+// emptyWait ...
+func (p *Policy) emptyWait() time.Duration {
+	if p == nil || p.EmptyWait <= 0 {
+		return defaultEmptyWait
+	}
+	return p.EmptyWait
+}
+
+// fallbackIP returns the first fallback address of family, of the other family too if allowed
+func (p *Policy) fallbackIP(family Family, other bool) string {
+	if p == nil {
+		return ""
+	}
+	var ret net.IP
+	for _, ip := range p.EmptyFallback {
+		if (ip.To4() != nil) == (family == FamilyV4) {
+			return ip.String()
+		}
+		if other && ret == nil {
+			ret = ip
+		}
+	}
+	if ret == nil {
+		return ""
+	}
+	return ret.String()
+}
+
+// nextIP returns the next address of family for GetNextIP* with options o, an empty string if there is none
+func (h *host) nextIP(family Family, o queryOptions) (string, int) {
+	if o.family == FamilyAll {
+		ip, idx := h.getNextIPWithIndex(family, false)
+		if !ip.IsValid() {
+			return h.nextFallbackIP(family)
+		}
+		return ipStrIdx(ip, idx)
+	}
+	return ipStrIdx(h.getNextIPWithIndex(o.family, true))
+}
+
+// emptyIP returns what GetNextIP* return for a host without addresses per Policy.OnEmpty
+func (h *host) emptyIP(family Family, o queryOptions) (string, int) {
+	switch h.policy.onEmpty() {
+	case EmptyWait:
+		if h.static {
+			break
+		}
+		deadline := h.clock.After(h.policy.emptyWait())
+		for {
+			// subscribe before checking so a change in between is not missed
+			changes := h.changes()
+			if ip, idx := h.nextIP(family, o); ip != "" {
+				return ip, idx
+			}
+			select {
+			case <-changes:
+			case <-deadline:
+				return "", -1
+			}
+		}
+	case EmptyFallback:
+		if o.family != FamilyAll {
+			return h.policy.fallbackIP(o.family, true), -1
+		}
+		return h.policy.fallbackIP(family, false), -1
+	}
+	return "", -1
+}
@@ -0,0 +1,122 @@
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// exclusions - addresses filtered out of hosts by ExcludeIP, kept for hosts not maintained as well,
+// so they apply when a host is added again
+type exclusions struct {
+	mu sync.RWMutex
+	m  map[string]map[netip.Addr]struct{}
+}
+
+// add ...
+func (e *exclusions) add(hostName string, addr netip.Addr) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.m == nil {
+		e.m = make(map[string]map[netip.Addr]struct{})
+	}
+	if e.m[hostName] == nil {
+		e.m[hostName] = make(map[netip.Addr]struct{})
+	}
+	e.m[hostName][addr] = struct{}{}
+}
+
+// remove ...
+func (e *exclusions) remove(hostName string, addr netip.Addr) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.m[hostName], addr)
+	if len(e.m[hostName]) == 0 {
+		delete(e.m, hostName)
+	}
+}
+
+// filter returns ipList without addresses excluded for hostName
+func (e *exclusions) filter(hostName string, ipList []net.IP) []net.IP {
+	if e == nil {
+		return ipList
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	excluded := e.m[hostName]
+	if len(excluded) == 0 {
+		return ipList
+	}
+	ret := make([]net.IP, 0, len(ipList))
+	for _, ip := range ipList {
+		if _, ok := excluded[ipKey(ip)]; !ok {
+			ret = append(ret, ip)
+		}
+	}
+	return ret
+}
+
+// list returns addresses excluded for hostName sorted
+func (e *exclusions) list(hostName string) []string {
+	e.mu.RLock()
+	addrs := make([]netip.Addr, 0, len(e.m[hostName]))
+	for addr := range e.m[hostName] {
+		addrs = append(addrs, addr)
+	}
+	e.mu.RUnlock()
+
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i].Less(addrs[j])
+	})
+	ret := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		ret = append(ret, addr.String())
+	}
+	return ret
+}
+
+// ExcludeIP filters ip out of addresses of host with name hostName returned by nameservers
+// until IncludeIP is called, the address is removed from the rotation at once and is not added
+// back by refreshes. Static hosts set by UpdateHostsFromMaping are not affected
+func (r *Resolver) ExcludeIP(hostName, ip string) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return fmt.Errorf("invalid IP address %q", ip)
+	}
+	addr := ipKey(parsed)
+	r.exclusions.add(hostName, addr)
+
+	r.mu.RLock()
+	h := r.hosts[hostName]
+	r.mu.RUnlock()
+
+	if h != nil && !h.static {
+		// an IPv4 address may be kept in the IPv4-mapped form with IPv6 addresses, see Policy.MappedV4
+		removed4 := h.ip4.remove(addr)
+		removed6 := h.ip6.remove(addr)
+		if removed4 || removed6 {
+			atomic.AddUint64(&h.version, 1)
+			h.notifyChange()
+		}
+	}
+	return nil
+}
+
+// IncludeIP cancels ExcludeIP for ip of host with name hostName,
+// the address returns to the rotation with the next refresh of the host
+func (r *Resolver) IncludeIP(hostName, ip string) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return fmt.Errorf("invalid IP address %q", ip)
+	}
+	r.exclusions.remove(hostName, ipKey(parsed))
+	return nil
+}
+
+// ExcludedIPs returns addresses excluded for host with name hostName by ExcludeIP
+func (r *Resolver) ExcludedIPs(hostName string) []string {
+	return r.exclusions.list(hostName)
+}
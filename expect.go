@@ -0,0 +1,36 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrUnexpectedAddress - an answer has addresses outside of Policy.ExpectedPrefixes
+var ErrUnexpectedAddress = errors.New("answer has addresses outside of the expected prefixes")
+
+// checkExpected returns an error wrapping ErrUnexpectedAddress if addresses of l are outside
+// of the expected prefixes of the policy
+func (p *Policy) checkExpected(l hostLookup) error {
+	if p == nil || len(p.ExpectedPrefixes) == 0 {
+		return nil
+	}
+	var unexpected []net.IP
+	for _, ip := range append(append([]net.IP(nil), l.ip4...), l.ip6...) {
+		addr := ipKey(ip)
+		expected := false
+		for _, prefix := range p.ExpectedPrefixes {
+			if prefix.Contains(addr) {
+				expected = true
+				break
+			}
+		}
+		if !expected {
+			unexpected = append(unexpected, ip)
+		}
+	}
+	if len(unexpected) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrUnexpectedAddress, unexpected)
+}
@@ -0,0 +1,6 @@
+package resolver
+
+// CollectGarbage runs a pass of the GC policy at once, tests call it instead of waiting for the ticker
+func (r *Resolver) CollectGarbage() {
+	r.deleteOldHosts()
+}
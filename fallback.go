@@ -0,0 +1,38 @@
+package resolver
+
+// FamilyFallback - what GetNextIP* return for a host without addresses of the family of the call,
+// flags of Policy.FamilyFallback
+type FamilyFallback int
+
+const (
+	// FallbackMapped - GetNextIP6* return an IPv4 address of the host in the IPv4-mapped IPv6 form
+	// (::ffff:192.0.2.1) if it has no IPv6 addresses, usable with dual-stack sockets
+	FallbackMapped FamilyFallback = 1 << iota
+	// FallbackToV6 - GetNextIP* return an IPv6 address of the host if it has no IPv4 addresses
+	FallbackToV6
+)
+
+// familyFallback ...
+func (p *Policy) familyFallback() FamilyFallback {
+	if p == nil {
+		return 0
+	}
+	return p.FamilyFallback
+}
+
+// nextFallbackIP returns the next address of the other family for a call of family which found
+// no addresses, as allowed by the policy of the host
+func (h *host) nextFallbackIP(family Family) (string, int) {
+	fallback := h.policy.familyFallback()
+	now := h.clock.Now()
+	switch {
+	case family == FamilyV6 && fallback&FallbackMapped != 0 && !h.hardExpired(FamilyV4, now):
+		ip, idx := h.ip4.getNextIPWithIndex(now)
+		if ip.Is4() {
+			return "::ffff:" + ip.String(), idx
+		}
+	case family == FamilyV4 && fallback&FallbackToV6 != 0 && !h.hardExpired(FamilyV6, now):
+		return ipStrIdx(h.ip6.getNextIPWithIndex(now))
+	}
+	return "", -1
+}
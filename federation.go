@@ -0,0 +1,395 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// peerQueueSize - the max number of updates waiting to be published, further ones are dropped
+	peerQueueSize = 1024
+
+	// peerPublishTimeout - the timeout of publishing an update to peers
+	peerPublishTimeout = 5 * time.Second
+
+	// peerGrace - refreshes of addresses received from peers are deferred by this time after their TTL
+	// so that the peer resolved them first refreshes them and publishes the result
+	peerGrace = 5 * time.Second
+
+	// maxPeerUpdateSize - the max size of an update accepted by HTTPPeers
+	maxPeerUpdateSize = 64 << 10
+
+	// maxPeerClockSkew - HTTPPeers refuse updates signed earlier or later than this from now
+	maxPeerClockSkew = time.Minute
+
+	// peerSignatureHeader, peerTimestampHeader - headers of the signature of an update posted by HTTPPeers
+	// and of the Unix time it was signed at
+	peerSignatureHeader = "X-Peer-Signature"
+	peerTimestampHeader = "X-Peer-Timestamp"
+)
+
+// errNoPeerSecret - HTTPPeers are created without a secret
+var errNoPeerSecret = errors.New("no peer secret")
+
+// CacheUpdate - addresses of a host resolved by a resolver of a federation, or an invalidation of them
+type CacheUpdate struct {
+	// Origin - the name of the resolver published the update, see WithFederation
+	Origin string `json:"origin"`
+
+	Host   string   `json:"host"`
+	Family Family   `json:"family"`
+	IP4    []net.IP `json:"ip4,omitempty"`
+	IP6    []net.IP `json:"ip6,omitempty"`
+
+	// TTL4, TTL6 - upstream TTLs in seconds, receivers apply their own policies to them
+	TTL4 uint32 `json:"ttl4,omitempty"`
+	TTL6 uint32 `json:"ttl6,omitempty"`
+
+	// Invalidate - the addresses of the host are stale, receivers refresh them
+	Invalidate bool `json:"invalidate,omitempty"`
+}
+
+// PeerTransport - delivers cache updates between resolvers of a federation, e.g. grpcpeers.Transport or HTTPPeers.
+// Updates overwrite cached addresses, so implementations must authenticate peers. Implementations must be safe
+// for concurrent use
+type PeerTransport interface {
+	// Publish sends u to all peers
+	Publish(ctx context.Context, u CacheUpdate) error
+
+	// Updates returns the channel of updates received from peers, it may include updates of this resolver
+	Updates() <-chan CacheUpdate
+}
+
+// federation - the state of WithFederation
+type federation struct {
+	origin    string
+	transport PeerTransport
+
+	// out - updates waiting to be published
+	out chan CacheUpdate
+
+	// published, dropped, received - the numbers of updates published, dropped because out was full
+	// and applied from peers
+	published, dropped, received uint64
+}
+
+// publish queues addresses of family of hostName resolved from upstreams for publishing,
+// the update is dropped if the queue is full
+func (f *federation) publish(hostName string, family Family, l hostLookup) {
+	if f == nil {
+		return
+	}
+	u := CacheUpdate{Origin: f.origin, Host: hostName, Family: family}
+	if family.hasV4() {
+		u.IP4, u.TTL4 = l.ip4, l.ttl4
+	}
+	if family.hasV6() {
+		u.IP6, u.TTL6 = l.ip6, l.ttl6
+	}
+	f.send(u)
+}
+
+// send ...
+func (f *federation) send(u CacheUpdate) {
+	select {
+	case f.out <- u:
+	default:
+		atomic.AddUint64(&f.dropped, 1)
+	}
+}
+
+// WithFederation - joins a federation of resolvers sharing their caches through t: addresses resolved
+// from upstreams are published to peers, and addresses received from peers are applied to hosts
+// maintained by this resolver, deferring their refreshes until the TTL of the update expires,
+// so a fleet queries upstreams about once per TTL for each host. Updates are not published again
+// by receivers. origin names this resolver in updates, the host name is used if empty.
+// Applies to hosts created after this call, static hosts are not published nor updated,
+// hosts which require DNSSEC accept only invalidations from peers
+func (r *Resolver) WithFederation(origin string, t PeerTransport) *Resolver {
+	if origin == "" {
+		origin, _ = os.Hostname()
+	}
+	f := &federation{origin: origin, transport: t, out: make(chan CacheUpdate, peerQueueSize)}
+
+	r.mu.Lock()
+	if r.federation != nil {
+		r.mu.Unlock()
+		logError(r.logger, r.tag, "Federation is already set")
+		return r
+	}
+	r.federation = f
+	r.mu.Unlock()
+
+	r.scheduler.goroutines.spawn("peer-publish", "", func() { r.publishPeers(f) })
+	r.scheduler.goroutines.spawn("peer-receive", "", func() { r.receivePeers(f) })
+	return r
+}
+
+// InvalidateHost - makes peers of the federation and this resolver refresh addresses of hostName now,
+// e.g. after the records of the host were changed
+func (r *Resolver) InvalidateHost(hostName string) {
+	r.mu.RLock()
+	f := r.federation
+	h := r.hosts[hostName]
+	r.mu.RUnlock()
+
+	if h != nil && !h.static {
+		h.scheduler.reschedule(h, FamilyAll, h.clock.Now())
+	}
+	if f != nil {
+		f.send(CacheUpdate{Origin: f.origin, Host: hostName, Invalidate: true})
+	}
+}
+
+// PeerStats - counters of the federation, see WithFederation
+type PeerStats struct {
+	Published uint64
+	Dropped   uint64
+	Received  uint64
+}
+
+// PeerStats returns counters of the federation, zero if the resolver is not federated
+func (r *Resolver) PeerStats() PeerStats {
+	r.mu.RLock()
+	f := r.federation
+	r.mu.RUnlock()
+	if f == nil {
+		return PeerStats{}
+	}
+	return PeerStats{
+		Published: atomic.LoadUint64(&f.published),
+		Dropped:   atomic.LoadUint64(&f.dropped),
+		Received:  atomic.LoadUint64(&f.received),
+	}
+}
+
+// publishPeers publishes queued updates until the resolver is stopped
+func (r *Resolver) publishPeers(f *federation) {
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case u := <-f.out:
+			ctx, cancel := context.WithTimeout(context.Background(), peerPublishTimeout)
+			err := f.transport.Publish(ctx, u)
+			cancel()
+			if err != nil {
+				logError(r.logger, r.tag, "Error publishing update of host", r.clientCfg.privacy.redact(u.Host),
+					r.clientCfg.privacy.redactErr(err, u.Host))
+				continue
+			}
+			atomic.AddUint64(&f.published, 1)
+		}
+	}
+}
+
+// receivePeers applies updates of peers until the resolver is stopped or the channel is closed
+func (r *Resolver) receivePeers(f *federation) {
+	updates := f.transport.Updates()
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case u, ok := <-updates:
+			if !ok {
+				return
+			}
+			if u.Origin != f.origin && r.applyPeerUpdate(u) {
+				atomic.AddUint64(&f.received, 1)
+			}
+		}
+	}
+}
+
+// applyPeerUpdate applies u to the host if it is maintained and ready, addresses of hosts which require
+// DNSSEC are not taken from peers as they cannot be validated. Reports whether u was applied
+func (r *Resolver) applyPeerUpdate(u CacheUpdate) bool {
+	r.mu.RLock()
+	h := r.hosts[u.Host]
+	r.mu.RUnlock()
+	if h == nil || h.static || h.isStopped() || !h.isReady() || !h.policy.resolvesAddrs() {
+		return false
+	}
+
+	now := h.clock.Now()
+	if u.Invalidate {
+		h.scheduler.reschedule(h, FamilyAll, now)
+		return true
+	}
+	if h.secure() {
+		logError(h.logger, h.tag, "Rejected update of host", h.logName(), "from peer", u.Origin,
+			"as the host requires DNSSEC")
+		return false
+	}
+	family, ok := intersectFamily(h.policy.family(), u.Family)
+	if !ok {
+		return false
+	}
+
+	src := Source{Nameserver: u.Origin, Transport: TransportPeer, Time: now}
+	l := hostLookup{ip4: u.IP4, ip6: u.IP6, ttl4: u.TTL4, ttl6: u.TTL6, src4: src, src6: src}
+	if err := h.policy.checkExpected(l); err != nil {
+		atomic.AddUint64(&h.dnsClient.cfg.unexpectedAnswers, 1)
+		logError(h.logger, h.tag, "Rejected update of host", h.logName(), "from peer", u.Origin, h.logErr(err))
+		return false
+	}
+	h.setErr(nil)
+	ttl4, ttl6 := h.applyLookup(family, l)
+	if family.hasV4() {
+		h.scheduler.reschedule(h, FamilyV4, now.Add(time.Duration(ttl4)*time.Second+peerGrace))
+	}
+	if family.hasV6() {
+		h.scheduler.reschedule(h, FamilyV6, now.Add(time.Duration(ttl6)*time.Second+peerGrace))
+	}
+	return true
+}
+
+// intersectFamily returns the family of both a and b, ok is false if there is none
+func intersectFamily(a, b Family) (Family, bool) {
+	switch {
+	case a == FamilyAll:
+		return b, true
+	case b == FamilyAll || a == b:
+		return a, true
+	}
+	return a, false
+}
+
+// HTTPPeers - a PeerTransport posting updates as JSON to URLs of peers and receiving them
+// as an http.Handler, mount it at the URL the peers post to. Updates are authenticated with
+// an HMAC-SHA256 of the body and the time it was signed at keyed by a secret shared by the peers,
+// updates signed more than a minute from now are refused to limit replays. Use HTTPS
+// if the addresses must not be disclosed
+type HTTPPeers struct {
+	client  *http.Client
+	secret  []byte
+	urls    []string
+	updates chan CacheUpdate
+}
+
+// NewHTTPPeers returns a transport posting updates to urls with client, http.DefaultClient if nil,
+// signed with secret. Nothing is published nor received if secret is empty
+func NewHTTPPeers(client *http.Client, secret []byte, urls ...string) *HTTPPeers {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPPeers{client: client, secret: secret, urls: urls, updates: make(chan CacheUpdate, peerQueueSize)}
+}
+
+// signPeerUpdate returns the hex HMAC-SHA256 of the time ts an update was signed at and its body with secret
+func signPeerUpdate(secret []byte, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte{'\n'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature of an update posted at now
+func (p *HTTPPeers) verify(header http.Header, body []byte, now time.Time) error {
+	if len(p.secret) == 0 {
+		return errNoPeerSecret
+	}
+	ts := header.Get(peerTimestampHeader)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad timestamp %q", ts)
+	}
+	if skew := now.Sub(time.Unix(sec, 0)); skew > maxPeerClockSkew || skew < -maxPeerClockSkew {
+		return fmt.Errorf("timestamp is off by %s", skew)
+	}
+	if !hmac.Equal([]byte(header.Get(peerSignatureHeader)), []byte(signPeerUpdate(p.secret, ts, body))) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// Publish posts u to all peers and returns the last error
+func (p *HTTPPeers) Publish(ctx context.Context, u CacheUpdate) error {
+	if len(p.secret) == 0 {
+		return errNoPeerSecret
+	}
+	body, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, url := range p.urls {
+		if err := p.post(ctx, url, body); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// post ...
+func (p *HTTPPeers) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(peerTimestampHeader, ts)
+	req.Header.Set(peerSignatureHeader, signPeerUpdate(p.secret, ts, body))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("peer %s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// Updates ...
+func (p *HTTPPeers) Updates() <-chan CacheUpdate {
+	return p.updates
+}
+
+// ServeHTTP receives an update posted by a peer, updates without a valid signature are refused,
+// updates are dropped if the receiver falls behind
+func (p *HTTPPeers) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxPeerUpdateSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := p.verify(req.Header, body, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var u CacheUpdate
+	if err := json.Unmarshal(body, &u); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if u.Host == "" {
+		http.Error(w, "no host", http.StatusBadRequest)
+		return
+	}
+	select {
+	case p.updates <- u:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "too many updates", http.StatusServiceUnavailable)
+	}
+}
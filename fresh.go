@@ -0,0 +1,53 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// GetIPsFresh returns IPv4 and IPv6 addresses of host with name hostName obtained less than maxAge ago,
+// older addresses are refreshed before returning regardless of their TTL. The host is passed through
+// the lookup pipeline and added to maintaining non-explicitly if it is not maintained, addresses
+// of rewrite rules and mirrored zones are returned as is. Errors are of type *net.DNSError
+func (r *Resolver) GetIPsFresh(ctx context.Context, hostName string, maxAge time.Duration) ([]net.IP, []net.IP, error) {
+	l := apiLookup(hostName, dns.TypeNone)
+	l.sourceOnly = true
+	res := r.resolve(ctx, l)
+	if res.err != nil {
+		return nil, nil, dnsError(res.err, hostName)
+	}
+	if res.rcode == dns.RcodeNameError {
+		return nil, nil, &net.DNSError{Err: errNoSuchHost, Name: hostName, IsNotFound: true}
+	}
+
+	h := res.host
+	if h == nil {
+		ip4, ip6 := res.addrs()
+		return ip4, ip6, nil
+	}
+	if !h.static && h.age() >= maxAge {
+		h.reloadIPs(ctx, h.policy.family())
+		if err := h.getErr(); err != nil {
+			return nil, nil, &net.DNSError{Err: err.Error(), Name: hostName, IsTimeout: isTimeout(err), IsTemporary: true}
+		}
+	}
+	ip4, ip6 := h.getIPs()
+	return ip4, ip6, nil
+}
+
+// age returns the time since the addresses of the host were obtained, the oldest family counts
+func (h *host) age() time.Duration {
+	src4, src6 := h.sources.get()
+	family := h.policy.family()
+	var obtained time.Time
+	if family.hasV4() {
+		obtained = src4.Time
+	}
+	if family.hasV6() && (obtained.IsZero() || src6.Time.Before(obtained)) {
+		obtained = src6.Time
+	}
+	return h.clock.Now().Sub(obtained)
+}
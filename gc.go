@@ -0,0 +1,81 @@
+package resolver
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// defaultIdleTimeout - hosts added non-explicitly and not looked up for this duration are deleted
+const defaultIdleTimeout = 30 * time.Minute
+
+// GCPolicy - the rule deleting hosts added non-explicitly by lookups, checked every minute.
+// Explicitly added and static hosts are never deleted, nor are hosts during an outage
+type GCPolicy struct {
+	// IdleTimeout - a host not looked up for this duration is deleted, 30 minutes if zero,
+	// a negative value disables the rule
+	IdleTimeout time.Duration
+
+	// MinLookups, Window - if MinLookups is set, a host looked up fewer than MinLookups times
+	// within a Window is deleted at the end of the window, e.g. to keep only popular hosts
+	// while the idle rule is disabled. Window is IdleTimeout or 30 minutes if zero
+	MinLookups uint64
+	Window     time.Duration
+}
+
+// idleTimeout ...
+func (p GCPolicy) idleTimeout() time.Duration {
+	if p.IdleTimeout == 0 {
+		return defaultIdleTimeout
+	}
+	return p.IdleTimeout
+}
+
+// window ...
+func (p GCPolicy) window() time.Duration {
+	switch {
+	case p.Window > 0:
+		return p.Window
+	case p.IdleTimeout > 0:
+		return p.IdleTimeout
+	}
+	return defaultIdleTimeout
+}
+
+// isGarbage reports whether h must be deleted at now, it starts a new lookup window of h
+// when the current one is over. The resolver mutex must be held
+func (p GCPolicy) isGarbage(h *host, now time.Time) bool {
+	if idle := p.idleTimeout(); idle > 0 && h.idleFor(now) >= idle {
+		return true
+	}
+	if p.MinLookups == 0 {
+		return false
+	}
+
+	lookups := h.getLookups()
+	if h.gcWindowStart == 0 {
+		h.gcWindowStart, h.gcLookups = now.Unix(), lookups
+		return false
+	}
+	if now.Unix()-h.gcWindowStart < int64(p.window()/time.Second) {
+		return false
+	}
+	if lookups-h.gcLookups < p.MinLookups {
+		return true
+	}
+	h.gcWindowStart, h.gcLookups = now.Unix(), lookups
+	return false
+}
+
+// idleFor returns the time since the last lookup of the host or its creation
+func (h *host) idleFor(now time.Time) time.Duration {
+	return time.Duration(now.Unix()-atomic.LoadInt64(&h.lastTime)) * time.Second
+}
+
+// WithGCPolicy - sets the rule deleting hosts added non-explicitly, by default
+// they are deleted when not looked up for 30 minutes
+func (r *Resolver) WithGCPolicy(p GCPolicy) *Resolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gcPolicy = p
+	return r
+}
@@ -0,0 +1,158 @@
+package resolver_test
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	resolver "github.com/ndmsystems/go-dns-caching-resolver"
+	"github.com/ndmsystems/go-dns-caching-resolver/resolvertest"
+)
+
+// gcZone - records of hosts of the GC tests
+const gcZone = `
+a.test. 86400 IN A 192.0.2.1
+b.test. 86400 IN A 192.0.2.2
+c.test. 86400 IN A 192.0.2.3
+d.test. 86400 IN A 192.0.2.4
+`
+
+// newGCResolver returns a resolver with the GC policy p and the fake clock it runs by
+func newGCResolver(t *testing.T, p resolver.GCPolicy) (*resolver.Resolver, *resolvertest.Clock) {
+	t.Helper()
+	srv, err := resolvertest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	if err = srv.AddRecords(gcZone); err != nil {
+		t.Fatal(err)
+	}
+
+	clock := resolvertest.NewClock(time.Now())
+	r := resolver.NewWithClock("test", nil, clock).WithNameservers(srv.Addr).WithGCPolicy(p)
+	t.Cleanup(r.Stop)
+	return r, clock
+}
+
+// lookup looks up hostName adding it non-explicitly
+func lookup(t *testing.T, r *resolver.Resolver, hostName string) {
+	t.Helper()
+	if ip := r.GetNextIP(hostName); ip == "" {
+		t.Fatalf("no address of %s", hostName)
+	}
+}
+
+// hostNames returns names of maintained hosts sorted
+func hostNames(r *resolver.Resolver) []string {
+	var names []string
+	for _, st := range r.HostStats() {
+		names = append(names, st.Host)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkHosts fails the test if the maintained hosts are not want
+func checkHosts(t *testing.T, r *resolver.Resolver, want ...string) {
+	t.Helper()
+	if got := hostNames(r); !reflect.DeepEqual(got, want) {
+		t.Fatalf("hosts %v, want %v", got, want)
+	}
+}
+
+func TestGCDeletesIdleHosts(t *testing.T) {
+	r, clock := newGCResolver(t, resolver.GCPolicy{IdleTimeout: 10 * time.Minute})
+	r.UpdateHostsFromMaping(map[string]map[string][]string{
+		"static.test": {"ip4": {"192.0.2.10"}},
+	})
+	lookup(t, r, "a.test")
+	lookup(t, r, "c.test")
+	r.AddHost("b.test")
+	lookup(t, r, "b.test")
+	// a host added by a lookup and then explicitly is promoted
+	lookup(t, r, "d.test")
+	r.AddHost("d.test")
+
+	clock.Advance(6 * time.Minute)
+	lookup(t, r, "c.test")
+	r.CollectGarbage()
+	checkHosts(t, r, "a.test", "b.test", "c.test", "d.test", "static.test")
+
+	clock.Advance(5 * time.Minute)
+	r.CollectGarbage()
+	checkHosts(t, r, "b.test", "c.test", "d.test", "static.test")
+
+	clock.Advance(24 * time.Hour)
+	r.CollectGarbage()
+	checkHosts(t, r, "b.test", "d.test", "static.test")
+}
+
+func TestGCDefaultIdleTimeout(t *testing.T) {
+	r, clock := newGCResolver(t, resolver.GCPolicy{})
+	lookup(t, r, "a.test")
+
+	clock.Advance(29 * time.Minute)
+	r.CollectGarbage()
+	checkHosts(t, r, "a.test")
+
+	clock.Advance(time.Minute)
+	r.CollectGarbage()
+	checkHosts(t, r)
+}
+
+func TestGCIdleRuleDisabled(t *testing.T) {
+	r, clock := newGCResolver(t, resolver.GCPolicy{IdleTimeout: -1})
+	lookup(t, r, "a.test")
+
+	clock.Advance(24 * time.Hour)
+	r.CollectGarbage()
+	checkHosts(t, r, "a.test")
+}
+
+func TestGCDeletesRarelyLookedUpHosts(t *testing.T) {
+	r, clock := newGCResolver(t, resolver.GCPolicy{IdleTimeout: -1, MinLookups: 3, Window: 10 * time.Minute})
+	lookup(t, r, "a.test")
+	lookup(t, r, "b.test")
+	r.AddHost("c.test")
+	lookup(t, r, "c.test")
+
+	// the first pass starts lookup windows of the hosts
+	r.CollectGarbage()
+	for i := 0; i < 3; i++ {
+		lookup(t, r, "a.test")
+	}
+	lookup(t, r, "b.test")
+
+	clock.Advance(9 * time.Minute)
+	r.CollectGarbage()
+	checkHosts(t, r, "a.test", "b.test", "c.test")
+
+	clock.Advance(time.Minute)
+	r.CollectGarbage()
+	checkHosts(t, r, "a.test", "c.test")
+
+	// a.test is not looked up within the next window
+	clock.Advance(10 * time.Minute)
+	r.CollectGarbage()
+	checkHosts(t, r, "c.test")
+}
+
+func TestMemoryLimitKeepsExplicitHosts(t *testing.T) {
+	r, _ := newGCResolver(t, resolver.GCPolicy{})
+	lookup(t, r, "a.test")
+	lookup(t, r, "c.test")
+	r.AddHost("b.test")
+	lookup(t, r, "b.test")
+
+	// a limit below the size of any host leaves only the explicitly added ones
+	r.WithMemoryLimit(1)
+	deadline := time.Now().Add(5 * time.Second)
+	for !reflect.DeepEqual(hostNames(r), []string{"b.test"}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("hosts %v, want [b.test]", hostNames(r))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,192 @@
+// Package grpcpeers - a resolver.PeerTransport exchanging cache updates of a federation over gRPC,
+// see resolver.WithFederation. Peers are authenticated with mutual TLS: every resolver serves and dials
+// with a certificate issued by the CA of the federation and accepts updates only from peers presenting one
+package grpcpeers
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+
+	resolver "github.com/ndmsystems/go-dns-caching-resolver"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// serviceName - the gRPC service of the federation
+	serviceName = "resolver.federation.Peers"
+
+	// publishMethod - the full name of the method receiving an update
+	publishMethod = "/" + serviceName + "/Publish"
+
+	// codecName - the content subtype updates are encoded with
+	codecName = "resolver-json"
+
+	// queueSize - the max number of received updates waiting to be applied, further ones are refused
+	queueSize = 1024
+)
+
+// errNoTLS - the config has no certificate or CAs of the federation
+var errNoTLS = errors.New("grpcpeers: TLS with a certificate and CAs of the federation is required")
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec encodes messages of the service as JSON, so no generated protobuf code is needed
+type jsonCodec struct{}
+
+// Marshal ...
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal ...
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// Name ...
+func (jsonCodec) Name() string {
+	return codecName
+}
+
+// ack - the response to an update
+type ack struct{}
+
+// Config - settings of New
+type Config struct {
+	// Peers - addresses of peers updates are published to, host:port of their gRPC servers
+	Peers []string
+
+	// TLS - the certificate of this resolver in Certificates, RootCAs verifying servers of peers
+	// and ClientCAs verifying peers dialing this resolver
+	TLS *tls.Config
+
+	// DialOptions - extra options of connections to peers, e.g. keepalive parameters
+	DialOptions []grpc.DialOption
+}
+
+// Transport - a resolver.PeerTransport over gRPC, register it on a gRPC server created with ServerOption
+// to receive updates
+type Transport struct {
+	tlsConfig *tls.Config
+	conns     []*grpc.ClientConn
+	updates   chan resolver.CacheUpdate
+}
+
+// New returns a transport publishing updates to cfg.Peers, connections are established lazily
+func New(cfg Config) (*Transport, error) {
+	if cfg.TLS == nil || (len(cfg.TLS.Certificates) == 0 && cfg.TLS.GetCertificate == nil) ||
+		cfg.TLS.RootCAs == nil || cfg.TLS.ClientCAs == nil {
+		return nil, errNoTLS
+	}
+	t := &Transport{tlsConfig: cfg.TLS.Clone(), updates: make(chan resolver.CacheUpdate, queueSize)}
+
+	opts := append([]grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(t.tlsConfig.Clone()))},
+		cfg.DialOptions...)
+	for _, addr := range cfg.Peers {
+		conn, err := grpc.Dial(addr, opts...)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.conns = append(t.conns, conn)
+	}
+	return t, nil
+}
+
+// ServerOption returns the option of the gRPC server receiving updates which requires and verifies
+// certificates of peers
+func (t *Transport) ServerOption() grpc.ServerOption {
+	cfg := t.tlsConfig.Clone()
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return grpc.Creds(credentials.NewTLS(cfg))
+}
+
+// Register registers the service receiving updates on s, s must be created with ServerOption
+func (t *Transport) Register(s *grpc.Server) {
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: serviceName,
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Publish",
+			Handler:    t.handlePublish,
+		}},
+		Metadata: "grpcpeers",
+	}, t)
+}
+
+// Publish sends u to all peers and returns the last error
+func (t *Transport) Publish(ctx context.Context, u resolver.CacheUpdate) error {
+	var lastErr error
+	for _, conn := range t.conns {
+		err := conn.Invoke(ctx, publishMethod, &u, &ack{}, grpc.CallContentSubtype(codecName))
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Updates ...
+func (t *Transport) Updates() <-chan resolver.CacheUpdate {
+	return t.updates
+}
+
+// Close closes connections to peers
+func (t *Transport) Close() error {
+	var lastErr error
+	for _, conn := range t.conns {
+		if err := conn.Close(); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// handlePublish receives an update of a peer authenticated with its certificate, updates are refused
+// if the receiver falls behind
+func (t *Transport) handlePublish(_ interface{}, ctx context.Context, dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	handle := func(ctx context.Context, req interface{}) (interface{}, error) {
+		if !authenticated(ctx) {
+			return nil, status.Error(codes.Unauthenticated, "no verified peer certificate")
+		}
+		u := req.(*resolver.CacheUpdate)
+		if u.Host == "" {
+			return nil, status.Error(codes.InvalidArgument, "no host")
+		}
+		select {
+		case t.updates <- *u:
+			return &ack{}, nil
+		default:
+			return nil, status.Error(codes.ResourceExhausted, "too many updates")
+		}
+	}
+
+	u := new(resolver.CacheUpdate)
+	if err := dec(u); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return handle(ctx, u)
+	}
+	return interceptor(ctx, u, &grpc.UnaryServerInfo{Server: t, FullMethod: publishMethod}, handle)
+}
+
+// authenticated reports whether the peer of ctx presented a certificate verified by the CAs of the federation
+func authenticated(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	return ok && len(info.State.VerifiedChains) > 0
+}
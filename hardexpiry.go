@@ -0,0 +1,69 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStale - addresses of a host are past their hard expiry and are not served, see WithHardExpiry
+var ErrStale = errors.New("addresses are past their hard expiry")
+
+// WithHardExpiry - makes addresses of hosts unusable factor times their TTL after they were resolved
+// if they were not refreshed since, e.g. because nameservers are down. Refreshes still happen per TTL,
+// the hard expiry only limits how long stale addresses are served: GetNextIP* return empty addresses,
+// GetIPs* return none and LastError, LookupIP and AddHostAwait report an error wrapping ErrStale.
+// Zero, the default, serves the last addresses forever. Policy.HardExpiry overrides it.
+// Applies to hosts created after this call
+func (r *Resolver) WithHardExpiry(factor int) *Resolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hardExpiryFactor = factor
+	return r
+}
+
+// hardTtl returns the time addresses resolved with ttl in seconds are usable for, zero if forever
+func (o *hostOptions) hardTtl(ttl uint32) time.Duration {
+	if o.policy != nil && o.policy.HardExpiry > 0 {
+		return o.policy.HardExpiry
+	}
+	if o.hardExpiryFactor > 0 {
+		return time.Duration(o.hardExpiryFactor) * time.Duration(ttl) * time.Second
+	}
+	return 0
+}
+
+// setHardExpiry sets the hard expiry of addresses of family resolved at unix time now with ttl in seconds
+func (h *host) setHardExpiry(family Family, now int64, ttl uint32) {
+	var expire int64
+	if d := h.hardTtl(ttl); d > 0 {
+		expire = now + int64(d/time.Second)
+	}
+	if family == FamilyV4 {
+		atomic.StoreInt64(&h.hardExpireTime4, expire)
+	} else {
+		atomic.StoreInt64(&h.hardExpireTime6, expire)
+	}
+}
+
+// hardExpired reports whether addresses of family are past their hard expiry at now
+func (h *host) hardExpired(family Family, now time.Time) bool {
+	expire := atomic.LoadInt64(&h.hardExpireTime4)
+	if family == FamilyV6 {
+		expire = atomic.LoadInt64(&h.hardExpireTime6)
+	}
+	return expire > 0 && now.Unix() >= expire
+}
+
+// staleErr returns err wrapped with ErrStale if addresses of any family are past their hard expiry
+func (h *host) staleErr(err error) error {
+	now := h.clock.Now()
+	if !h.hardExpired(FamilyV4, now) && !h.hardExpired(FamilyV6, now) {
+		return err
+	}
+	if err == nil {
+		return ErrStale
+	}
+	return fmt.Errorf("%w: %v", ErrStale, err)
+}
@@ -0,0 +1,100 @@
+package resolver
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AddressChange - a change of the set of addresses of a family of a host
+type AddressChange struct {
+	Time   time.Time `json:"time"`
+	Family Family    `json:"family"`
+
+	// Added, Removed - addresses which appeared in and disappeared from the set
+	Added   []net.IP `json:"added,omitempty"`
+	Removed []net.IP `json:"removed,omitempty"`
+
+	// Source - where the new set of addresses was obtained from, Source.Nameserver
+	// is the nameserver responsible for the change
+	Source Source `json:"source"`
+}
+
+// history - a ring of the last address changes of a host
+type history struct {
+	mu      sync.Mutex
+	changes []AddressChange
+}
+
+// newAddressChange returns the change of addresses of family from old to cur, ok is false if they are the same
+func newAddressChange(family Family, old, cur []net.IP, src Source) (ch AddressChange, ok bool) {
+	added, removed := diffIPs(cur, old), diffIPs(old, cur)
+	if len(added) == 0 && len(removed) == 0 {
+		return AddressChange{}, false
+	}
+	return AddressChange{
+		Time:    src.Time,
+		Family:  family,
+		Added:   added,
+		Removed: removed,
+		Source:  src,
+	}, true
+}
+
+// add records ch keeping at most size changes
+func (hs *history) add(size int, ch AddressChange) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.changes = append(hs.changes, ch)
+	if len(hs.changes) > size {
+		hs.changes = append(hs.changes[:0:0], hs.changes[len(hs.changes)-size:]...)
+	}
+}
+
+// get ...
+func (hs *history) get() []AddressChange {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	ret := make([]AddressChange, len(hs.changes))
+	copy(ret, hs.changes)
+	return ret
+}
+
+// diffIPs returns addresses of a missing in b
+func diffIPs(a, b []net.IP) []net.IP {
+	seen := make(map[string]bool, len(b))
+	for _, ip := range b {
+		seen[string(ip.To16())] = true
+	}
+	var ret []net.IP
+	for _, ip := range a {
+		if !seen[string(ip.To16())] {
+			ret = append(ret, ip)
+		}
+	}
+	return ret
+}
+
+// WithHistory - keeps the last n changes of addresses of each host with the time and the nameserver
+// of the change, see History and DebugHandler. Applies to hosts created after this call, zero disables it
+func (r *Resolver) WithHistory(n int) *Resolver {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt32(&r.historySize, int32(n))
+	return r
+}
+
+// History returns the last changes of addresses of host with name hostName, the oldest first.
+// The first resolution of the host is a change adding all its addresses
+func (r *Resolver) History(hostName string) []AddressChange {
+	r.mu.RLock()
+	h, ok := r.hosts[hostOnly(hostName)]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+	return h.history.get()
+}
@@ -0,0 +1,60 @@
+package resolver
+
+import (
+	"net"
+	"sync"
+)
+
+// heldAddrs - the numbers of consecutive refreshes addresses of a family are absent from
+type heldAddrs struct {
+	mu     sync.Mutex
+	misses map[string]int
+}
+
+// apply returns cur with the addresses of old absent from fewer than n consecutive refreshes
+// including this one appended
+func (hd *heldAddrs) apply(n int, old, cur []net.IP) []net.IP {
+	hd.mu.Lock()
+	defer hd.mu.Unlock()
+
+	seen := make(map[string]bool, len(cur))
+	for _, ip := range cur {
+		seen[string(ip.To16())] = true
+	}
+	var held []net.IP
+	misses := make(map[string]int)
+	for _, ip := range old {
+		key := string(ip.To16())
+		if seen[key] {
+			continue
+		}
+		if cnt := hd.misses[key] + 1; cnt < n {
+			misses[key] = cnt
+			held = append(held, ip)
+		}
+	}
+	hd.misses = misses
+	if len(held) == 0 {
+		return cur
+	}
+	return append(append(make([]net.IP, 0, len(cur)+len(held)), cur...), held...)
+}
+
+// WithHoldDown - keeps an address of a host until it is absent from n consecutive refreshes, so
+// addresses flapping in answers of noisy GSLBs do not churn connection pools. Held addresses are
+// rotated as usual. Zero or one removes absent addresses at once. Policy.HoldDown overrides it.
+// Applies to hosts created after this call
+func (r *Resolver) WithHoldDown(n int) *Resolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.holdDown = n
+	return r
+}
+
+// holdDownRefreshes returns the number of refreshes an address must be absent from before it is removed
+func (o *hostOptions) holdDownRefreshes() int {
+	if o.policy != nil && o.policy.HoldDown > 0 {
+		return o.policy.HoldDown
+	}
+	return o.holdDown
+}
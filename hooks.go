@@ -0,0 +1,62 @@
+package resolver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// BeforeLookupFunc - a function called before a query is sent
+type BeforeLookupFunc func(hostName string, qtype uint16)
+
+// AfterLookupFunc - a function called when a query is done, result is nil on error
+// and for lookups made via net.Resolver (the system resolver, SRV lookups)
+type AfterLookupFunc func(hostName string, qtype uint16, result *dns.Msg, err error, duration time.Duration)
+
+// lookupHooks - hooks shared by all dns clients of a resolver, the lists are only appended to,
+// so a copy of a slice taken under mu stays valid after it is released
+type lookupHooks struct {
+	mu     sync.RWMutex
+	before []BeforeLookupFunc
+	after  []AfterLookupFunc
+}
+
+// callBefore calls the hooks after releasing the lock, so a hook may add hooks without a deadlock
+func (h *lookupHooks) callBefore(hostName string, qtype uint16) {
+	h.mu.RLock()
+	before := h.before
+	h.mu.RUnlock()
+	for _, fn := range before {
+		fn(hostName, qtype)
+	}
+}
+
+// callAfter calls the hooks after releasing the lock like callBefore
+func (h *lookupHooks) callAfter(hostName string, qtype uint16, result *dns.Msg, err error, duration time.Duration) {
+	h.mu.RLock()
+	after := h.after
+	h.mu.RUnlock()
+	for _, fn := range after {
+		fn(hostName, qtype, result, err, duration)
+	}
+}
+
+// BeforeLookup - adds a function called before every upstream query. Lookups via the system
+// resolver (no nameservers set) are reported once per lookup with qtype dns.TypeNone.
+// The function is called synchronously and must not block
+func (r *Resolver) BeforeLookup(fn BeforeLookupFunc) *Resolver {
+	r.clientCfg.hooks.mu.Lock()
+	defer r.clientCfg.hooks.mu.Unlock()
+	r.clientCfg.hooks.before = append(r.clientCfg.hooks.before, fn)
+	return r
+}
+
+// AfterLookup - adds a function called after every upstream query with its result, error and duration.
+// The function is called synchronously and must not block
+func (r *Resolver) AfterLookup(fn AfterLookupFunc) *Resolver {
+	r.clientCfg.hooks.mu.Lock()
+	defer r.clientCfg.hooks.mu.Unlock()
+	r.clientCfg.hooks.after = append(r.clientCfg.hooks.after, fn)
+	return r
+}
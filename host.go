@@ -3,6 +3,7 @@ package resolver
 import (
 	"context"
 	"net"
+	"net/netip"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -11,10 +12,75 @@ import (
 )
 
 const (
-	oldHostDuration  = 30 * time.Minute
 	retryIntervalSec = 10
 )
 
+// hostOptions - settings of a host taken from the resolver when the host is created
+type hostOptions struct {
+	// policy - a policy matched the host name, may be nil
+	policy *Policy
+
+	// anchors - trust anchors of the resolver to check negative trust anchors
+	anchors *trustAnchors
+
+	// ttlOverride - a forced refresh interval, zero means the upstream TTL is used
+	ttlOverride time.Duration
+
+	// maxAnswers - the max number of addresses of each family kept, zero means no limit
+	maxAnswers int
+
+	// hardExpiryFactor - addresses are unusable this number of TTLs after they were resolved, zero if never
+	hardExpiryFactor int
+
+	// holdDown - the number of consecutive refreshes an address must be absent from before it is removed
+	holdDown int
+
+	// rtts - smoothed RTTs of addresses of the resolver
+	rtts *addrRTTs
+
+	// exclusions - addresses excluded by ExcludeIP, may be nil
+	exclusions *exclusions
+
+	// ipsetFuncs - functions of ipset hooks matching the host
+	ipsetFuncs []IPSetFunc
+
+	// historySize - the max number of address changes kept, zero disables the history
+	historySize int
+
+	// store - a storage address changes are written to, may be nil
+	store HistoryStore
+
+	// peers - the federation resolved addresses are published to, may be nil
+	peers *federation
+
+	// cnames - hosts of the resolver by names of their CNAME chains, may be nil
+	cnames *cnameIndex
+
+	// clock - a source of time
+	clock Clock
+
+	// scheduler - the scheduler of refreshes of the resolver
+	scheduler *scheduler
+
+	// rrsetType - the type of the RRset maintained by Maintain the host keeps, such a host resolves
+	// no addresses. dns.TypeNone for hosts
+	rrsetType uint16
+}
+
+// prepare filters ipList of hostName by the policy and exclusions, ranks it and truncates it to the cap
+func (o *hostOptions) prepare(hostName string, ipList []net.IP) []net.IP {
+	ipList = o.policy.filter(ipList)
+	ipList = o.exclusions.filter(hostName, ipList)
+	n := o.answerCap()
+	if (n > 0 && len(ipList) > n) || (o.policy != nil && len(o.policy.PreferPrefixes) > 0) {
+		ipList = o.rank(ipList)
+	}
+	if n > 0 && len(ipList) > n {
+		ipList = ipList[:n]
+	}
+	return ipList
+}
+
 // host ...
 type host struct {
 	tag      string
@@ -23,124 +89,521 @@ type host struct {
 	ip6      *ips
 	lastTime int64
 
+	// lookups - the number of lookups of the host addresses
+	lookups uint64
+
+	// gcLookups, gcWindowStart - the number of lookups and the unix time at the start
+	// of the current window of GCPolicy.MinLookups, guarded by the resolver mutex
+	gcLookups     uint64
+	gcWindowStart int64
+
+	// expireTime4, expireTime6 - unix time when the current IPv4 and IPv6 addresses expire
+	expireTime4 int64
+	expireTime6 int64
+
+	// hardExpireTime4, hardExpireTime6 - unix time when the current addresses become unusable, zero if never
+	hardExpireTime4 int64
+	hardExpireTime6 int64
+
+	// version - incremented whenever the set of addresses changes
+	version uint64
+
+	// changeMu, changeCh - a channel closed and replaced whenever the set of addresses changes
+	changeMu sync.Mutex
+	changeCh chan struct{}
+
 	// eaFlag - flag means explicitly added host
 	eaFlag bool
 
+	// promoted - set to 1 when a non-explicitly added host is made explicit
+	promoted int32
+
+	// dials - the number of connections dialed to the host by DialContext
+	dials uint64
+
+	hostOptions
+
+	// errMu, err - the error of the last reload
+	errMu sync.RWMutex
+	err   error
+
+	// sources - where the current addresses were obtained from
+	sources sources
+
+	// rrsets - RRsets of types set by Policy.Qtypes other than A and AAAA
+	rrsets hostRRsets
+
+	// history - the last changes of the addresses, see WithHistory
+	history history
+
+	// chain - the CNAME chain of the last answer
+	chain cnameChain
+
+	// held4, held6 - addresses kept by the hold-down though absent from the last answers
+	held4, held6 heldAddrs
+
+	// mapped4 - IPv4-mapped addresses of the last AAAA answer moved to IPv4 addresses, see MappedToV4
+	mapped4 mappedAddrs
+
 	dnsClient *dnsClient
 	logger    logApi.Logger
 
 	ready  sync.WaitGroup
 	stopCh chan struct{}
 
+	// readyFlag - set to 1 when the first resolution is done
+	readyFlag int32
+
+	// tasks - scheduled refreshes of the host, guarded by the scheduler mutex
+	tasks []*refreshTask
+
+	// suspended, suspendedFlag - due refreshes suspended until the next lookup, see WithLazyRefresh.
+	// suspended is guarded by the scheduler mutex, resumeMu makes lookups wait for the resumed refreshes
+	suspended     []*refreshTask
+	suspendedFlag int32
+	resumeMu      sync.Mutex
+
+	// refreshTime - unix time of the last refresh
+	refreshTime int64
+
+	// apex - the apex of the host if its refreshes are coalesced, guarded by the scheduler mutex
+	apex string
+
+	// readyWait - the total time in nanoseconds callers blocked waiting for the first resolution
+	readyWait int64
+
+	// class - the HostClass of the host
+	class int32
+
+	// nxdomain - set to 1 when the last lookup found the name does not exist
+	nxdomain int32
+
 	static bool
 }
 
-func newStaticHost(tag string, hName string, eaFlag bool, mapings map[string][]string, logger logApi.Logger) *host {
+func newStaticHost(tag string, hName string, eaFlag bool, mapings map[string][]string, clock Clock, logger logApi.Logger) *host {
 	h := &host{
-		tag:      tag,
-		hostName: hName,
-		eaFlag:   eaFlag,
-		ip4:      newIpsFromList(mapings["ip4"]),
-		ip6:      newIpsFromList(mapings["ip6"]),
-		lastTime: time.Now().Unix(),
-		static:   true,
-		logger:   logger,
+		tag:         tag,
+		hostName:    hName,
+		eaFlag:      eaFlag,
+		hostOptions: hostOptions{clock: clock},
+		ip4:         newIpsFromList(mapings["ip4"]),
+		ip6:         newIpsFromList(mapings["ip6"]),
+		lastTime:    clock.Now().Unix(),
+		static:      true,
+		logger:      logger,
+		readyFlag:   1,
+		version:     1,
 	}
+	src := Source{Transport: TransportStatic, Time: h.clock.Now()}
+	h.sources.set(FamilyAll, src, src)
 	return h
 }
 
+// internName returns a copy of a host name stored once as the key of the hosts map and the name
+// of the host, so the name does not keep alive a larger string it was sliced from, e.g. a request
+func internName(hostName string) string {
+	return string([]byte(hostName))
+}
+
 // newHost ...
-func newHost(tag string, hName string, eaFlag bool, dnsClient *dnsClient, logger logApi.Logger) *host {
+func newHost(tag string, hName string, eaFlag bool, opts hostOptions, dnsClient *dnsClient, logger logApi.Logger) *host {
 	h := &host{
-		tag:       tag,
-		hostName:  hName,
-		eaFlag:    eaFlag,
-		ip4:       newIps(),
-		ip6:       newIps(),
-		lastTime:  time.Now().Unix(),
-		dnsClient: dnsClient,
-		logger:    logger,
-		stopCh:    make(chan struct{}),
+		tag:         tag,
+		hostName:    hName,
+		eaFlag:      eaFlag,
+		hostOptions: opts,
+		ip4:         newIps(),
+		ip6:         newIps(),
+		lastTime:    opts.clock.Now().Unix(),
+		dnsClient:   dnsClient,
+		logger:      logger,
+		stopCh:      make(chan struct{}),
 	}
 
 	h.ready.Add(1)
-	go h.reloadIPsLoop()
 
 	return h
 }
 
-// getNextIP4WithIndex ...
-func (h *host) getNextIP4WithIndex() (net.IP, int) {
-	h.ready.Wait()
-	defer h.updLastTime()
-	return h.ip4.getNextIPWithIndex()
+// start starts maintaining of the host, it is called once for the host stored in the resolver
+func (h *host) start() {
+	h.scheduler.start(h)
 }
 
-// getNextIP6WithIndex ...
-func (h *host) getNextIP6WithIndex() (net.IP, int) {
-	h.ready.Wait()
+// resume refreshes the host if its refreshes are suspended, see WithLazyRefresh
+func (h *host) resume() {
+	if h.scheduler != nil {
+		h.scheduler.resume(h)
+	}
+}
+
+// getNextIPWithIndex returns next IPv6 if family is FamilyV6 and next IPv4 otherwise,
+// if fallback is set and there are no addresses of family the other family is used
+func (h *host) getNextIPWithIndex(family Family, fallback bool) (netip.Addr, int) {
+	h.awaitReady()
+	h.resume()
 	defer h.updLastTime()
-	return h.ip6.getNextIPWithIndex()
+
+	first, second := h.ip4, h.ip6
+	firstFamily, secondFamily := FamilyV4, FamilyV6
+	if family == FamilyV6 {
+		first, second = h.ip6, h.ip4
+		firstFamily, secondFamily = FamilyV6, FamilyV4
+	}
+	now := h.clock.Now()
+	ip, idx := netip.Addr{}, 0
+	if !h.hardExpired(firstFamily, now) {
+		ip, idx = first.getNextIPWithIndex(now)
+	}
+	if !ip.IsValid() && fallback && !h.hardExpired(secondFamily, now) {
+		ip, idx = second.getNextIPWithIndex(now)
+	}
+	return ip, idx
 }
 
-// getIPs ...
-func (h *host) getIPs() ([]net.IP, []net.IP) {
-	h.ready.Wait()
-	return h.ip4.getList(), h.ip6.getList()
+// getIPs returns addresses which are not past their hard expiry
+func (h *host) getIPs() (ip4 []net.IP, ip6 []net.IP) {
+	h.awaitReady()
+	now := h.clock.Now()
+	if !h.hardExpired(FamilyV4, now) {
+		ip4 = h.ip4.getList()
+	}
+	if !h.hardExpired(FamilyV6, now) {
+		ip6 = h.ip6.getList()
+	}
+	return ip4, ip6
 }
 
-// reloadIPsLoop ...
-func (h *host) reloadIPsLoop() {
-	ttl := h.reloadIPs()
-	h.ready.Done()
+// getIPAddrs returns addresses with IPv6 zones which are not past their hard expiry
+func (h *host) getIPAddrs() (ip4 []net.IPAddr, ip6 []net.IPAddr) {
+	h.awaitReady()
+	now := h.clock.Now()
+	if !h.hardExpired(FamilyV4, now) {
+		ip4 = h.ip4.getAddrList()
+	}
+	if !h.hardExpired(FamilyV6, now) {
+		ip6 = h.ip6.getAddrList()
+	}
+	return ip4, ip6
+}
 
-	ttlCh := time.After(time.Duration(ttl) * time.Second)
-	for {
-		select {
-		case <-h.stopCh:
-			h.logger.Info().Println(h.tag, "Stop resolving host", h.hostName)
-			return
-		case <-ttlCh:
-			ttl = h.reloadIPs()
-			ttlCh = time.After(time.Duration(ttl) * time.Second)
+// reloadIPs reloads addresses of family and returns refresh intervals of IPv4 and IPv6 addresses,
+// intervals of families not reloaded are undefined
+func (h *host) reloadIPs(ctx context.Context, family Family) (uint32, uint32) {
+	l, err := h.dnsClient.lookupHostShared(ctx, h.hostName, family, h.secure())
+	if err == nil {
+		if err = h.policy.checkExpected(l); err != nil {
+			atomic.AddUint64(&h.dnsClient.cfg.unexpectedAnswers, 1)
 		}
 	}
+	h.setErr(err)
+	if err != nil {
+		logError(h.logger, h.tag, "Error reloading ips for host", h.logName(), h.logErr(err))
+		return retryIntervalSec, retryIntervalSec
+	}
+	ttl4, ttl6 := h.applyLookup(family, l)
+	h.peers.publish(h.hostName, family, l)
+	return ttl4, ttl6
 }
 
-// reloadIPs ...
-func (h *host) reloadIPs() uint32 {
-	ip4, ip6, ttl, err := h.dnsClient.lookupHost(context.Background(), h.hostName)
-	if err != nil {
-		h.logger.Error().Println(h.tag, "Error reloading ips for host", h.hostName, err)
-		return retryIntervalSec
+// applyLookup sets addresses of family from l and returns their refresh intervals
+func (h *host) applyLookup(family Family, l hostLookup) (uint32, uint32) {
+	l = h.normalizeMapped(family, l)
+	h.sources.set(family, l.src4, l.src6)
+	if l.nxdomain {
+		atomic.StoreInt32(&h.nxdomain, 1)
+	} else {
+		atomic.StoreInt32(&h.nxdomain, 0)
 	}
+	ttl4, ttl6 := l.ttl4, l.ttl6
 
-	h.ip4.setIpList(ip4)
-	h.ip6.setIpList(ip6)
+	now := h.clock.Now().Unix()
+	changed := false
+	if family.hasV4() {
+		ttl4 = h.adjustTtl(ttl4)
+		changed = h.setIPs(FamilyV4, h.ip4, l.ip4, ttl4, l.src4) || changed
+		atomic.StoreInt64(&h.expireTime4, now+int64(ttl4))
+		h.setHardExpiry(FamilyV4, now, ttl4)
+	}
+	if family.hasV6() {
+		ttl6 = h.adjustTtl(ttl6)
+		changed = h.setIPs(FamilyV6, h.ip6, l.ip6, ttl6, l.src6) || changed
+		atomic.StoreInt64(&h.expireTime6, now+int64(ttl6))
+		h.setHardExpiry(FamilyV6, now, ttl6)
+	}
+	if changed {
+		atomic.AddUint64(&h.version, 1)
+		h.notifyChange()
+	}
+	h.updateChain(l.chain, changed)
+
+	return ttl4, ttl6
+}
 
+// setIPs prepares and sets addresses of family resolved from src valid for ttl seconds,
+// reports whether the set of addresses has changed
+func (h *host) setIPs(family Family, s *ips, ipList []net.IP, ttl uint32, src Source) bool {
+	var old []net.IP
+	if len(h.ipsetFuncs) > 0 || h.historySize > 0 || h.store != nil {
+		old = s.getList()
+	}
+	if n := h.holdDownRefreshes(); n > 1 {
+		hd := &h.held4
+		if family == FamilyV6 {
+			hd = &h.held6
+		}
+		ipList = hd.apply(n, s.getList(), ipList)
+	}
+	ipList = h.prepare(h.hostName, ipList)
+	changed := s.setIpList(family, ipList)
+	if len(h.ipsetFuncs) > 0 {
+		h.notifyIPSet(old, ipList, ttl)
+	}
+	if changed && (h.historySize > 0 || h.store != nil) {
+		h.recordChange(family, old, ipList, src)
+	}
+	return changed
+}
+
+// recordChange adds the change of addresses of family from old to cur to the history and the store
+func (h *host) recordChange(family Family, old, cur []net.IP, src Source) {
+	ch, ok := newAddressChange(family, old, cur, src)
+	if !ok {
+		return
+	}
+	if h.historySize > 0 {
+		h.history.add(h.historySize, ch)
+	}
+	if h.store != nil {
+		if err := h.store.AddChange(HostChange{Host: h.logName(), AddressChange: ch}); err != nil {
+			logError(h.logger, h.tag, "Error storing address change of host", h.logName(), h.logErr(err))
+		}
+	}
+}
+
+// adjustTtl applies the policy and the override to an upstream ttl
+func (h *host) adjustTtl(ttl uint32) uint32 {
+	ttl = h.policy.clampTtl(ttl)
+	if h.ttlOverride > 0 {
+		ttl = uint32(h.ttlOverride / time.Second)
+	}
 	return ttl
 }
 
-// isOld ...
-func (h *host) isOld() bool {
-	lastTime := atomic.LoadInt64(&h.lastTime)
-	return lastTime < time.Now().Add(-oldHostDuration).Unix()
+// remainingTtl returns the number of seconds left until the addresses of family expire,
+// for FamilyAll the earliest expiration is used
+func (h *host) remainingTtl(family Family) uint32 {
+	if h.static {
+		return defaultTtl
+	}
+	var expire int64
+	switch family {
+	case FamilyV4:
+		expire = atomic.LoadInt64(&h.expireTime4)
+	case FamilyV6:
+		expire = atomic.LoadInt64(&h.expireTime6)
+	default:
+		expire = atomic.LoadInt64(&h.expireTime4)
+		if e6 := atomic.LoadInt64(&h.expireTime6); expire == 0 || (e6 != 0 && e6 < expire) {
+			expire = e6
+		}
+	}
+	left := expire - h.clock.Now().Unix()
+	if left < 0 {
+		return 0
+	}
+	return uint32(left)
+}
+
+// changes returns a channel which is closed on the next change of the addresses
+func (h *host) changes() <-chan struct{} {
+	h.changeMu.Lock()
+	defer h.changeMu.Unlock()
+	if h.changeCh == nil {
+		h.changeCh = make(chan struct{})
+	}
+	return h.changeCh
+}
+
+// notifyChange ...
+func (h *host) notifyChange() {
+	h.changeMu.Lock()
+	defer h.changeMu.Unlock()
+	if h.changeCh != nil {
+		close(h.changeCh)
+		h.changeCh = nil
+	}
+}
+
+// secure reports whether answers for the host must be DNSSEC-validated
+func (h *host) secure() bool {
+	return h.policy.requireDNSSEC() && (h.anchors == nil || !h.anchors.isNegative(h.hostName))
+}
+
+// setErr ...
+func (h *host) setErr(err error) {
+	h.errMu.Lock()
+	defer h.errMu.Unlock()
+	h.err = err
+}
+
+// getErr returns the error of the last reload wrapped with ErrStale if addresses are past their hard expiry
+func (h *host) getErr() error {
+	h.errMu.RLock()
+	err := h.err
+	h.errMu.RUnlock()
+	return h.staleErr(err)
+}
+
+// getVersion ...
+func (h *host) getVersion() uint64 {
+	return atomic.LoadUint64(&h.version)
+}
+
+// expiry returns the expiration time of the addresses of family,
+// zero time for static hosts and families never resolved
+func (h *host) expiry(family Family) time.Time {
+	var expire int64
+	switch family {
+	case FamilyV4:
+		expire = atomic.LoadInt64(&h.expireTime4)
+	case FamilyV6:
+		expire = atomic.LoadInt64(&h.expireTime6)
+	}
+	if expire == 0 {
+		return time.Time{}
+	}
+	return time.Unix(expire, 0)
+}
+
+// awaitReady waits for the first resolution of the host and returns the time it blocked for
+func (h *host) awaitReady() time.Duration {
+	if h.isReady() {
+		return 0
+	}
+	start := h.clock.Now()
+	h.ready.Wait()
+	return h.countReadyWait(start)
+}
+
+// countReadyWait adds the time blocked since start to the ready wait time of the host and returns it
+func (h *host) countReadyWait(start time.Time) time.Duration {
+	d := h.clock.Now().Sub(start)
+	if d <= 0 {
+		return 0
+	}
+	atomic.AddInt64(&h.readyWait, int64(d))
+	if h.scheduler != nil {
+		atomic.AddInt64(&h.scheduler.readyWait, int64(d))
+	}
+	return d
+}
+
+// getReadyWait ...
+func (h *host) getReadyWait() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.readyWait))
+}
+
+// waitReady waits for the first resolution of the host until ctx is done,
+// returns the time it blocked for
+func (h *host) waitReady(ctx context.Context) (time.Duration, error) {
+	if h.isReady() {
+		return 0, nil
+	}
+
+	start := h.clock.Now()
+	done := make(chan struct{})
+	h.scheduler.goroutines.spawn("wait-ready", h.logName(), func() {
+		defer h.scheduler.trackWaiter()()
+		h.ready.Wait()
+		close(done)
+	})
+	select {
+	case <-done:
+		return h.countReadyWait(start), nil
+	case <-ctx.Done():
+		return h.countReadyWait(start), ctx.Err()
+	}
+}
+
+// markReady marks the first resolution done
+func (h *host) markReady() {
+	if atomic.CompareAndSwapInt32(&h.readyFlag, 0, 1) {
+		h.ready.Done()
+	}
+}
+
+// isReady reports whether the first resolution is done
+func (h *host) isReady() bool {
+	return atomic.LoadInt32(&h.readyFlag) == 1
 }
 
 // isExplicitlyAdded ...
 func (h *host) isExplicitlyAdded() bool {
-	return h.eaFlag
+	return h.eaFlag || atomic.LoadInt32(&h.promoted) == 1
+}
+
+// promote makes the host explicitly added, reports whether it was not before
+func (h *host) promote() bool {
+	return !h.eaFlag && atomic.CompareAndSwapInt32(&h.promoted, 0, 1)
+}
+
+// markBad removes ip from rotation for cooldown, reports whether the host has the address
+func (h *host) markBad(ip net.IP, cooldown time.Duration) bool {
+	until := h.clock.Now().Add(cooldown)
+	// an IPv4 address may be kept in the IPv4-mapped form with IPv6 addresses, see Policy.MappedV4
+	bad4 := h.ip4.markBad(ip, until)
+	bad6 := h.ip6.markBad(ip, until)
+	return bad4 || bad6
+}
+
+// countDial increments the number of dials and returns it
+func (h *host) countDial() uint64 {
+	return atomic.AddUint64(&h.dials, 1)
 }
 func (h *host) isStatic() bool {
 	return h.static
 }
 
-// stop ...
+// stop stops refreshing of the host
 func (h *host) stop() {
+	if h.stopCh == nil {
+		return
+	}
 	close(h.stopCh)
+	h.scheduler.cancel(h)
+	h.expireIPSet()
+	logInfo(h.logger, h.tag, "Stop resolving host", h.logName())
+}
+
+// isStopped ...
+func (h *host) isStopped() bool {
+	select {
+	case <-h.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// removeTask removes t from scheduled refreshes of the host, the scheduler mutex must be held
+func (h *host) removeTask(t *refreshTask) {
+	for i, ht := range h.tasks {
+		if ht == t {
+			h.tasks = append(h.tasks[:i], h.tasks[i+1:]...)
+			return
+		}
+	}
 }
 
 // updLastTime ...
 func (h *host) updLastTime() {
-	atomic.StoreInt64(&h.lastTime, time.Now().Unix())
+	atomic.StoreInt64(&h.lastTime, h.clock.Now().Unix())
+	atomic.AddUint64(&h.lookups, 1)
+}
+
+// getLookups ...
+func (h *host) getLookups() uint64 {
+	return atomic.LoadUint64(&h.lookups)
 }
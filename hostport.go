@@ -0,0 +1,45 @@
+package resolver
+
+import (
+	"net"
+	"strings"
+)
+
+// hostOnly returns the host of host:port or [host]:port, other names including IPv6 addresses
+// without brackets are returned as is
+func hostOnly(name string) string {
+	if strings.IndexByte(name, ':') < 0 {
+		return name
+	}
+	host, _, err := net.SplitHostPort(name)
+	if err != nil {
+		return name
+	}
+	return host
+}
+
+// GetNextHostPort returns next IPv4 for the host of hostPort joined with its port, e.g. "10.0.0.1:443"
+// for "example.com:443", so the result can be dialed as is. An IPv6 address returned due to the options
+// is put in brackets. hostPort without a port returns the address only, an empty string is returned
+// if the host has no addresses
+func (r *Resolver) GetNextHostPort(hostPort string, opts ...QueryOption) string {
+	return r.nextHostPort(hostPort, FamilyV4, opts)
+}
+
+// GetNextHostPort6 returns next IPv6 for the host of hostPort joined with its port, see GetNextHostPort
+func (r *Resolver) GetNextHostPort6(hostPort string, opts ...QueryOption) string {
+	return r.nextHostPort(hostPort, FamilyV6, opts)
+}
+
+// nextHostPort ...
+func (r *Resolver) nextHostPort(hostPort string, family Family, opts []QueryOption) string {
+	hostName, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		hostName, port = hostPort, ""
+	}
+	ip, _ := r.getNextIPWithIdx(hostName, family, newQueryOptions(opts))
+	if ip == "" || port == "" {
+		return ip
+	}
+	return net.JoinHostPort(ip, port)
+}
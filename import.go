@@ -0,0 +1,194 @@
+package resolver
+
+import (
+	"io"
+	"math"
+	"net"
+	"sort"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// importedAddrs - addresses of an owner name of imported records and their least TTLs
+type importedAddrs struct {
+	ip4, ip6   []net.IP
+	ttl4, ttl6 uint32
+}
+
+// ImportRRs - seeds the cache with A, AAAA and CNAME records of rrs, e.g. parsed from a dig answer
+// to reproduce an issue or from a fixture: every owner name of A or AAAA records and every name
+// aliased to one by CNAME records becomes an explicitly added host with the addresses, valid for
+// the least TTL of its records and chain, and refreshed when it expires. A family of the host
+// without records is set empty. Addresses of maintained hosts are replaced and their refreshes
+// deferred likewise, static hosts and hosts being resolved for the first time are skipped.
+// Other records are ignored. Returns the number of hosts seeded
+func (r *Resolver) ImportRRs(rrs []dns.RR) int {
+	addrs := make(map[string]*importedAddrs)
+	cnames := make(map[string]CNAMELink)
+	get := func(name string) *importedAddrs {
+		a := addrs[name]
+		if a == nil {
+			a = &importedAddrs{ttl4: math.MaxUint32, ttl6: math.MaxUint32}
+			addrs[name] = a
+		}
+		return a
+	}
+	for _, rr := range rrs {
+		switch rr := rr.(type) {
+		case *dns.A:
+			a := get(normalizeName(rr.Hdr.Name))
+			a.ip4 = append(a.ip4, rr.A)
+			if rr.Hdr.Ttl < a.ttl4 {
+				a.ttl4 = rr.Hdr.Ttl
+			}
+		case *dns.AAAA:
+			a := get(normalizeName(rr.Hdr.Name))
+			a.ip6 = append(a.ip6, rr.AAAA)
+			if rr.Hdr.Ttl < a.ttl6 {
+				a.ttl6 = rr.Hdr.Ttl
+			}
+		case *dns.CNAME:
+			name := normalizeName(rr.Hdr.Name)
+			cnames[name] = CNAMELink{Name: name, Target: normalizeName(rr.Target), TTL: rr.Hdr.Ttl}
+		}
+	}
+
+	names := make([]string, 0, len(addrs)+len(cnames))
+	for name := range addrs {
+		if _, ok := cnames[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for name := range cnames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := r.clock.Now()
+	src := Source{Transport: TransportImport, Time: now}
+	seeded := 0
+	for _, name := range names {
+		l, ok := importedLookup(name, addrs, cnames)
+		if !ok {
+			continue
+		}
+		l.src4, l.src6 = src, src
+		if r.importHost(name, l) {
+			seeded++
+		}
+	}
+	logInfo(r.logger, r.tag, "Imported hosts:", seeded, "of", len(names))
+	return seeded
+}
+
+// importedLookup follows CNAME records from name to the addresses it is aliased to,
+// ok is false if there are none or the chain loops
+func importedLookup(name string, addrs map[string]*importedAddrs, cnames map[string]CNAMELink) (hostLookup, bool) {
+	var l hostLookup
+	ttl := uint32(math.MaxUint32)
+	seen := make(map[string]bool)
+	for {
+		if seen[name] {
+			return l, false
+		}
+		seen[name] = true
+		link, ok := cnames[name]
+		if !ok {
+			break
+		}
+		l.chain = append(l.chain, link)
+		if link.TTL < ttl {
+			ttl = link.TTL
+		}
+		name = link.Target
+	}
+	a := addrs[name]
+	if a == nil {
+		return l, false
+	}
+
+	l.ip4, l.ip6 = a.ip4, a.ip6
+	l.ttl4, l.ttl6 = a.ttl4, a.ttl6
+	// an absent family is as valid as the present one, like a NODATA answer
+	if len(l.ip4) == 0 {
+		l.ttl4 = l.ttl6
+	}
+	if len(l.ip6) == 0 {
+		l.ttl6 = l.ttl4
+	}
+	if ttl < l.ttl4 {
+		l.ttl4 = ttl
+	}
+	if ttl < l.ttl6 {
+		l.ttl6 = ttl
+	}
+	l.ttl4, l.ttl6 = floorTtl(l.ttl4), floorTtl(l.ttl6)
+	return l, true
+}
+
+// importHost sets addresses of hostName from l, adding the host without resolving it
+// if it is not maintained, reports whether the host was seeded
+func (r *Resolver) importHost(hostName string, l hostLookup) bool {
+	if _, policy := r.hostClient(hostName); policy.resolvesAddrs() {
+		if err := policy.checkExpected(l); err != nil {
+			logError(r.logger, r.tag, "Rejected imported records of host", r.clientCfg.privacy.redact(hostName),
+				r.clientCfg.privacy.redactErr(err, hostName))
+			return false
+		}
+	}
+
+	r.mu.Lock()
+	h, ok := r.hosts[hostName]
+	if !ok {
+		if r.Stopped() {
+			r.mu.Unlock()
+			return false
+		}
+		h = r.newHost(internName(hostName), true)
+		r.hosts[h.hostName] = h
+	}
+	r.mu.Unlock()
+
+	if ok && (h.static || h.isStopped() || !h.isReady() || !h.policy.resolvesAddrs()) {
+		return false
+	}
+
+	family := h.policy.family()
+	var ttl4, ttl6 uint32
+	if h.policy.resolvesAddrs() {
+		h.setErr(nil)
+		ttl4, ttl6 = h.applyLookup(family, l)
+	}
+	if ok {
+		now := h.clock.Now()
+		if family.hasV4() {
+			h.scheduler.reschedule(h, FamilyV4, now.Add(h.refreshDelay(ttl4)))
+		}
+		if family.hasV6() {
+			h.scheduler.reschedule(h, FamilyV6, now.Add(h.refreshDelay(ttl6)))
+		}
+		return true
+	}
+
+	atomic.StoreInt64(&h.refreshTime, h.clock.Now().Unix())
+	h.scheduler.seed(h, ttl4, ttl6)
+	h.markReady()
+	return true
+}
+
+// ImportZoneText parses RR text in the zone file format, e.g. the answer section of dig output,
+// and seeds the cache with the records like ImportRRs. Relative names are relative to the root,
+// $ORIGIN and $TTL directives are supported. Returns the number of hosts seeded and the first
+// parse error, nothing is imported if there is one
+func (r *Resolver) ImportZoneText(rd io.Reader) (int, error) {
+	zp := dns.NewZoneParser(rd, ".", "")
+	var rrs []dns.RR
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+	}
+	if err := zp.Err(); err != nil {
+		return 0, err
+	}
+	return r.ImportRRs(rrs), nil
+}
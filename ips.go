@@ -2,30 +2,33 @@ package resolver
 
 import (
 	"net"
+	"net/netip"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
-// ips ...
+// ips - addresses of a family stored as netip.Addr values which need no allocations of their own
 type ips struct {
 	mu     sync.RWMutex
 	ipIdx  uint64
-	ipList []net.IP
+	ipList []netip.Addr
+
+	// badUntil - addresses removed from rotation until the time
+	badUntil map[netip.Addr]time.Time
 }
 
 // newIps ...
 func newIps() *ips {
-	return &ips{
-		ipList: make([]net.IP, 0),
-	}
+	return &ips{}
 }
 
+// newIpsFromList creates ips from a list of addresses, IPv6 addresses may have a zone (fe80::1%eth0)
 func newIpsFromList(listIP []string) *ips {
-	result := make([]net.IP, 0, len(listIP))
+	result := make([]netip.Addr, 0, len(listIP))
 	for _, v := range listIP {
-		ip := net.ParseIP(v)
-		if ip != nil {
-			result = append(result, ip)
+		if addr, ok := parseIPAddr(v); ok {
+			result = append(result, addr)
 		}
 	}
 	return &ips{
@@ -33,33 +36,187 @@ func newIpsFromList(listIP []string) *ips {
 	}
 }
 
-// setIpList ...
-func (i *ips) setIpList(ipList []net.IP) {
+// parseIPAddr parses an address with an optional IPv6 zone, IPv4-mapped IPv6 addresses stay IPv6
+func parseIPAddr(s string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(s)
+	return addr, err == nil
+}
+
+// addrFromIP converts ip to netip.Addr as it is, an IPv4 address in the 16-byte form becomes
+// an IPv4-mapped IPv6 address, see Policy.MappedV4
+func addrFromIP(ip net.IP) netip.Addr {
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr
+}
+
+// ipKey returns ip as netip.Addr comparing equal regardless of the form of IPv4 addresses,
+// an IPv4-mapped IPv6 address is unmapped
+func ipKey(ip net.IP) netip.Addr {
+	return addrFromIP(ip).Unmap()
+}
+
+// setIpList sets the list of addresses of family and reports whether the set of addresses has changed.
+// IPv4 addresses in the 16-byte form are stored in the 4-byte one, IPv6 addresses are stored as they are
+func (i *ips) setIpList(family Family, ipList []net.IP) bool {
+	addrList := make([]netip.Addr, 0, len(ipList))
+	for _, ip := range ipList {
+		if ip4 := ip.To4(); family == FamilyV4 && ip4 != nil {
+			ip = ip4
+		}
+		addrList = append(addrList, addrFromIP(ip))
+	}
+
 	i.mu.Lock()
 	defer i.mu.Unlock()
-	i.ipList = ipList
+	changed := !sameAddrs(i.ipList, addrList)
+	i.ipList = addrList
+	return changed
 }
 
-// getNextIPWithIndex ...
-func (i *ips) getNextIPWithIndex() (net.IP, int) {
+// remove removes addr from the list, an IPv4 address matches its IPv4-mapped form, reports whether it was there
+func (i *ips) remove(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for n, a := range i.ipList {
+		if a.Unmap() == addr {
+			ipList := make([]netip.Addr, 0, len(i.ipList)-1)
+			ipList = append(ipList, i.ipList[:n]...)
+			i.ipList = append(ipList, i.ipList[n+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// getNextIPWithIndex returns the next address skipping ones marked bad at now,
+// if all addresses are bad the next one is returned anyway. The address is invalid if the list is empty
+func (i *ips) getNextIPWithIndex(now time.Time) (netip.Addr, int) {
 	i.mu.RLock()
+	defer i.mu.RUnlock()
 	if len(i.ipList) == 0 {
-		i.mu.RUnlock()
-		return nil, 0
+		return netip.Addr{}, 0
+	}
+
+	first := -1
+	for n := 0; n < len(i.ipList); n++ {
+		idx := int((atomic.AddUint64(&i.ipIdx, 1) - 1) % uint64(len(i.ipList)))
+		if first < 0 {
+			first = idx
+		}
+		if !i.isBad(i.ipList[idx], now) {
+			return i.ipList[idx], idx
+		}
+	}
+	return i.ipList[first], first
+}
+
+// markBad removes ip from rotation until the time, an IPv4 address matches its IPv4-mapped form,
+// reports whether ip is in the list
+func (i *ips) markBad(ip net.IP, until time.Time) bool {
+	bad := ipKey(ip)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, addr := range i.ipList {
+		if addr = addr.WithZone(""); addr.Unmap() == bad {
+			if i.badUntil == nil {
+				i.badUntil = make(map[netip.Addr]time.Time)
+			}
+			i.badUntil[addr] = until
+			return true
+		}
 	}
+	return false
+}
 
-	idx := i.ipIdx % uint64(len(i.ipList))
-	ipRet := i.ipList[idx]
-	i.mu.RUnlock()
+// isMarkedBad reports whether ip is marked bad at now, an IPv4 address matches its IPv4-mapped form
+func (i *ips) isMarkedBad(ip net.IP, now time.Time) bool {
+	key := ipKey(ip)
 
-	atomic.AddUint64(&i.ipIdx, 1)
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	for addr, until := range i.badUntil {
+		if addr.Unmap() == key && now.Before(until) {
+			return true
+		}
+	}
+	return false
+}
 
-	return ipRet, int(idx)
+// isBad must be called with i.mu held
+func (i *ips) isBad(addr netip.Addr, now time.Time) bool {
+	until, ok := i.badUntil[addr.WithZone("")]
+	return ok && now.Before(until)
 }
 
-// getList ...
+// getList returns addresses without zones, they share one buffer
 func (i *ips) getList() []net.IP {
 	i.mu.RLock()
 	defer i.mu.RUnlock()
-	return i.ipList
+	if len(i.ipList) == 0 {
+		return nil
+	}
+	buf := i.buffer()
+	ret := make([]net.IP, len(i.ipList))
+	for n, addr := range i.ipList {
+		ret[n], buf = appendIP(buf, addr)
+	}
+	return ret
+}
+
+// getAddrList returns addresses with zones, they share one buffer
+func (i *ips) getAddrList() []net.IPAddr {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if len(i.ipList) == 0 {
+		return nil
+	}
+	buf := i.buffer()
+	ret := make([]net.IPAddr, len(i.ipList))
+	for n, addr := range i.ipList {
+		ret[n].IP, buf = appendIP(buf, addr)
+		ret[n].Zone = addr.Zone()
+	}
+	return ret
+}
+
+// buffer returns an empty buffer fitting all addresses, i.mu must be held
+func (i *ips) buffer() []byte {
+	size := 0
+	for _, addr := range i.ipList {
+		size += addr.BitLen() / 8
+	}
+	return make([]byte, 0, size)
+}
+
+// appendIP appends addr to buf and returns it as net.IP sharing buf and the rest of buf
+func appendIP(buf []byte, addr netip.Addr) (net.IP, []byte) {
+	n := addr.BitLen() / 8
+	if addr.Is4() {
+		a := addr.As4()
+		buf = append(buf, a[:]...)
+	} else {
+		a := addr.As16()
+		buf = append(buf, a[:]...)
+	}
+	return net.IP(buf[:n:n]), buf[n:]
+}
+
+// sameAddrs reports whether a and b contain the same addresses regardless of order
+func sameAddrs(a, b []netip.Addr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[netip.Addr]int, len(a))
+	for _, addr := range a {
+		seen[addr]++
+	}
+	for _, addr := range b {
+		if seen[addr] == 0 {
+			return false
+		}
+		seen[addr]--
+	}
+	return true
 }
@@ -0,0 +1,167 @@
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// storedHosts - the number of hosts the memory benchmarks store
+const storedHosts = 100000
+
+// heapInUse returns the bytes of live heap objects after a collection
+func heapInUse() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// hostAddrs returns two IPv4 and one IPv6 address of host n as they are unpacked from answers
+func hostAddrs(n int) (ip4, ip6 []net.IP) {
+	ip4 = []net.IP{
+		net.IPv4(10, byte(n>>16), byte(n>>8), byte(n)).To4(),
+		net.IPv4(11, byte(n>>16), byte(n>>8), byte(n)).To4(),
+	}
+	ip6 = []net.IP{net.ParseIP(fmt.Sprintf("2001:db8::%x", n))}
+	return ip4, ip6
+}
+
+// netIPAddrIps - ips as they were before addresses were stored as netip.Addr, the baseline of BenchmarkAddrStorage
+type netIPAddrIps struct {
+	mu       sync.RWMutex
+	ipIdx    uint64
+	ipList   []net.IPAddr
+	badUntil map[string]time.Time
+}
+
+// BenchmarkAddrStorage reports heap bytes per host of addresses stored as net.IPAddr values,
+// the form used before netip.Addr, and as netip.Addr values of ips
+func BenchmarkAddrStorage(b *testing.B) {
+	b.Run("net.IPAddr", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			before := heapInUse()
+			stored := make([][2]netIPAddrIps, storedHosts)
+			for n := range stored {
+				ip4, ip6 := hostAddrs(n)
+				for f, list := range [][]net.IP{ip4, ip6} {
+					addrs := make([]net.IPAddr, 0, len(list))
+					for _, ip := range list {
+						addrs = append(addrs, net.IPAddr{IP: append(net.IP(nil), ip...)})
+					}
+					stored[n][f].ipList = addrs
+				}
+			}
+			b.ReportMetric(float64(heapInUse()-before)/storedHosts, "B/host")
+			runtime.KeepAlive(stored)
+		}
+	})
+	b.Run("netip.Addr", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			before := heapInUse()
+			stored := make([][2]ips, storedHosts)
+			for n := range stored {
+				ip4, ip6 := hostAddrs(n)
+				stored[n][0].setIpList(FamilyV4, ip4)
+				stored[n][1].setIpList(FamilyV6, ip6)
+			}
+			b.ReportMetric(float64(heapInUse()-before)/storedHosts, "B/host")
+			runtime.KeepAlive(stored)
+		}
+	})
+}
+
+// BenchmarkStaticHostsMemory reports heap bytes per static host with two IPv4 and one IPv6 address
+func BenchmarkStaticHostsMemory(b *testing.B) {
+	mapping := make(map[string]map[string][]string, storedHosts)
+	for n := 0; n < storedHosts; n++ {
+		ip4, ip6 := hostAddrs(n)
+		mapping[fmt.Sprintf("h%d.bench.test", n)] = map[string][]string{
+			"ip4": {ip4[0].String(), ip4[1].String()},
+			"ip6": {ip6[0].String()},
+		}
+	}
+	for i := 0; i < b.N; i++ {
+		r := New("bench", nil)
+		before := heapInUse()
+		r.UpdateHostsFromMaping(mapping)
+		b.ReportMetric(float64(heapInUse()-before)/storedHosts, "B/host")
+		r.Stop()
+	}
+}
+
+// BenchmarkGetNextIPWithIndex measures the rotation, it must not allocate
+func BenchmarkGetNextIPWithIndex(b *testing.B) {
+	var s ips
+	ip4, _ := hostAddrs(1)
+	s.setIpList(FamilyV4, ip4)
+	now := time.Now()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if addr, _ := s.getNextIPWithIndex(now); !addr.IsValid() {
+			b.Fatal("no address")
+		}
+	}
+}
+
+// BenchmarkGetList measures copying addresses out, it allocates the list and one buffer
+func BenchmarkGetList(b *testing.B) {
+	var s ips
+	_, ip6 := hostAddrs(1)
+	s.setIpList(FamilyV6, append(ip6, net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if len(s.getList()) != 3 {
+			b.Fatal("wrong list")
+		}
+	}
+}
+
+func TestIpsKeepMappedAddresses(t *testing.T) {
+	var s ips
+	mapped := net.ParseIP("::ffff:192.0.2.1")
+	s.setIpList(FamilyV6, []net.IP{mapped, net.ParseIP("2001:db8::1")})
+
+	addr, _ := s.getNextIPWithIndex(time.Now())
+	if !addr.Is4In6() {
+		t.Fatalf("got %s, want the IPv4-mapped address", addr)
+	}
+	if list := s.getList(); len(list[0]) != net.IPv6len {
+		t.Fatalf("got %d bytes, want %d", len(list[0]), net.IPv6len)
+	}
+
+	// the plain IPv4 form marks the mapped address bad
+	now := time.Now()
+	if !s.markBad(net.IPv4(192, 0, 2, 1), now.Add(time.Minute)) {
+		t.Fatal("mapped address is not marked bad")
+	}
+	for i := 0; i < 4; i++ {
+		if addr, _ := s.getNextIPWithIndex(now); addr != netip.MustParseAddr("2001:db8::1") {
+			t.Fatalf("got %s, want 2001:db8::1", addr)
+		}
+	}
+
+	if !s.remove(netip.MustParseAddr("192.0.2.1")) || len(s.getList()) != 1 {
+		t.Fatal("mapped address is not removed")
+	}
+}
+
+func TestIpsStoreIPv4In4Bytes(t *testing.T) {
+	var s ips
+	s.setIpList(FamilyV4, []net.IP{net.ParseIP("192.0.2.1")})
+	addr, _ := s.getNextIPWithIndex(time.Now())
+	if !addr.Is4() {
+		t.Fatalf("got %s, want an IPv4 address", addr)
+	}
+	if list := s.getList(); len(list[0]) != net.IPv4len {
+		t.Fatalf("got %d bytes, want %d", len(list[0]), net.IPv4len)
+	}
+}
@@ -0,0 +1,119 @@
+package resolver
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// IPSetOp - an operation of an IPSetEvent
+type IPSetOp int
+
+const (
+	// IPSetAdd - the address was resolved, it should be kept in the set for TTL
+	IPSetAdd IPSetOp = iota
+	// IPSetExpire - the address is no longer resolved or the host is deleted, it should be removed from the set
+	IPSetExpire
+)
+
+// String ...
+func (op IPSetOp) String() string {
+	switch op {
+	case IPSetAdd:
+		return "add"
+	case IPSetExpire:
+		return "expire"
+	}
+	return "unknown"
+}
+
+// IPSetEvent - an address of a host for feeding ipset or nftables sets
+type IPSetEvent struct {
+	Host string
+	IP   net.IP
+	Op   IPSetOp
+
+	// TTL - the time the added address is valid for, the timeout of the set entry. Every refresh
+	// adds the addresses again with a new TTL. Zero for IPSetExpire
+	TTL time.Duration
+}
+
+// IPSetFunc - a function receiving addresses of hosts, see WithIPSetHook
+type IPSetFunc func(ev IPSetEvent)
+
+// ipsetHook ...
+type ipsetHook struct {
+	patterns []string
+	fn       IPSetFunc
+}
+
+// ipsetHooks - hooks added by WithIPSetHook
+type ipsetHooks struct {
+	mu   sync.RWMutex
+	list []ipsetHook
+}
+
+// add ...
+func (s *ipsetHooks) add(fn IPSetFunc, patterns []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.list = append(s.list, ipsetHook{patterns: patterns, fn: fn})
+}
+
+// match returns functions of the hooks matching hostName
+func (s *ipsetHooks) match(hostName string) []IPSetFunc {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var ret []IPSetFunc
+	for _, hook := range s.list {
+		for _, pattern := range hook.patterns {
+			if matchPattern(pattern, hostName) {
+				ret = append(ret, hook.fn)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// WithIPSetHook - adds a function receiving addresses of hosts matching the glob patterns, designed
+// for domain-based firewalling with ipset or nftables sets: every resolution passes IPSetAdd
+// for each address with its TTL, addresses no longer resolved and addresses of deleted hosts
+// are passed with IPSetExpire. Applies to hosts created after this call, static hosts are not passed.
+// The function is called synchronously with refreshes and must not block. Malformed patterns are logged and skipped
+func (r *Resolver) WithIPSetHook(fn IPSetFunc, patterns ...string) *Resolver {
+	r.ipsetHooks.add(fn, r.validPatterns(patterns...))
+	return r
+}
+
+// notifyIPSet passes addresses of the host changed from old to cur with ttl in seconds to the hooks
+func (h *host) notifyIPSet(old, cur []net.IP, ttl uint32) {
+	seen := make(map[string]bool, len(cur))
+	for _, ip := range cur {
+		seen[string(ip.To16())] = true
+		h.callIPSet(IPSetEvent{Host: h.hostName, IP: ip, Op: IPSetAdd, TTL: time.Duration(ttl) * time.Second})
+	}
+	for _, ip := range old {
+		if !seen[string(ip.To16())] {
+			h.callIPSet(IPSetEvent{Host: h.hostName, IP: ip, Op: IPSetExpire})
+		}
+	}
+}
+
+// expireIPSet passes all addresses of the host to the hooks as expired
+func (h *host) expireIPSet() {
+	if len(h.ipsetFuncs) == 0 {
+		return
+	}
+	ip4, ip6 := h.ip4.getList(), h.ip6.getList()
+	for _, ip := range append(ip4, ip6...) {
+		h.callIPSet(IPSetEvent{Host: h.hostName, IP: ip, Op: IPSetExpire})
+	}
+}
+
+// callIPSet ...
+func (h *host) callIPSet(ev IPSetEvent) {
+	for _, fn := range h.ipsetFuncs {
+		fn(ev)
+	}
+}
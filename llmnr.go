@@ -0,0 +1,179 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// llmnrTimeout - the time responses to an LLMNR query are waited for (LLMNR_TIMEOUT of RFC 4795)
+	llmnrTimeout = time.Second
+
+	// llmnrGroup4, llmnrGroup6 - LLMNR multicast addresses
+	llmnrGroup4 = "224.0.0.252:5355"
+	llmnrGroup6 = "[ff02::1:3]:5355"
+)
+
+// WithLLMNR - enables LLMNR (RFC 4795) for single-label names, e.g. "nas" or "printer" on LANs
+// of Windows hosts: when nameservers resolve such a name to no addresses it is queried by multicast
+// over IPv4 and IPv6 and the addresses of responders are cached like ones of nameservers
+// per their TTLs. Answers required to be DNSSEC-validated never fall back to LLMNR
+func (r *Resolver) WithLLMNR(enabled bool) *Resolver {
+	var flag int32
+	if enabled {
+		flag = 1
+	}
+	atomic.StoreInt32(&r.clientCfg.llmnr, flag)
+	return r
+}
+
+// useLLMNR reports whether host is resolved by LLMNR if nameservers do not resolve it
+func (c *clientConfig) useLLMNR(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	return atomic.LoadInt32(&c.llmnr) == 1 && host != "" && !strings.Contains(host, ".")
+}
+
+// llmnrLookupHost queries addresses of host of family by LLMNR, ok is false if nobody answered
+func (d *dnsClient) llmnrLookupHost(ctx context.Context, host string, family Family) (hostLookup, bool) {
+	ctx, cancel := context.WithTimeout(ctx, llmnrTimeout)
+	defer cancel()
+
+	var qtypes []uint16
+	if family.hasV4() {
+		qtypes = append(qtypes, dns.TypeA)
+	}
+	if family.hasV6() {
+		qtypes = append(qtypes, dns.TypeAAAA)
+	}
+
+	var (
+		mu   sync.Mutex
+		l    hostLookup
+		seen = make(map[string]bool)
+	)
+	add := func(rr dns.RR, from net.Addr) {
+		mu.Lock()
+		defer mu.Unlock()
+		var ip net.IP
+		switch v := rr.(type) {
+		case *dns.A:
+			ip = v.A
+		case *dns.AAAA:
+			ip = v.AAAA
+		default:
+			return
+		}
+		if seen[string(ip.To16())] {
+			return
+		}
+		seen[string(ip.To16())] = true
+
+		src := Source{Nameserver: from.String(), Transport: TransportLLMNR, Time: d.cfg.clock.Now()}
+		ttl := floorTtl(rr.Header().Ttl)
+		if ip.To4() != nil {
+			if len(l.ip4) == 0 || ttl < l.ttl4 {
+				l.ttl4 = ttl
+			}
+			l.ip4, l.src4 = append(l.ip4, ip), src
+		} else {
+			if len(l.ip6) == 0 || ttl < l.ttl6 {
+				l.ttl6 = ttl
+			}
+			l.ip6, l.src6 = append(l.ip6, ip), src
+		}
+	}
+
+	var g errgroup.Group
+	for _, group := range []string{llmnrGroup4, llmnrGroup6} {
+		group := group
+		g.Go(func() error {
+			if err := d.llmnrQuery(ctx, group, host, qtypes, add); err != nil {
+				logError(d.logger, d.cfg.tag, "Error querying LLMNR", group, d.cfg.privacy.redact(host), d.cfg.privacy.redactErr(err, host))
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	if l.ttl4 == 0 {
+		l.ttl4 = defaultTtl
+	}
+	if l.ttl6 == 0 {
+		l.ttl6 = defaultTtl
+	}
+	return l, len(l.ip4) > 0 || len(l.ip6) > 0
+}
+
+// llmnrQuery sends queries of qtypes for host to the multicast group and passes answer records
+// of responses to add until every query is answered or ctx is done
+func (d *dnsClient) llmnrQuery(ctx context.Context, group, host string, qtypes []uint16, add func(rr dns.RR, from net.Addr)) error {
+	network := "udp4"
+	if strings.HasPrefix(group, "[") {
+		network = "udp6"
+	}
+	groupAddr, err := net.ResolveUDPAddr(network, group)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		// no IPv6 or IPv4 on the host
+		return nil
+	}
+	defer conn.Close()
+	d.cfg.conns.add(TransportLLMNR, 1)
+	defer d.cfg.conns.add(TransportLLMNR, -1)
+
+	qname := dns.Fqdn(host)
+	pending := make(map[uint16]uint16, len(qtypes))
+	for _, qtype := range qtypes {
+		m := new(dns.Msg)
+		m.SetQuestion(qname, qtype)
+		m.RecursionDesired = false
+		b, err := m.Pack()
+		if err != nil {
+			return err
+		}
+		if _, err := conn.WriteToUDP(b, groupAddr); err != nil {
+			// the group is not routable, e.g. there is no IPv6 interface
+			return nil
+		}
+		pending[m.Id] = qtype
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+	buf := make([]byte, dns.MaxMsgSize)
+	for len(pending) > 0 {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return nil
+			}
+			return err
+		}
+		in := new(dns.Msg)
+		if err := in.Unpack(buf[:n]); err != nil || !in.Response || len(in.Question) != 1 {
+			continue
+		}
+		qtype, ok := pending[in.Id]
+		if !ok || in.Question[0].Qtype != qtype || !strings.EqualFold(in.Question[0].Name, qname) {
+			continue
+		}
+		delete(pending, in.Id)
+		for _, rr := range in.Answer {
+			if rr.Header().Rrtype == qtype && strings.EqualFold(rr.Header().Name, qname) {
+				add(rr, from)
+			}
+		}
+	}
+	return nil
+}
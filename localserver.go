@@ -0,0 +1,136 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	// errPipeClosed - the PipeListener is closed
+	errPipeClosed = errors.New("pipe listener closed")
+
+	// errNotSocket - a file which is not a socket exists at the path of UnixServer
+	errNotSocket = errors.New("file exists and is not a socket")
+)
+
+// pipeAddr - the address of both ends of connections of a PipeListener
+type pipeAddr struct{}
+
+// Network ...
+func (pipeAddr) Network() string { return "pipe" }
+
+// String ...
+func (pipeAddr) String() string { return "pipe" }
+
+// PipeListener - an in-memory net.Listener whose connections are made by DialContext with net.Pipe,
+// so a dns.Server can be served and queried without binding a port
+type PipeListener struct {
+	connCh    chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPipeListener ...
+func NewPipeListener() *PipeListener {
+	return &PipeListener{
+		connCh: make(chan net.Conn),
+		done:   make(chan struct{}),
+	}
+}
+
+// Accept ...
+func (l *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.done:
+		return nil, errPipeClosed
+	}
+}
+
+// Close ...
+func (l *PipeListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+// Addr ...
+func (l *PipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// DialContext returns a connection accepted by the listener, network and address are ignored,
+// so it can be used as net.Resolver.Dial. Messages are framed by their length like over TCP,
+// e.g. by dns.Conn
+func (l *PipeListener) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.connCh <- server:
+		return client, nil
+	case <-l.done:
+		return nil, errPipeClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// PipeServer returns a server answering queries like ServeDNS over an in-memory listener
+// and the listener to dial it with. The server is started by ActivateAndServe and stopped by Shutdown
+func (r *Resolver) PipeServer() (*dns.Server, *PipeListener) {
+	l := NewPipeListener()
+	return &dns.Server{
+		Listener: l,
+		Net:      "tcp",
+		Handler:  r,
+	}, l
+}
+
+// UnixServer returns a server answering queries like ServeDNS over the unix socket at path,
+// network is "unix" with messages framed like over TCP or "unixgram" with a message per datagram.
+// A stale socket file at path is removed, any other file there is an error.
+// The server is started by ActivateAndServe and stopped by Shutdown
+func (r *Resolver) UnixServer(path, network string) (*dns.Server, error) {
+	if err := removeSocket(path); err != nil {
+		return nil, err
+	}
+	switch network {
+	case "unix":
+		l, err := net.Listen(network, path)
+		if err != nil {
+			return nil, err
+		}
+		return &dns.Server{Listener: l, Net: "tcp", Handler: r}, nil
+	case "unixgram":
+		pc, err := net.ListenPacket(network, path)
+		if err != nil {
+			return nil, err
+		}
+		return &dns.Server{PacketConn: pc, Net: "udp", Handler: r}, nil
+	}
+	return nil, net.UnknownNetworkError(network)
+}
+
+// removeSocket removes the socket file at path if it exists
+func removeSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return &os.PathError{Op: "listen", Path: path, Err: errNotSocket}
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
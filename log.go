@@ -0,0 +1,19 @@
+package resolver
+
+import (
+	logApi "github.com/ndmsystems/go/api/log"
+)
+
+// logInfo logs v at the info level, a nil logger disables logging
+func logInfo(logger logApi.Logger, v ...interface{}) {
+	if logger != nil {
+		logger.Info().Println(v...)
+	}
+}
+
+// logError logs v at the error level, a nil logger disables logging
+func logError(logger logApi.Logger, v ...interface{}) {
+	if logger != nil {
+		logger.Error().Println(v...)
+	}
+}
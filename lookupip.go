@@ -0,0 +1,72 @@
+package resolver
+
+import (
+	"context"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// errNoSuchHost - the text of net.DNSError for hosts without addresses as used by the net package
+const errNoSuchHost = "no such host"
+
+// dnsError returns err of the resolution of hostName as *net.DNSError
+func dnsError(err error, hostName string) *net.DNSError {
+	if err == ErrStopped {
+		return &net.DNSError{Err: err.Error(), Name: hostName}
+	}
+	return &net.DNSError{Err: err.Error(), Name: hostName, IsTimeout: isTimeout(err), IsTemporary: true}
+}
+
+// LookupIP looks up host for the given network like net.Resolver.LookupIP, network must be
+// "ip", "ip4" or "ip6". The host is passed through the lookup pipeline like queries of ServeDNS,
+// addresses are served from the cache adding the host to maintaining, with WithNoAutoAdd
+// a host which is not maintained is resolved without caching.
+// Resolution errors are of type *net.DNSError
+func (r *Resolver) LookupIP(ctx context.Context, network, host string, opts ...QueryOption) ([]net.IP, error) {
+	var family Family
+	switch network {
+	case "ip":
+		family = FamilyAll
+	case "ip4":
+		family = FamilyV4
+	case "ip6":
+		family = FamilyV6
+	default:
+		return nil, net.UnknownNetworkError(network)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if (family == FamilyV4 && ip.To4() == nil) || (family == FamilyV6 && ip.To4() != nil) {
+			return nil, &net.AddrError{Err: "no suitable address", Addr: host}
+		}
+		return []net.IP{ip}, nil
+	}
+
+	o := newQueryOptions(opts)
+	ip4, ip6, err := r.lookupIPs(ctx, host, o)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: host, IsTimeout: isTimeout(err), IsTemporary: true}
+	}
+
+	ipList := make([]net.IP, 0, len(ip4)+len(ip6))
+	if family.hasV4() {
+		ipList = append(ipList, ip4...)
+	}
+	if family.hasV6() {
+		ipList = append(ipList, ip6...)
+	}
+	if len(ipList) == 0 {
+		return nil, &net.DNSError{Err: errNoSuchHost, Name: host, IsNotFound: true}
+	}
+	return ipList, nil
+}
+
+// lookupIPs returns addresses of a host passing it through the lookup pipeline
+func (r *Resolver) lookupIPs(ctx context.Context, hostName string, o queryOptions) ([]net.IP, []net.IP, error) {
+	l := newLookup(hostName, dns.TypeNone)
+	l.noAutoAdd = o.noAutoAdd
+
+	res := r.resolve(ctx, l)
+	return res.ip4, res.ip6, res.err
+}
@@ -0,0 +1,89 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/sync/errgroup"
+)
+
+// resolveManyParallelism - the max number of hosts ResolveMany waits for at once
+const resolveManyParallelism = 16
+
+// ResolveMany resolves hosts through the cache concurrently, at most 16 at once, until all of them
+// are resolved or ctx is done, e.g. to resolve dependencies at startup. Hosts which are not maintained
+// are added non-explicitly like by lookups. Returns a result per host name, a host without addresses
+// has Result.Err set to the error of its resolution, a not found error or the error of ctx
+func (r *Resolver) ResolveMany(ctx context.Context, hosts []string) map[string]Result {
+	ret := make(map[string]Result, len(hosts))
+	results := make([]Result, len(hosts))
+	seen := make(map[string]bool, len(hosts))
+
+	var g errgroup.Group
+	g.SetLimit(resolveManyParallelism)
+	for i, hostName := range hosts {
+		if seen[hostName] {
+			continue
+		}
+		seen[hostName] = true
+		i, hostName := i, hostName
+		g.Go(func() error {
+			results[i] = r.resolveCached(ctx, hostName)
+			return nil
+		})
+	}
+	g.Wait()
+
+	for i, hostName := range hosts {
+		if _, ok := ret[hostName]; !ok {
+			ret[hostName] = results[i]
+		}
+	}
+	return ret
+}
+
+// resolveCached returns addresses of a host passed through the lookup pipeline waiting for the first
+// resolution of a maintained host
+func (r *Resolver) resolveCached(ctx context.Context, hostName string) Result {
+	if err := ctx.Err(); err != nil {
+		return Result{Err: dnsError(err, hostName)}
+	}
+	lr := r.resolve(ctx, apiLookup(hostName, dns.TypeNone))
+	if lr.err != nil {
+		return Result{Err: dnsError(lr.err, hostName), ReadyWait: lr.readyWait}
+	}
+
+	ip4, ip6 := lr.ip4, lr.ip6
+	if len(ip4) == 0 && len(ip6) == 0 {
+		return Result{Err: &net.DNSError{Err: errNoSuchHost, Name: hostName, IsNotFound: true}, ReadyWait: lr.readyWait}
+	}
+	res := Result{IP4: ip4, IP6: ip6, ReadyWait: lr.readyWait}
+
+	h := lr.host
+	if h == nil {
+		// rewrite rules and mirrored zones answer with TTLs only
+		for _, ttl := range []uint32{lr.ttl4, lr.ttl6} {
+			if d := time.Duration(ttl) * time.Second; d > 0 && (res.TTL == 0 || d < res.TTL) {
+				res.TTL = d
+			}
+		}
+		return res
+	}
+	res.Source4, res.Source6 = h.sources.get()
+	now := h.clock.Now()
+	for _, family := range []Family{FamilyV4, FamilyV6} {
+		if (family == FamilyV4 && len(ip4) == 0) || (family == FamilyV6 && len(ip6) == 0) {
+			continue
+		}
+		expire := h.expiry(family)
+		if expire.IsZero() {
+			continue
+		}
+		if ttl := expire.Sub(now); ttl > 0 && (res.TTL == 0 || ttl < res.TTL) {
+			res.TTL = ttl.Truncate(time.Second)
+		}
+	}
+	return res
+}
@@ -0,0 +1,92 @@
+package resolver
+
+import (
+	"net"
+	"sync"
+)
+
+// MappedV4 - how IPv4-mapped IPv6 addresses (::ffff:192.0.2.1) in AAAA answers are handled,
+// see Policy.MappedV4. Some broken nameservers return them
+type MappedV4 int
+
+const (
+	// MappedKeep - the addresses are kept with IPv6 addresses, it is the default
+	MappedKeep MappedV4 = iota
+	// MappedToV4 - the addresses are moved to IPv4 addresses of the host, which are resolved
+	// along with IPv6 addresses at first, and later at their own refreshes
+	MappedToV4
+	// MappedDrop - the addresses are dropped
+	MappedDrop
+)
+
+// String ...
+func (m MappedV4) String() string {
+	switch m {
+	case MappedKeep:
+		return "keep"
+	case MappedToV4:
+		return "to-v4"
+	case MappedDrop:
+		return "drop"
+	}
+	return "unknown"
+}
+
+// mappedV4 ...
+func (p *Policy) mappedV4() MappedV4 {
+	if p == nil {
+		return MappedKeep
+	}
+	return p.MappedV4
+}
+
+// mappedAddrs - IPv4 addresses of the last AAAA answer given in the IPv4-mapped form
+type mappedAddrs struct {
+	mu  sync.Mutex
+	ips []net.IP
+}
+
+// set ...
+func (m *mappedAddrs) set(ipList []net.IP) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ips = ipList
+}
+
+// get ...
+func (m *mappedAddrs) get() []net.IP {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ips
+}
+
+// normalizeMapped applies the MappedV4 policy to addresses of family of l, slices of l are shared
+// with other lookups and are not modified
+func (h *host) normalizeMapped(family Family, l hostLookup) hostLookup {
+	mode := h.policy.mappedV4()
+	if mode == MappedKeep {
+		return l
+	}
+	if family.hasV6() {
+		var ip6, mapped []net.IP
+		for _, ip := range l.ip6 {
+			if ip4 := ip.To4(); ip4 != nil {
+				mapped = append(mapped, ip4)
+				continue
+			}
+			ip6 = append(ip6, ip)
+		}
+		if len(mapped) > 0 {
+			l.ip6 = ip6
+		}
+		if mode == MappedToV4 {
+			h.mapped4.set(mapped)
+		}
+	}
+	if mode == MappedToV4 && family.hasV4() {
+		if mapped := diffIPs(h.mapped4.get(), l.ip4); len(mapped) > 0 {
+			l.ip4 = append(append(make([]net.IP, 0, len(l.ip4)+len(mapped)), l.ip4...), mapped...)
+		}
+	}
+	return l
+}
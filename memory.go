@@ -0,0 +1,192 @@
+package resolver
+
+import (
+	"net/netip"
+	"sort"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// mapEntryOverhead - an estimated overhead of a map entry besides the key and the value
+	mapEntryOverhead = 48
+
+	// rrOverhead - an estimated in-memory overhead of a record besides its wire size
+	rrOverhead = 64
+)
+
+// MemoryFootprint - an estimation of memory used by the cache in bytes
+type MemoryFootprint struct {
+	// Hosts - hosts with addresses
+	Hosts int64
+
+	// Negative - hosts resolved to no addresses
+	Negative int64
+
+	// Records - RRsets maintained by Maintain
+	Records int64
+
+	// Zones - records of zones mirrored by MirrorZone
+	Zones int64
+
+	// Total - the sum of the above
+	Total int64
+}
+
+// MemoryFootprint returns an estimation of memory used by the cache
+func (r *Resolver) MemoryFootprint() MemoryFootprint {
+	var f MemoryFootprint
+
+	r.mu.RLock()
+	for _, h := range r.hosts {
+		if size, negative := h.memSize(); negative {
+			f.Negative += size
+		} else {
+			f.Hosts += size
+		}
+	}
+	for _, rec := range r.records {
+		f.Records += rec.memSize()
+	}
+	r.mu.RUnlock()
+
+	r.zones.mu.RLock()
+	for _, mz := range r.zones.list {
+		f.Zones += mz.memSize()
+	}
+	r.zones.mu.RUnlock()
+
+	f.Total = f.Hosts + f.Negative + f.Records + f.Zones
+	return f
+}
+
+// WithMemoryLimit - limits the estimated memory used by the cache, see MemoryFootprint.
+// When the limit is exceeded least recently used hosts which are not added explicitly are deleted
+// in the order of their classes, see HostClass.
+// Zero disables the limit
+func (r *Resolver) WithMemoryLimit(bytes int64) *Resolver {
+	atomic.StoreInt64(&r.memLimit, bytes)
+	r.memOnce.Do(func() {
+		r.scheduler.goroutines.spawn("memory-limit", "", r.memoryLimitLoop)
+	})
+	r.checkMemory()
+	return r
+}
+
+// checkMemory makes the memory limit be checked
+func (r *Resolver) checkMemory() {
+	select {
+	case r.memCh <- struct{}{}:
+	default:
+	}
+}
+
+// memoryLimitLoop ...
+func (r *Resolver) memoryLimitLoop() {
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case <-r.memCh:
+			r.enforceMemoryLimit()
+		}
+	}
+}
+
+// enforceMemoryLimit deletes implicit hosts while the memory limit is exceeded: ClassBackground hosts
+// go first and ClassCritical hosts go last, least recently used ones first within a class
+func (r *Resolver) enforceMemoryLimit() {
+	limit := atomic.LoadInt64(&r.memLimit)
+	if limit <= 0 {
+		return
+	}
+	total := r.MemoryFootprint().Total
+	if total <= limit {
+		return
+	}
+
+	type candidate struct {
+		name     string
+		h        *host
+		lastTime int64
+		rank     int
+	}
+	r.mu.Lock()
+	candidates := make([]candidate, 0, len(r.hosts))
+	for hostName, h := range r.hosts {
+		if !h.isExplicitlyAdded() && !h.static {
+			candidates = append(candidates, candidate{hostName, h, atomic.LoadInt64(&h.lastTime), h.getClass().evictionRank()})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].rank != candidates[j].rank {
+			return candidates[i].rank < candidates[j].rank
+		}
+		return candidates[i].lastTime < candidates[j].lastTime
+	})
+	deleted := 0
+	for _, c := range candidates {
+		if total <= limit {
+			break
+		}
+		size, _ := c.h.memSize()
+		total -= size
+		delete(r.hosts, c.name)
+		c.h.stop()
+		deleted++
+	}
+	r.mu.Unlock()
+
+	if deleted > 0 {
+		logInfo(r.logger, r.tag, "Memory limit exceeded, deleted hosts:", deleted)
+	}
+}
+
+// memSize returns an estimated size of the host and whether it has no addresses
+func (h *host) memSize() (int64, bool) {
+	size := int64(unsafe.Sizeof(*h)) + int64(len(h.hostName)) + mapEntryOverhead
+	size4, n4 := h.ip4.memSize()
+	size6, n6 := h.ip6.memSize()
+	return size + size4 + size6, n4+n6 == 0
+}
+
+// memSize returns an estimated size of the addresses and their number
+func (s *ips) memSize() (int64, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	size := int64(unsafe.Sizeof(*s))
+	size += int64(cap(s.ipList)) * int64(unsafe.Sizeof(netip.Addr{}))
+	size += int64(len(s.badUntil)) * (int64(unsafe.Sizeof(netip.Addr{})+unsafe.Sizeof(time.Time{})) + mapEntryOverhead)
+	return size, len(s.ipList)
+}
+
+// memSize returns an estimated size of the record
+func (rec *record) memSize() int64 {
+	rrset, _ := rec.h.rrsets.get(rec.key.qtype)
+	return int64(unsafe.Sizeof(*rec)) + int64(unsafe.Sizeof(*rec.h)) + int64(len(rec.key.qname)) + mapEntryOverhead + rrsMemSize(rrset.rrs)
+}
+
+// memSize returns an estimated size of the zone records
+func (mz *mirroredZone) memSize() int64 {
+	mz.mu.RLock()
+	defer mz.mu.RUnlock()
+
+	var size int64
+	for name, rrs := range mz.rrs {
+		size += int64(len(name)) + mapEntryOverhead + rrsMemSize(rrs)
+	}
+	return size
+}
+
+// rrsMemSize returns an estimated size of records
+func rrsMemSize(rrs []dns.RR) int64 {
+	var size int64
+	for _, rr := range rrs {
+		size += rrOverhead + int64(dns.Len(rr))
+	}
+	return size
+}
@@ -0,0 +1,182 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync/atomic"
+)
+
+// MergeStrategy - how addresses answered by all nameservers are merged, see WithAnswerMerge
+type MergeStrategy int32
+
+const (
+	// MergeNone - nameservers are tried one by one until one answers, it is the default
+	MergeNone MergeStrategy = iota
+	// MergeUnion - addresses answered by any nameserver are kept
+	MergeUnion
+	// MergeIntersection - addresses answered by all nameservers answered are kept, the answer
+	// of the first nameserver in the strategy order is kept if there are no such addresses
+	MergeIntersection
+	// MergeFirstComplete - the first answer with addresses of every family resolved is kept,
+	// the first answer with any addresses if there is no such one
+	MergeFirstComplete
+)
+
+// String ...
+func (s MergeStrategy) String() string {
+	switch s {
+	case MergeNone:
+		return "none"
+	case MergeUnion:
+		return "union"
+	case MergeIntersection:
+		return "intersection"
+	case MergeFirstComplete:
+		return "first-complete"
+	}
+	return "unknown"
+}
+
+// WithAnswerMerge - queries A and AAAA of hosts from all nameservers at once and merges their answers
+// by s, for environments where nameservers see different regional views. The TTL of merged addresses
+// of a family is the min TTL of the answers with addresses of it, their source is the first such
+// nameserver in the strategy order. Answers required to be DNSSEC-validated are not merged, nor they are cross-checked
+func (r *Resolver) WithAnswerMerge(s MergeStrategy) *Resolver {
+	atomic.StoreInt32((*int32)(&r.clientCfg.merge), int32(s))
+	return r
+}
+
+// getMerge ...
+func (c *clientConfig) getMerge() MergeStrategy {
+	return MergeStrategy(atomic.LoadInt32((*int32)(&c.merge)))
+}
+
+// mergeAnswer - an answer of a nameserver of a merged lookup
+type mergeAnswer struct {
+	idx int
+	l   hostLookup
+	err error
+}
+
+// mergeLookupHost queries addresses of host of family from all nameservers and merges the answers by s
+func (d *dnsClient) mergeLookupHost(ctx context.Context, host string, family Family, s MergeStrategy) (hostLookup, error) {
+	nameServers := d.nameServersOrder()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan mergeAnswer, len(nameServers))
+	for i, nServer := range nameServers {
+		go func(i int, nServer string) {
+			var l hostLookup
+			err := d.tryNameServer(ctx, nServer, func(nServer string) (err error) {
+				l, err = d.dnsLookupHost(ctx, nServer, host, family, false)
+				return err
+			})
+			ch <- mergeAnswer{idx: i, l: l, err: err}
+		}(i, nServer)
+	}
+
+	answers := make([]*hostLookup, len(nameServers))
+	var err error
+	for range nameServers {
+		a := <-ch
+		if a.err != nil {
+			err = a.err
+			continue
+		}
+		if s == MergeFirstComplete && completeLookup(a.l, family) {
+			return a.l, nil
+		}
+		l := a.l
+		answers[a.idx] = &l
+	}
+
+	var ok []hostLookup
+	for _, l := range answers {
+		if l != nil {
+			ok = append(ok, *l)
+		}
+	}
+	if len(ok) == 0 {
+		return hostLookup{ttl4: defaultTtl, ttl6: defaultTtl}, err
+	}
+	switch s {
+	case MergeUnion:
+		return mergeLookups(ok, 1), nil
+	case MergeIntersection:
+		return mergeLookups(ok, len(ok)), nil
+	}
+	for _, l := range ok {
+		if len(l.ip4) > 0 || len(l.ip6) > 0 {
+			return l, nil
+		}
+	}
+	return ok[0], nil
+}
+
+// completeLookup reports whether l has addresses of every family of family
+func completeLookup(l hostLookup, family Family) bool {
+	return (!family.hasV4() || len(l.ip4) > 0) && (!family.hasV6() || len(l.ip6) > 0)
+}
+
+// mergeLookups returns addresses present in at least minCount of ls in the order of their first
+// appearance, addresses of the first lookup are kept for a family with no such addresses.
+// The name does not exist only if no lookup found it
+func mergeLookups(ls []hostLookup, minCount int) hostLookup {
+	ret := ls[0]
+	for _, l := range ls {
+		ret.nxdomain = ret.nxdomain && l.nxdomain
+	}
+	ret.ip4 = mergeIPs(ls, func(l hostLookup) []net.IP { return l.ip4 }, minCount)
+	ret.ip6 = mergeIPs(ls, func(l hostLookup) []net.IP { return l.ip6 }, minCount)
+	if len(ret.ip4) == 0 {
+		ret.ip4 = ls[0].ip4
+	}
+	if len(ret.ip6) == 0 {
+		ret.ip6 = ls[0].ip6
+	}
+	found4, found6 := false, false
+	for _, l := range ls {
+		if len(l.ip4) > 0 && (!found4 || l.ttl4 < ret.ttl4) {
+			if !found4 {
+				ret.src4 = l.src4
+			}
+			ret.ttl4, found4 = l.ttl4, true
+		}
+		if len(l.ip6) > 0 && (!found6 || l.ttl6 < ret.ttl6) {
+			if !found6 {
+				ret.src6 = l.src6
+			}
+			ret.ttl6, found6 = l.ttl6, true
+		}
+	}
+	return ret
+}
+
+// mergeIPs returns addresses selected by ipList present in at least minCount of ls
+func mergeIPs(ls []hostLookup, ipList func(l hostLookup) []net.IP, minCount int) []net.IP {
+	count := make(map[netip.Addr]int)
+	var order []net.IP
+	for _, l := range ls {
+		seen := make(map[netip.Addr]bool)
+		for _, ip := range ipList(l) {
+			addr := ipKey(ip)
+			if seen[addr] {
+				continue
+			}
+			seen[addr] = true
+			if count[addr] == 0 {
+				order = append(order, ip)
+			}
+			count[addr]++
+		}
+	}
+	var ret []net.IP
+	for _, ip := range order {
+		if count[ipKey(ip)] >= minCount {
+			ret = append(ret, ip)
+		}
+	}
+	return ret
+}
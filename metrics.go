@@ -0,0 +1,143 @@
+package resolver
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OtherHostsLabel - the host label value under which hosts beyond the metrics cardinality limit are aggregated
+const OtherHostsLabel = "other"
+
+// HostCount - a host with its number of lookups
+type HostCount struct {
+	Host  string
+	Count uint64
+}
+
+// WithMetricsCardinality - limits per-host metrics to the top limit hosts by lookups,
+// all the others are aggregated under OtherHostsLabel. Zero means no limit
+func (r *Resolver) WithMetricsCardinality(limit int) *Resolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metricsHostsLimit = limit
+	return r
+}
+
+// TopHosts returns up to n hosts with the most lookups in descending order, n <= 0 means all hosts
+func (r *Resolver) TopHosts(n int) []HostCount {
+	counts := r.hostCounts()
+	if n > 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// metricsHosts returns hosts to be exposed with their own label and the aggregated count for the rest
+func (r *Resolver) metricsHosts() ([]HostCount, uint64) {
+	r.mu.RLock()
+	limit := r.metricsHostsLimit
+	r.mu.RUnlock()
+
+	counts := r.hostCounts()
+	if limit <= 0 || len(counts) <= limit {
+		return counts, 0
+	}
+
+	var other uint64
+	for _, c := range counts[limit:] {
+		other += c.Count
+	}
+	return counts[:limit], other
+}
+
+// hostCounts returns all hosts sorted by lookups in descending order
+func (r *Resolver) hostCounts() []HostCount {
+	r.mu.RLock()
+	counts := make([]HostCount, 0, len(r.hosts))
+	for hostName, h := range r.hosts {
+		counts = append(counts, HostCount{Host: hostName, Count: h.getLookups()})
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Host < counts[j].Host
+	})
+	return counts
+}
+
+// DumpMetrics dumps into writer resolver metrics in the Prometheus text exposition format
+func (r *Resolver) DumpMetrics(w io.Writer) {
+	st := r.Stats()
+	tag := "tag=" + quoteLabel(r.tag)
+
+	writeMetric(w, "dns_resolver_hosts", "gauge", "Number of maintained hosts.")
+	fmt.Fprintf(w, "dns_resolver_hosts{%s} %d\n", tag, st.Hosts)
+
+	writeMetric(w, "dns_resolver_cache_lookups_total", "counter", "Number of lookups by cache result.")
+	fmt.Fprintf(w, "dns_resolver_cache_lookups_total{%s,result=\"%s\"} %d\n", tag, CacheHit, st.CacheHits)
+	fmt.Fprintf(w, "dns_resolver_cache_lookups_total{%s,result=\"%s\"} %d\n", tag, CacheBlocked, st.CacheBlocked)
+
+	writeMetric(w, "dns_resolver_hosts_created_total", "counter", "Number of hosts created non-explicitly by lookups.")
+	fmt.Fprintf(w, "dns_resolver_hosts_created_total{%s} %d\n", tag, st.HostsCreated)
+
+	writeMetric(w, "dns_resolver_ready_wait_seconds_total", "counter", "Time lookups blocked waiting for first resolutions of hosts.")
+	fmt.Fprintf(w, "dns_resolver_ready_wait_seconds_total{%s} %g\n", tag, st.ReadyWait.Seconds())
+
+	writeMetric(w, "dns_resolver_query_fallbacks_total", "counter", "Number of queries repeated without EDNS or over TCP.")
+	fmt.Fprintf(w, "dns_resolver_query_fallbacks_total{%s,fallback=\"edns\"} %d\n", tag, st.EdnsFallbacks)
+	fmt.Fprintf(w, "dns_resolver_query_fallbacks_total{%s,fallback=\"tcp\"} %d\n", tag, st.TcpFallbacks)
+
+	hosts, other := r.metricsHosts()
+	writeMetric(w, "dns_resolver_host_lookups_total", "counter", "Number of lookups per host.")
+	for _, c := range hosts {
+		fmt.Fprintf(w, "dns_resolver_host_lookups_total{%s,host=%s} %d\n", tag, quoteLabel(r.clientCfg.privacy.redact(c.Host)), c.Count)
+	}
+	if other > 0 {
+		fmt.Fprintf(w, "dns_resolver_host_lookups_total{%s,host=%s} %d\n", tag, quoteLabel(OtherHostsLabel), other)
+	}
+
+	waits := r.hostReadyWaits()
+	writeMetric(w, "dns_resolver_host_ready_wait_seconds_total", "counter", "Time lookups blocked waiting for the first resolution per host.")
+	for _, c := range hosts {
+		fmt.Fprintf(w, "dns_resolver_host_ready_wait_seconds_total{%s,host=%s} %g\n", tag, quoteLabel(r.clientCfg.privacy.redact(c.Host)), waits[c.Host].Seconds())
+		delete(waits, c.Host)
+	}
+	if other > 0 {
+		var otherWait time.Duration
+		for _, d := range waits {
+			otherWait += d
+		}
+		fmt.Fprintf(w, "dns_resolver_host_ready_wait_seconds_total{%s,host=%s} %g\n", tag, quoteLabel(OtherHostsLabel), otherWait.Seconds())
+	}
+}
+
+// hostReadyWaits returns the ready wait time of every host
+func (r *Resolver) hostReadyWaits() map[string]time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ret := make(map[string]time.Duration, len(r.hosts))
+	for hostName, h := range r.hosts {
+		ret[hostName] = h.getReadyWait()
+	}
+	return ret
+}
+
+// writeMetric writes HELP and TYPE lines of a metric
+func writeMetric(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+// labelEscaper ...
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quoteLabel returns a quoted and escaped label value
+func quoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
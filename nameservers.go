@@ -0,0 +1,304 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// failuresToDown - the number of consecutive failed queries after which a nameserver is down
+	failuresToDown = 3
+
+	// minQueryTimeout, maxQueryTimeout - bounds of the timeout of a query derived from the smoothed RTT,
+	// maxQueryTimeout is used for nameservers not queried yet
+	minQueryTimeout = 200 * time.Millisecond
+	maxQueryTimeout = 2 * time.Second
+)
+
+// NameserverState - a health state of a nameserver
+type NameserverState int
+
+const (
+	// NameserverUnknown - no queries were sent to the nameserver
+	NameserverUnknown NameserverState = iota
+	// NameserverHealthy - the last query to the nameserver succeeded
+	NameserverHealthy
+	// NameserverDegraded - the last queries to the nameserver failed
+	NameserverDegraded
+	// NameserverDown - several consecutive queries to the nameserver failed
+	NameserverDown
+	// NameserverHijacking - the nameserver answers nonexistent names with addresses, see WithCanary
+	NameserverHijacking
+)
+
+// String ...
+func (s NameserverState) String() string {
+	switch s {
+	case NameserverUnknown:
+		return "unknown"
+	case NameserverHealthy:
+		return "healthy"
+	case NameserverDegraded:
+		return "degraded"
+	case NameserverDown:
+		return "down"
+	case NameserverHijacking:
+		return "hijacking"
+	}
+	return "invalid"
+}
+
+// MarshalText ...
+func (s NameserverState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// NameserverStat - statistics of queries to a nameserver
+type NameserverStat struct {
+	// Nameserver - the nameserver as passed to WithNameservers or a policy
+	Nameserver string
+
+	// Queries - the number of queries sent
+	Queries uint64
+
+	// Errors - the number of queries failed, including timeouts and SERVFAIL or REFUSED responses
+	Errors uint64
+
+	// Timeouts - the number of queries timed out
+	Timeouts uint64
+
+	// LastRTT - the round trip time of the last query
+	LastRTT time.Duration
+
+	// SRTT - the smoothed round trip time, an exponential moving average of RTTs of succeeded queries
+	// doubled on every timeout
+	SRTT time.Duration
+
+	// Timeout - the timeout of the next query, three SRTTs within 200ms and 2s
+	Timeout time.Duration
+
+	// LastUsed - time of the last query, zero if there were no queries
+	LastUsed time.Time
+
+	// ErrorRate - the share of failed queries, an exponential moving average over about the last 16 queries
+	ErrorRate float64
+
+	// State - the health state
+	State NameserverState
+}
+
+// nameserverStats - query statistics per nameserver
+type nameserverStats struct {
+	mu    sync.Mutex
+	stats map[string]*nameserverStat
+
+	// hijacking - the number of nameservers answering canary names
+	hijacking int32
+
+	// events - the receiver of health changes set by WithNameserverEvents
+	events nameserverEvents
+}
+
+// nameserverStat ...
+type nameserverStat struct {
+	NameserverStat
+	failures   int
+	hijacking  bool
+	overBudget bool
+}
+
+// state returns the health state of the nameserver
+func (st *nameserverStat) state() NameserverState {
+	switch {
+	case st.hijacking:
+		return NameserverHijacking
+	case st.failures >= failuresToDown:
+		return NameserverDown
+	case st.failures > 0:
+		return NameserverDegraded
+	}
+	return NameserverHealthy
+}
+
+// getOrAdd returns statistics of nServer adding them if there are none, s.mu must be held
+func (s *nameserverStats) getOrAdd(nServer string) *nameserverStat {
+	if s.stats == nil {
+		s.stats = make(map[string]*nameserverStat)
+	}
+	st, ok := s.stats[nServer]
+	if !ok {
+		st = &nameserverStat{NameserverStat: NameserverStat{Nameserver: nServer}}
+		s.stats[nServer] = st
+	}
+	return st
+}
+
+// record accounts a query to nServer
+func (s *nameserverStats) record(nServer string, in *dns.Msg, err error, rtt time.Duration, now time.Time) {
+	s.mu.Lock()
+	st := s.getOrAdd(nServer)
+	prev := st.state()
+	st.Queries++
+	st.LastRTT = rtt
+	st.LastUsed = now
+	switch {
+	case isTimeout(err):
+		st.Timeouts++
+		st.SRTT *= 2
+		if st.SRTT == 0 || st.SRTT > maxQueryTimeout {
+			st.SRTT = maxQueryTimeout
+		}
+	case err == nil && st.SRTT == 0:
+		st.SRTT = rtt
+	case err == nil:
+		st.SRTT += (rtt - st.SRTT) / 8
+	}
+	if isFailure(in, err) {
+		st.Errors++
+		st.failures++
+		st.ErrorRate += (1 - st.ErrorRate) / 16
+	} else {
+		st.failures = 0
+		st.ErrorRate -= st.ErrorRate / 16
+	}
+	evs, fn := s.stateEvents(st, prev, now), s.events.fn
+	s.mu.Unlock()
+
+	emitNameserverEvents(fn, evs)
+}
+
+// get returns statistics of nServer
+func (s *nameserverStats) get(nServer string) NameserverStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.stats[nServer]
+	if !ok {
+		return NameserverStat{Nameserver: nServer, Timeout: maxQueryTimeout}
+	}
+	ret := st.NameserverStat
+	ret.Timeout = queryTimeout(st.SRTT)
+	ret.State = st.state()
+	return ret
+}
+
+// setHijacking sets whether nServer answers canary names, reports whether it has changed
+func (s *nameserverStats) setHijacking(nServer string, hijacking bool, now time.Time) bool {
+	s.mu.Lock()
+	st := s.getOrAdd(nServer)
+	if st.hijacking == hijacking {
+		s.mu.Unlock()
+		return false
+	}
+	prev := st.state()
+	st.hijacking = hijacking
+	if hijacking {
+		atomic.AddInt32(&s.hijacking, 1)
+	} else {
+		atomic.AddInt32(&s.hijacking, -1)
+	}
+	evs, fn := s.stateEvents(st, prev, now), s.events.fn
+	s.mu.Unlock()
+
+	emitNameserverEvents(fn, evs)
+	return true
+}
+
+// isHijacking ...
+func (s *nameserverStats) isHijacking(nServer string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.stats[nServer]
+	return ok && st.hijacking
+}
+
+// anyHijacking reports whether any nameserver answers canary names
+func (s *nameserverStats) anyHijacking() bool {
+	return atomic.LoadInt32(&s.hijacking) > 0
+}
+
+// timeout returns the timeout of the next query to nServer
+func (s *nameserverStats) timeout(nServer string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if st, ok := s.stats[nServer]; ok {
+		return queryTimeout(st.SRTT)
+	}
+	return maxQueryTimeout
+}
+
+// queryTimeout returns the timeout of a query to a nameserver with the smoothed RTT srtt
+func queryTimeout(srtt time.Duration) time.Duration {
+	if srtt == 0 {
+		return maxQueryTimeout
+	}
+	t := 3 * srtt
+	if t < minQueryTimeout {
+		return minQueryTimeout
+	}
+	if t > maxQueryTimeout {
+		return maxQueryTimeout
+	}
+	return t
+}
+
+// queryTimeout returns the timeout of a query to nServer adapted to its smoothed RTT
+func (d *dnsClient) queryTimeout(nServer string) time.Duration {
+	return d.cfg.nsStats.timeout(nServer)
+}
+
+// list returns names of all nameservers queried
+func (s *nameserverStats) list() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret := make([]string, 0, len(s.stats))
+	for nServer := range s.stats {
+		ret = append(ret, nServer)
+	}
+	return ret
+}
+
+// isTimeout reports whether err is a timeout
+func isTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// NameserverStats returns statistics of the nameservers set by WithNameservers in their order
+// followed by other nameservers queried, e.g. ones of policies, sorted by name
+func (r *Resolver) NameserverStats() []NameserverStat {
+	configured := r.dnsClient.getNameServers()
+	seen := make(map[string]bool, len(configured))
+	ret := make([]NameserverStat, 0, len(configured))
+	for _, nServer := range configured {
+		seen[nServer] = true
+		ret = append(ret, r.clientCfg.nsStats.get(nServer))
+	}
+
+	others := make([]string, 0)
+	for _, nServer := range r.clientCfg.nsStats.list() {
+		if !seen[nServer] {
+			others = append(others, nServer)
+		}
+	}
+	sort.Strings(others)
+	for _, nServer := range others {
+		ret = append(ret, r.clientCfg.nsStats.get(nServer))
+	}
+	return ret
+}
@@ -0,0 +1,234 @@
+package resolver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// netbiosTimeout - the time responses to a NetBIOS name query are waited for
+	netbiosTimeout = time.Second
+
+	// netbiosPort - the port of the NetBIOS name service
+	netbiosPort = 137
+
+	// netbiosMaxTtl - the max TTL of NetBIOS answers, responders often answer with days
+	netbiosMaxTtl = 300
+
+	// netbiosNameLen - the length of a NetBIOS name without the suffix
+	netbiosNameLen = 15
+
+	// netbiosTypeNB - the type of NetBIOS general name service records
+	netbiosTypeNB = 0x0020
+
+	// netbiosFlagsQuery - the RD and B flags of a broadcast name query request
+	netbiosFlagsQuery = 0x0110
+
+	// netbiosFlagResponse - the R flag of responses
+	netbiosFlagResponse = 0x8000
+)
+
+var errNetBIOSMalformed = errors.New("malformed netbios packet")
+
+// WithNetBIOS - enables NetBIOS name service (RFC 1002) lookups of single-label names as the last resort,
+// so names of SMB devices of a LAN resolve when nameservers and LLMNR do not resolve them: a name query
+// is broadcast on every IPv4 interface and IPv4 addresses of responders are cached per their TTLs
+// limited to 5 minutes. Answers required to be DNSSEC-validated never fall back to NetBIOS
+func (r *Resolver) WithNetBIOS(enabled bool) *Resolver {
+	var flag int32
+	if enabled {
+		flag = 1
+	}
+	atomic.StoreInt32(&r.clientCfg.netbios, flag)
+	return r
+}
+
+// useNetBIOS reports whether host is resolved by NetBIOS-NS if nameservers and LLMNR do not resolve it
+func (c *clientConfig) useNetBIOS(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	return atomic.LoadInt32(&c.netbios) == 1 && host != "" && len(host) <= netbiosNameLen && !strings.Contains(host, ".")
+}
+
+// netbiosLookupHost broadcasts a name query for host and returns IPv4 addresses of the first
+// positive response, ok is false if nobody answered
+func (d *dnsClient) netbiosLookupHost(ctx context.Context, host string) (hostLookup, bool) {
+	ctx, cancel := context.WithTimeout(ctx, netbiosTimeout)
+	defer cancel()
+
+	lc := net.ListenConfig{Control: setBroadcast}
+	pc, err := lc.ListenPacket(ctx, "udp4", ":0")
+	if err != nil {
+		logError(d.logger, d.cfg.tag, "Error querying NetBIOS", d.cfg.privacy.redact(host), d.cfg.privacy.redactErr(err, host))
+		return hostLookup{}, false
+	}
+	defer pc.Close()
+	d.cfg.conns.add(TransportNetBIOS, 1)
+	defer d.cfg.conns.add(TransportNetBIOS, -1)
+	conn := pc.(*net.UDPConn)
+
+	id := uint16(d.cfg.rnd.intn(1 << 16))
+	req := netbiosQuery(id, host)
+	sent := false
+	for _, bcast := range broadcastAddrs() {
+		if _, err := conn.WriteToUDP(req, &net.UDPAddr{IP: bcast, Port: netbiosPort}); err == nil {
+			sent = true
+		}
+	}
+	if !sent {
+		return hostLookup{}, false
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return hostLookup{}, false
+		}
+		ipList, ttl, err := parseNetbiosResponse(buf[:n], id)
+		if err != nil || len(ipList) == 0 {
+			continue
+		}
+		if ttl > netbiosMaxTtl {
+			ttl = netbiosMaxTtl
+		}
+		src := Source{Nameserver: from.String(), Transport: TransportNetBIOS, Time: d.cfg.clock.Now()}
+		return hostLookup{ip4: ipList, ttl4: floorTtl(ttl), ttl6: defaultTtl, src4: src}, true
+	}
+}
+
+// netbiosQuery returns a broadcast name query request of the workstation name of host
+func netbiosQuery(id uint16, host string) []byte {
+	b := make([]byte, 12, 12+34+4)
+	binary.BigEndian.PutUint16(b[0:], id)
+	binary.BigEndian.PutUint16(b[2:], netbiosFlagsQuery)
+	binary.BigEndian.PutUint16(b[4:], 1)
+	b = append(b, netbiosEncodeName(host)...)
+	b = append(b, 0, netbiosTypeNB, 0, 1)
+	return b
+}
+
+// netbiosEncodeName returns the first-level encoded label of host padded by spaces with the workstation suffix
+func netbiosEncodeName(host string) []byte {
+	name := make([]byte, netbiosNameLen+1)
+	copy(name, strings.ToUpper(host)+strings.Repeat(" ", netbiosNameLen))
+	name[netbiosNameLen] = 0x00
+
+	b := make([]byte, 0, 34)
+	b = append(b, 32)
+	for _, c := range name {
+		b = append(b, 'A'+c>>4, 'A'+c&0x0f)
+	}
+	return append(b, 0)
+}
+
+// parseNetbiosResponse returns addresses and the TTL of a positive name query response with id
+func parseNetbiosResponse(b []byte, id uint16) ([]net.IP, uint32, error) {
+	if len(b) < 12 {
+		return nil, 0, errNetBIOSMalformed
+	}
+	flags := binary.BigEndian.Uint16(b[2:])
+	if binary.BigEndian.Uint16(b[0:]) != id || flags&netbiosFlagResponse == 0 || flags&0x000f != 0 {
+		return nil, 0, errNetBIOSMalformed
+	}
+	qdCount, anCount := binary.BigEndian.Uint16(b[4:]), binary.BigEndian.Uint16(b[6:])
+
+	off := 12
+	for i := 0; i < int(qdCount); i++ {
+		var err error
+		if off, err = skipNetbiosName(b, off); err != nil {
+			return nil, 0, err
+		}
+		off += 4
+	}
+
+	var (
+		ipList []net.IP
+		ttl    uint32
+	)
+	for i := 0; i < int(anCount); i++ {
+		var err error
+		if off, err = skipNetbiosName(b, off); err != nil {
+			return nil, 0, err
+		}
+		if off+10 > len(b) {
+			return nil, 0, errNetBIOSMalformed
+		}
+		rrType := binary.BigEndian.Uint16(b[off:])
+		rrTtl := binary.BigEndian.Uint32(b[off+4:])
+		rdLen := int(binary.BigEndian.Uint16(b[off+8:]))
+		off += 10
+		if off+rdLen > len(b) {
+			return nil, 0, errNetBIOSMalformed
+		}
+		if rrType == netbiosTypeNB {
+			// entries of NB_FLAGS and an IPv4 address
+			for e := off; e+6 <= off+rdLen; e += 6 {
+				ipList = append(ipList, net.IPv4(b[e+2], b[e+3], b[e+4], b[e+5]).To4())
+			}
+			if ttl == 0 || rrTtl < ttl {
+				ttl = rrTtl
+			}
+		}
+		off += rdLen
+	}
+	return ipList, ttl, nil
+}
+
+// skipNetbiosName returns the offset after the name at off
+func skipNetbiosName(b []byte, off int) (int, error) {
+	for {
+		if off >= len(b) {
+			return 0, errNetBIOSMalformed
+		}
+		l := int(b[off])
+		switch {
+		case l == 0:
+			return off + 1, nil
+		case l&0xc0 == 0xc0:
+			return off + 2, nil
+		}
+		off += 1 + l
+	}
+}
+
+// broadcastAddrs returns broadcast addresses of IPv4 networks of interfaces which are up
+func broadcastAddrs() []net.IP {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return []net.IP{net.IPv4bcast}
+	}
+	var ret []net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagBroadcast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil || len(ipNet.Mask) != net.IPv4len {
+				continue
+			}
+			ip := ipNet.IP.To4()
+			bcast := make(net.IP, net.IPv4len)
+			for i := range bcast {
+				bcast[i] = ip[i] | ^ipNet.Mask[i]
+			}
+			ret = append(ret, bcast)
+		}
+	}
+	if len(ret) == 0 {
+		ret = append(ret, net.IPv4bcast)
+	}
+	return ret
+}
@@ -0,0 +1,62 @@
+package resolver
+
+import (
+	"context"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// NetResolver returns a net.Resolver resolving names through the lookup pipeline of r, like ServeDNS does.
+// The pure Go resolver of the net package is used and its queries are passed to r in process over
+// net.Pipe, so no port is bound and nameservers of the system configuration are not queried.
+// Names listed in /etc/hosts are still resolved from it by the net package
+func (r *Resolver) NetResolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial:     r.dialPipe,
+	}
+}
+
+// InstallDefaultResolver replaces net.DefaultResolver with NetResolver, so dialers of the net package
+// and third-party libraries calling net.Dial("tcp", "host:port") resolve hosts through the cache.
+// Returns a function restoring the previous resolver. It must be called before resolutions start,
+// net.DefaultResolver is not guarded by the net package
+func (r *Resolver) InstallDefaultResolver() (restore func()) {
+	prev := net.DefaultResolver
+	net.DefaultResolver = r.NetResolver()
+	return func() {
+		net.DefaultResolver = prev
+	}
+}
+
+// dialPipe returns a connection to an in-process forwarder answering queries through the lookup pipeline,
+// the address of the nameserver is ignored
+func (r *Resolver) dialPipe(ctx context.Context, network, address string) (net.Conn, error) {
+	if r.Stopped() {
+		return nil, ErrStopped
+	}
+	client, server := net.Pipe()
+	r.scheduler.goroutines.spawn("pipe", "", func() { r.servePipe(server) })
+	return client, nil
+}
+
+// servePipe answers queries read from conn until it is closed. The net package frames messages
+// by their length over connections which are not net.PacketConn, so net.Pipe is served like TCP
+func (r *Resolver) servePipe(conn net.Conn) {
+	defer conn.Close()
+
+	dc := &dns.Conn{Conn: conn}
+	for {
+		req, err := dc.ReadMsg()
+		if err != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), serverTimeout)
+		resp := r.answer(ctx, req)
+		cancel()
+		if err := dc.WriteMsg(resp); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package resolver
+
+import "time"
+
+const (
+	// defaultMaxErrorRate - the default error budget of nameservers
+	defaultMaxErrorRate = 0.25
+
+	// minBudgetQueries - the number of queries to a nameserver before its error rate is checked
+	minBudgetQueries = 10
+)
+
+// NameserverEventKind - a kind of a NameserverEvent
+type NameserverEventKind int
+
+const (
+	// NameserverDemoted - the nameserver is down or hijacking and is tried after the others
+	NameserverDemoted NameserverEventKind = iota
+	// NameserverRestored - the demoted nameserver answers correctly again
+	NameserverRestored
+	// NameserverOverBudget - the error rate of the nameserver exceeded the budget
+	NameserverOverBudget
+	// NameserverWithinBudget - the error rate of the nameserver fell below half of the budget
+	NameserverWithinBudget
+)
+
+// String ...
+func (k NameserverEventKind) String() string {
+	switch k {
+	case NameserverDemoted:
+		return "demoted"
+	case NameserverRestored:
+		return "restored"
+	case NameserverOverBudget:
+		return "over-budget"
+	case NameserverWithinBudget:
+		return "within-budget"
+	}
+	return "unknown"
+}
+
+// MarshalText ...
+func (k NameserverEventKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// NameserverEvent - a change of the health of a nameserver, see WithNameserverEvents
+type NameserverEvent struct {
+	Nameserver string
+	Kind       NameserverEventKind
+
+	// State - the health state after the change
+	State NameserverState
+
+	// ErrorRate - the smoothed share of failed queries, see NameserverStat.ErrorRate
+	ErrorRate float64
+
+	Time time.Time
+}
+
+// nameserverEvents - the receiver of nameserver events, guarded by the mutex of nameserverStats
+type nameserverEvents struct {
+	fn           func(ev NameserverEvent)
+	maxErrorRate float64
+}
+
+// WithNameserverEvents - sets a function receiving changes of the health of nameservers, e.g. to show
+// "Primary DNS unreachable": a nameserver is demoted when several consecutive queries to it fail
+// or it answers canary names (see WithCanary), and restored when it answers again. Budget events
+// are passed when the smoothed share of failed queries exceeds maxErrorRate (0.25 if zero) and
+// when it falls below half of it. The function is called synchronously with queries and must not block
+func (r *Resolver) WithNameserverEvents(fn func(ev NameserverEvent), maxErrorRate float64) *Resolver {
+	if maxErrorRate <= 0 {
+		maxErrorRate = defaultMaxErrorRate
+	}
+	s := &r.clientCfg.nsStats
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = nameserverEvents{fn: fn, maxErrorRate: maxErrorRate}
+	return r
+}
+
+// demoted ...
+func demoted(s NameserverState) bool {
+	return s == NameserverDown || s == NameserverHijacking
+}
+
+// stateEvents returns events of nameserver st changed from the state prev, s.mu must be held
+func (s *nameserverStats) stateEvents(st *nameserverStat, prev NameserverState, now time.Time) []NameserverEvent {
+	if s.events.fn == nil {
+		return nil
+	}
+	var evs []NameserverEvent
+	add := func(kind NameserverEventKind) {
+		evs = append(evs, NameserverEvent{
+			Nameserver: st.Nameserver,
+			Kind:       kind,
+			State:      st.state(),
+			ErrorRate:  st.ErrorRate,
+			Time:       now,
+		})
+	}
+
+	switch cur := st.state(); {
+	case demoted(cur) && !demoted(prev):
+		add(NameserverDemoted)
+	case !demoted(cur) && demoted(prev):
+		add(NameserverRestored)
+	}
+	switch {
+	case !st.overBudget && st.Queries >= minBudgetQueries && st.ErrorRate > s.events.maxErrorRate:
+		st.overBudget = true
+		add(NameserverOverBudget)
+	case st.overBudget && st.ErrorRate < s.events.maxErrorRate/2:
+		st.overBudget = false
+		add(NameserverWithinBudget)
+	}
+	return evs
+}
+
+// emitNameserverEvents passes evs to fn set by WithNameserverEvents, the stats mutex must not be held
+func emitNameserverEvents(fn func(ev NameserverEvent), evs []NameserverEvent) {
+	for _, ev := range evs {
+		fn(ev)
+	}
+}
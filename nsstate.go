@@ -0,0 +1,118 @@
+package resolver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// maxSavedNameserverAge - saved statistics of nameservers not queried for longer are not loaded
+const maxSavedNameserverAge = 7 * 24 * time.Hour
+
+// savedNameserver - learned health of a nameserver written by SaveNameserverStats
+type savedNameserver struct {
+	Nameserver string        `json:"nameserver"`
+	SRTT       time.Duration `json:"srtt"`
+	ErrorRate  float64       `json:"error_rate"`
+	Failures   int           `json:"failures"`
+	Queries    uint64        `json:"queries"`
+	Errors     uint64        `json:"errors"`
+	Timeouts   uint64        `json:"timeouts"`
+	LastUsed   time.Time     `json:"last_used"`
+}
+
+// SaveNameserverStats writes learned RTTs and health of nameservers into w as JSON lines,
+// the output may be loaded back by WithNameserverStats after a restart
+func (r *Resolver) SaveNameserverStats(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, saved := range r.clientCfg.nsStats.save() {
+		if err := enc.Encode(saved); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WithNameserverStats - loads RTTs and health of nameservers saved by SaveNameserverStats from the file
+// at path, so the strategy prefers reliable and fast nameservers from the start instead of learning
+// through failures. Nameservers not queried for a week and ones already queried are skipped,
+// a missing file is not an error
+func (r *Resolver) WithNameserverStats(path string) *Resolver {
+	f, err := os.Open(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logError(r.logger, r.tag, "Error loading nameserver stats", err)
+		}
+		return r
+	}
+	defer f.Close()
+
+	var list []savedNameserver
+	dec := json.NewDecoder(f)
+	for {
+		var saved savedNameserver
+		if err := dec.Decode(&saved); err != nil {
+			if err != io.EOF {
+				logError(r.logger, r.tag, "Error parsing nameserver stats", path, err)
+			}
+			break
+		}
+		list = append(list, saved)
+	}
+	cnt := r.clientCfg.nsStats.load(list, r.clock.Now())
+	logInfo(r.logger, r.tag, "Nameserver stats loaded:", cnt)
+	return r
+}
+
+// save ...
+func (s *nameserverStats) save() []savedNameserver {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret := make([]savedNameserver, 0, len(s.stats))
+	for _, st := range s.stats {
+		if st.Queries == 0 {
+			continue
+		}
+		ret = append(ret, savedNameserver{
+			Nameserver: st.Nameserver,
+			SRTT:       st.SRTT,
+			ErrorRate:  st.ErrorRate,
+			Failures:   st.failures,
+			Queries:    st.Queries,
+			Errors:     st.Errors,
+			Timeouts:   st.Timeouts,
+			LastUsed:   st.LastUsed,
+		})
+	}
+	return ret
+}
+
+// load sets statistics of nameservers which were not queried yet, returns the number of them
+func (s *nameserverStats) load(list []savedNameserver, now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cnt := 0
+	for _, saved := range list {
+		if saved.Nameserver == "" || now.Sub(saved.LastUsed) > maxSavedNameserverAge {
+			continue
+		}
+		st := s.getOrAdd(saved.Nameserver)
+		if st.Queries > 0 {
+			continue
+		}
+		st.SRTT = saved.SRTT
+		if st.SRTT > maxQueryTimeout {
+			st.SRTT = maxQueryTimeout
+		}
+		st.ErrorRate = saved.ErrorRate
+		st.failures = saved.Failures
+		st.Queries, st.Errors, st.Timeouts = saved.Queries, saved.Errors, saved.Timeouts
+		st.LastUsed = saved.LastUsed
+		cnt++
+	}
+	return cnt
+}
@@ -0,0 +1,38 @@
+package resolver
+
+// QueryOption - an option of a single GetNextIP* call
+type QueryOption func(*queryOptions)
+
+// queryOptions ...
+type queryOptions struct {
+	// family - the preferred address family, FamilyAll means the family of the call
+	family Family
+
+	// noAutoAdd - do not create a host which is not maintained yet
+	noAutoAdd bool
+}
+
+// newQueryOptions ...
+func newQueryOptions(opts []QueryOption) queryOptions {
+	var o queryOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithFamilyPreference - prefer addresses of family falling back to the other family
+// if the host has no addresses of the preferred one
+func WithFamilyPreference(family Family) QueryOption {
+	return func(o *queryOptions) {
+		o.family = family
+	}
+}
+
+// WithNoAutoAdd - do not add a host to maintaining if it is not maintained yet,
+// the call returns an empty address instead
+func WithNoAutoAdd() QueryOption {
+	return func(o *queryOptions) {
+		o.noAutoAdd = true
+	}
+}
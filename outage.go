@@ -0,0 +1,72 @@
+package resolver
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// staleTtl - the TTL of stale answers served in an outage, RFC 8767
+const staleTtl = 30
+
+// outage - tracking of failures of all nameservers for the outage mode
+type outage struct {
+	// after - the time all queries must be failing for to start an outage, zero disables the mode
+	after int64
+
+	// failingSince - time of the first failed query after the last succeeded one in unix nanoseconds,
+	// zero if the last query succeeded
+	failingSince int64
+}
+
+// record accounts a query, returns true if an outage ended with the query
+func (o *outage) record(failed bool, now time.Time) bool {
+	if failed {
+		atomic.CompareAndSwapInt64(&o.failingSince, 0, now.UnixNano())
+		return false
+	}
+	since := atomic.SwapInt64(&o.failingSince, 0)
+	after := atomic.LoadInt64(&o.after)
+	return since != 0 && after > 0 && now.UnixNano()-since > after
+}
+
+// enabled reports whether the outage mode is set
+func (o *outage) enabled() bool {
+	return atomic.LoadInt64(&o.after) > 0
+}
+
+// active reports whether there is an outage at now
+func (o *outage) active(now time.Time) bool {
+	after := atomic.LoadInt64(&o.after)
+	since := atomic.LoadInt64(&o.failingSince)
+	return after > 0 && since != 0 && now.UnixNano()-since > after
+}
+
+// isFailure reports whether a query failed: it returned an error, SERVFAIL or REFUSED
+func isFailure(in *dns.Msg, err error) bool {
+	return err != nil || (in != nil && (in.Rcode == dns.RcodeServerFailure || in.Rcode == dns.RcodeRefused))
+}
+
+// recordQuery accounts a query to nServer in the nameserver statistics and the outage mode
+func (d *dnsClient) recordQuery(nServer string, in *dns.Msg, err error, rtt time.Duration) {
+	now := d.cfg.clock.Now()
+	d.cfg.nsStats.record(nServer, in, err, rtt, now)
+	if d.cfg.outage.record(isFailure(in, err), now) {
+		logInfo(d.logger, d.cfg.tag, "Nameservers recovered from an outage")
+	}
+}
+
+// WithOutageMode - when all queries to nameservers are failing for longer than after, hosts are not
+// deleted as old and expired addresses are served as stale with a TTL of 30 seconds, the server modes
+// flag them with the Stale Answer extended error (RFC 8914). The mode ends with the first query succeeded.
+// Zero disables the mode
+func (r *Resolver) WithOutageMode(after time.Duration) *Resolver {
+	atomic.StoreInt64(&r.clientCfg.outage.after, int64(after))
+	return r
+}
+
+// InOutage reports whether all nameservers are failing for longer than set by WithOutageMode
+func (r *Resolver) InOutage() bool {
+	return r.clientCfg.outage.active(r.clock.Now())
+}
@@ -0,0 +1,288 @@
+package resolver
+
+import (
+	"context"
+	"math"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// lookup - a query passed through the lookup pipeline
+type lookup struct {
+	// name - the host name in lower case without the trailing dot, the Go API passes names as given
+	// so they match hosts added by AddHost
+	name string
+
+	// qtype - the type queried, dns.TypeA, dns.TypeAAAA and dns.TypeNone ask for addresses of both families
+	qtype uint16
+
+	// dnssecOK - DNSSEC records are requested
+	dnssecOK bool
+
+	// noAutoAdd - do not add a host which is not maintained yet, it is resolved without caching
+	noAutoAdd bool
+
+	// rewritten - the name is the target of a rewrite rule, it is not rewritten again
+	rewritten bool
+
+	// cacheOnly - a name no stage answers is not forwarded to nameservers, the result is empty then,
+	// the first resolution of a maintained host is not waited for
+	cacheOnly bool
+
+	// sourceOnly - only the host or the rewrite rule serving addresses is needed to rotate them,
+	// their addresses are not copied into the result
+	sourceOnly bool
+}
+
+// newLookup ...
+func newLookup(name string, qtype uint16) lookup {
+	return lookup{name: normalizeName(name), qtype: qtype}
+}
+
+// apiLookup returns a lookup of addresses of hostName made by the Go API, the name is kept as given
+// but the port, so it matches hosts added by AddHost
+func apiLookup(hostName string, qtype uint16) lookup {
+	return lookup{name: hostOnly(hostName), qtype: qtype}
+}
+
+// lookupMaintained passes a lookup of addresses of hostName through the pipeline without adding
+// the host to maintaining or forwarding it, so only rewrite rules, static hosts, mirrored zones
+// and maintained hosts answer
+func (r *Resolver) lookupMaintained(hostName string) lookupResult {
+	l := apiLookup(hostName, dns.TypeNone)
+	l.noAutoAdd, l.cacheOnly = true, true
+	return r.resolve(context.Background(), l)
+}
+
+// isAddr reports whether addresses are queried
+func (l lookup) isAddr() bool {
+	return l.qtype == dns.TypeA || l.qtype == dns.TypeAAAA || l.qtype == dns.TypeNone
+}
+
+// lookupResult - an answer of the lookup pipeline
+type lookupResult struct {
+	// err - an error of the lookup, rcode is not set then
+	err error
+
+	rcode int
+
+	// ip4, ip6, ttl4, ttl6 - addresses and their TTLs for queries of addresses
+	ip4, ip6   []net.IP
+	ttl4, ttl6 uint32
+
+	// answer, ns, extra, ad - sections and the AD flag of a forwarded response
+	answer, ns, extra []dns.RR
+	ad                bool
+
+	// stale - expired addresses are served in an outage
+	stale bool
+
+	// cname - the target of a rewrite rule the result is for, empty if the name was not rewritten
+	cname string
+
+	// chain - CNAME records the addresses were resolved through
+	chain []CNAMELink
+
+	// host - the static or maintained host serving the addresses, nil if they are not served by a host
+	host *host
+
+	// rewrite - the rewrite rule answering with its addresses
+	rewrite *rewriteEntry
+
+	// readyWait - the time the lookup blocked waiting for the first resolution of the host
+	readyWait time.Duration
+}
+
+// addrs returns addresses of the result, of its host or rewrite rule for sourceOnly lookups
+func (res lookupResult) addrs() ([]net.IP, []net.IP) {
+	switch {
+	case res.host != nil:
+		return res.host.getIPs()
+	case res.rewrite != nil:
+		return res.rewrite.ip4.getList(), res.rewrite.ip6.getList()
+	}
+	return res.ip4, res.ip6
+}
+
+// addrResult returns a result of a query of addresses of an existing name, NOERROR with no answer
+// (NODATA) if there are no addresses
+func addrResult(ip4, ip6 []net.IP, ttl4, ttl6 uint32) lookupResult {
+	return lookupResult{rcode: dns.RcodeSuccess, ip4: ip4, ip6: ip6, ttl4: ttl4, ttl6: ttl6}
+}
+
+// lookupStage - a stage of the pipeline, ok is false if the lookup is passed to the next stage
+type lookupStage func(ctx context.Context, l lookup) (res lookupResult, ok bool)
+
+// resolve passes a lookup through the pipeline: rewrite rules, local hosts, the blocklist, the cache
+// and the nameservers of the matching policy. The Go API and the server modes use it
+// so all of them answer the same way
+func (r *Resolver) resolve(ctx context.Context, l lookup) lookupResult {
+	if r.Stopped() {
+		return lookupResult{err: ErrStopped}
+	}
+	for _, stage := range []lookupStage{r.lookupRewritten, r.lookupLocal, r.lookupBlocked, r.lookupCached} {
+		if res, ok := stage(ctx, l); ok {
+			return res
+		}
+	}
+	if l.cacheOnly {
+		return lookupResult{rcode: dns.RcodeSuccess}
+	}
+	return r.lookupForwarded(ctx, l)
+}
+
+// lookupLocal answers with addresses of static hosts set by UpdateHostsFromMaping including wildcard ones,
+// the hosts have no records of other types, and with records of zones mirrored by MirrorZone
+func (r *Resolver) lookupLocal(ctx context.Context, l lookup) (lookupResult, bool) {
+	r.mu.RLock()
+	h, ok := r.staticHost(l.name)
+	r.mu.RUnlock()
+
+	if !ok || !h.static {
+		if mz := r.zones.find(l.name); mz != nil {
+			return mz.lookup(l)
+		}
+		return lookupResult{}, false
+	}
+	if !l.isAddr() {
+		return lookupResult{rcode: dns.RcodeSuccess}, true
+	}
+	h.updLastTime()
+	if l.sourceOnly {
+		return lookupResult{rcode: dns.RcodeSuccess, host: h}, true
+	}
+	ip4, ip6 := h.getIPs()
+	res := addrResult(ip4, ip6, defaultTtl, defaultTtl)
+	res.host = h
+	return res, true
+}
+
+// lookupBlocked answers NXDOMAIN for hosts matching the blocklist
+func (r *Resolver) lookupBlocked(ctx context.Context, l lookup) (lookupResult, bool) {
+	if !r.blocklist.match(l.name) {
+		return lookupResult{}, false
+	}
+	return lookupResult{rcode: dns.RcodeNameError}, true
+}
+
+// lookupCached answers with addresses of maintained hosts adding the host to maintaining
+// unless noAutoAdd is set, and with RRsets maintained by Maintain or by hosts per Policy.Qtypes
+func (r *Resolver) lookupCached(ctx context.Context, l lookup) (lookupResult, bool) {
+	if !l.isAddr() {
+		rrs, src := r.getRecords(l.name, l.qtype)
+		if rrs == nil {
+			if rrset, ok := r.hostRRset(l.name, l.qtype); ok {
+				rrs, src = rrset.rrs, rrset.src
+			}
+		}
+		if rrs == nil {
+			return lookupResult{}, false
+		}
+		return lookupResult{rcode: dns.RcodeSuccess, answer: decayTtl(rrs, r.clock.Now().Sub(src.Time))}, true
+	}
+
+	h, ev := r.getHost(l.name, !l.noAutoAdd)
+	if h == nil {
+		return lookupResult{}, false
+	}
+	qtype := l.qtype
+	if qtype == dns.TypeNone {
+		qtype = dns.TypeA
+	}
+	r.clientCfg.qlog.logCache(r.tag, l.name, qtype, ev)
+
+	var wait time.Duration
+	if !l.cacheOnly {
+		var err error
+		if wait, err = h.waitReady(ctx); err != nil {
+			return lookupResult{err: err, readyWait: wait}, true
+		}
+	}
+	h.resume()
+	h.updLastTime()
+	if l.sourceOnly {
+		return lookupResult{rcode: dns.RcodeSuccess, host: h, readyWait: wait}, true
+	}
+
+	ip4, ip6 := h.getIPs()
+	if len(ip4) == 0 && len(ip6) == 0 {
+		if err := h.getErr(); err != nil {
+			return lookupResult{err: err, readyWait: wait}, true
+		}
+		if atomic.LoadInt32(&h.nxdomain) == 1 {
+			return lookupResult{rcode: dns.RcodeNameError, readyWait: wait}, true
+		}
+	}
+	res := addrResult(ip4, ip6, h.remainingTtl(FamilyV4), h.remainingTtl(FamilyV6))
+	res.chain, res.host, res.readyWait = h.chain.get(), h, wait
+	if r.InOutage() {
+		if len(ip4) > 0 && res.ttl4 == 0 {
+			res.ttl4, res.stale = staleTtl, true
+		}
+		if len(ip6) > 0 && res.ttl6 == 0 {
+			res.ttl6, res.stale = staleTtl, true
+		}
+	}
+	return res, true
+}
+
+// decayTtl returns copies of cached rrs with TTLs decremented by the time passed since they were
+// obtained like caching resolvers answer, rrs are returned as is if less than a second passed
+func decayTtl(rrs []dns.RR, elapsed time.Duration) []dns.RR {
+	if elapsed < time.Second {
+		return rrs
+	}
+	sec := uint32(elapsed / time.Second)
+	if elapsed >= math.MaxUint32*time.Second {
+		sec = math.MaxUint32
+	}
+	ret := make([]dns.RR, len(rrs))
+	for i, rr := range rrs {
+		rr = dns.Copy(rr)
+		if hdr := rr.Header(); hdr.Ttl > sec {
+			hdr.Ttl -= sec
+		} else {
+			hdr.Ttl = 0
+		}
+		ret[i] = rr
+	}
+	return ret
+}
+
+// lookupForwarded answers with a response of the nameservers of the policy matching the name
+func (r *Resolver) lookupForwarded(ctx context.Context, l lookup) lookupResult {
+	dnsClient, policy := r.hostClient(l.name)
+	secure := policy.requireDNSSEC() && !r.trustAnchors.isNegative(l.name)
+
+	if l.isAddr() {
+		hl, err := dnsClient.lookupHost(ctx, l.name, policy.family(), secure)
+		if err != nil {
+			return lookupResult{err: err}
+		}
+		ip4, ip6 := policy.filter(hl.ip4), policy.filter(hl.ip6)
+		if len(ip4) == 0 && len(ip6) == 0 && hl.nxdomain {
+			return lookupResult{rcode: dns.RcodeNameError}
+		}
+		res := addrResult(ip4, ip6, hl.ttl4, hl.ttl6)
+		res.chain = hl.chain
+		return res
+	}
+
+	in, err := dnsClient.query(ctx, l.name, l.qtype, l.dnssecOK || secure)
+	if err == nil && secure {
+		err = checkSecure(in)
+	}
+	if err != nil {
+		return lookupResult{err: err}
+	}
+	res := lookupResult{rcode: in.Rcode, answer: in.Answer, ns: in.Ns, ad: in.AuthenticatedData}
+	for _, rr := range in.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			res.extra = append(res.extra, rr)
+		}
+	}
+	return res
+}
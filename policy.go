@@ -0,0 +1,330 @@
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Family - an address family selector
+type Family int
+
+const (
+	// FamilyAll - both IPv4 and IPv6 addresses
+	FamilyAll Family = iota
+	// FamilyV4 - IPv4 addresses only
+	FamilyV4
+	// FamilyV6 - IPv6 addresses only
+	FamilyV6
+)
+
+// String ...
+func (f Family) String() string {
+	switch f {
+	case FamilyAll:
+		return "all"
+	case FamilyV4:
+		return "v4"
+	case FamilyV6:
+		return "v6"
+	}
+	return "unknown"
+}
+
+// MarshalText ...
+func (f Family) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// UnmarshalText ...
+func (f *Family) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "all":
+		*f = FamilyAll
+	case "v4":
+		*f = FamilyV4
+	case "v6":
+		*f = FamilyV6
+	default:
+		return fmt.Errorf("unknown family %q", b)
+	}
+	return nil
+}
+
+// hasV4 ...
+func (f Family) hasV4() bool {
+	return f != FamilyV6
+}
+
+// hasV6 ...
+func (f Family) hasV6() bool {
+	return f != FamilyV4
+}
+
+// Policy - a set of rules applied to every host whose name matches the policy pattern
+type Policy struct {
+	// MinTTL - the lower bound of the refresh interval, zero means no bound
+	MinTTL time.Duration
+
+	// MaxTTL - the upper bound of the refresh interval, zero means no bound
+	MaxTTL time.Duration
+
+	// Family - address families to resolve
+	Family Family
+
+	// Qtypes - types kept fresh for matching hosts, e.g. A, AAAA and HTTPS, instead of addresses of Family.
+	// A and AAAA select address families, addresses are not resolved if there are neither of them.
+	// RRsets of the other types are refreshed per their TTLs along with addresses, see GetHostRecords
+	Qtypes []uint16
+
+	// Nameservers - nameservers used for matching hosts instead of the ones passed to WithNameservers
+	Nameservers []string
+
+	// Filter - if set, only addresses for which it returns true are kept
+	Filter func(ip net.IP) bool
+
+	// ExpectedPrefixes - if set, an answer with any address outside of the prefixes is rejected
+	// as a possible result of cache poisoning: the current addresses are kept, the error wrapping
+	// ErrUnexpectedAddress is logged and reported by LastError and Stats.UnexpectedAnswers is
+	// incremented. Ranges of an AS should be listed as its announced prefixes
+	ExpectedPrefixes []netip.Prefix
+
+	// MaxAnswers - the max number of addresses of each family kept for matching hosts, overrides
+	// WithMaxAnswersPerHost. Addresses are ranked by PreferPrefixes and then by RTT (see ReportRTT)
+	// and the best ones are kept, so connections are reused across fewer addresses of big CDN answers
+	MaxAnswers int
+
+	// PreferPrefixes - addresses in the prefixes go first in the order of the prefixes,
+	// e.g. addresses of the local network or of a preferred provider
+	PreferPrefixes []netip.Prefix
+
+	// HardExpiry - the time addresses of matching hosts are usable for after they were resolved,
+	// overrides WithHardExpiry
+	HardExpiry time.Duration
+
+	// HoldDown - the number of consecutive refreshes an address must be absent from before it is removed
+	// from matching hosts, overrides WithHoldDown
+	HoldDown int
+
+	// MappedV4 - how IPv4-mapped IPv6 addresses in AAAA answers of matching hosts are handled
+	MappedV4 MappedV4
+
+	// FamilyFallback - flags allowing GetNextIP* to return an address of the other family
+	// if a matching host has no addresses of the family of the call, e.g. FallbackMapped
+	FamilyFallback FamilyFallback
+
+	// OnEmpty - what GetNextIP* do when a matching host has no usable addresses, see EmptyAction
+	OnEmpty EmptyAction
+
+	// EmptyWait - the max time GetNextIP* block with EmptyWait, 5 seconds if not set
+	EmptyWait time.Duration
+
+	// EmptyFallback - addresses GetNextIP* return with EmptyFallback
+	EmptyFallback []net.IP
+
+	// Class - the priority class of matching hosts, see HostClass
+	Class HostClass
+
+	// RequireDNSSEC - accept only answers validated by a DNSSEC-aware nameserver (the AD bit),
+	// insecure and bogus answers are rejected and reported by LastError. Hosts under
+	// a negative trust anchor are exempt
+	RequireDNSSEC bool
+}
+
+// clampTtl ...
+func (p *Policy) clampTtl(ttl uint32) uint32 {
+	if p == nil {
+		return ttl
+	}
+	if lo := uint32(p.MinTTL / time.Second); p.MinTTL > 0 && ttl < lo {
+		ttl = lo
+	}
+	if hi := uint32(p.MaxTTL / time.Second); p.MaxTTL > 0 && ttl > hi {
+		ttl = hi
+	}
+	return ttl
+}
+
+// filter ...
+func (p *Policy) filter(ipList []net.IP) []net.IP {
+	if p == nil || p.Filter == nil {
+		return ipList
+	}
+	ret := make([]net.IP, 0, len(ipList))
+	for _, ip := range ipList {
+		if p.Filter(ip) {
+			ret = append(ret, ip)
+		}
+	}
+	return ret
+}
+
+// family ...
+func (p *Policy) family() Family {
+	if p == nil {
+		return FamilyAll
+	}
+	if len(p.Qtypes) > 0 {
+		v4, v6 := p.hasQtype(dns.TypeA), p.hasQtype(dns.TypeAAAA)
+		switch {
+		case v4 && !v6:
+			return FamilyV4
+		case v6 && !v4:
+			return FamilyV6
+		}
+		return FamilyAll
+	}
+	return p.Family
+}
+
+// resolvesAddrs reports whether addresses of matching hosts are resolved
+func (p *Policy) resolvesAddrs() bool {
+	return p == nil || len(p.Qtypes) == 0 || p.hasQtype(dns.TypeA) || p.hasQtype(dns.TypeAAAA)
+}
+
+// rrsetTypes returns types of Qtypes other than A and AAAA
+func (p *Policy) rrsetTypes() []uint16 {
+	if p == nil {
+		return nil
+	}
+	var ret []uint16
+	for _, qtype := range p.Qtypes {
+		if qtype != dns.TypeA && qtype != dns.TypeAAAA && qtype != dns.TypeNone {
+			ret = append(ret, qtype)
+		}
+	}
+	return ret
+}
+
+// hasQtype ...
+func (p *Policy) hasQtype(qtype uint16) bool {
+	for _, t := range p.Qtypes {
+		if t == qtype {
+			return true
+		}
+	}
+	return false
+}
+
+// requireDNSSEC ...
+func (p *Policy) requireDNSSEC() bool {
+	return p != nil && p.RequireDNSSEC
+}
+
+// policyEntry ...
+type policyEntry struct {
+	pattern string
+	policy  Policy
+
+	// dnsClient - a client using the policy nameservers, nil if the policy has none
+	dnsClient *dnsClient
+}
+
+// policies - an ordered list of pattern policies, the first matching one wins
+type policies struct {
+	mu   sync.RWMutex
+	list []*policyEntry
+}
+
+// add ...
+func (p *policies) add(e *policyEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.list = append(p.list, e)
+}
+
+// match returns the first policy entry matching hostName or nil
+func (p *policies) match(hostName string) *policyEntry {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, e := range p.list {
+		if matchPattern(e.pattern, hostName) {
+			return e
+		}
+	}
+	return nil
+}
+
+// matchPattern reports whether hostName matches the glob pattern, case-insensitively
+func matchPattern(pattern, hostName string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	hostName = strings.ToLower(strings.TrimSuffix(hostName, "."))
+	ok, err := path.Match(pattern, hostName)
+	return err == nil && ok
+}
+
+// validPatterns returns the well-formed glob patterns of patterns logging the others, malformed
+// patterns never match since path.Match reports them only when matching
+func (r *Resolver) validPatterns(patterns ...string) []string {
+	ret := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			logError(r.logger, r.tag, "Malformed host name pattern rejected:", pattern, err)
+			continue
+		}
+		ret = append(ret, pattern)
+	}
+	return ret
+}
+
+// patternList - a list of glob patterns of host names
+type patternList struct {
+	mu   sync.RWMutex
+	list []string
+}
+
+// add ...
+func (p *patternList) add(patterns ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.list = append(p.list, patterns...)
+}
+
+// match reports whether hostName matches one of the patterns
+func (p *patternList) match(hostName string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, pattern := range p.list {
+		if matchPattern(pattern, hostName) {
+			return true
+		}
+	}
+	return false
+}
+
+// ttlOverride ...
+type ttlOverride struct {
+	pattern string
+	ttl     time.Duration
+}
+
+// ttlOverrides - an ordered list of refresh interval overrides, the first matching one wins
+type ttlOverrides struct {
+	mu   sync.RWMutex
+	list []ttlOverride
+}
+
+// add ...
+func (o *ttlOverrides) add(pattern string, ttl time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.list = append(o.list, ttlOverride{pattern: pattern, ttl: ttl})
+}
+
+// match returns the refresh interval override for hostName, zero if there is none
+func (o *ttlOverrides) match(hostName string) time.Duration {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	for _, e := range o.list {
+		if matchPattern(e.pattern, hostName) {
+			return e.ttl
+		}
+	}
+	return 0
+}
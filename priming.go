@@ -0,0 +1,154 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// primingParallelism - the max number of hosts re-resolved at once on a nameserver change
+	primingParallelism = 16
+
+	// primingTimeout - the timeout of re-resolving a host on a nameserver change
+	primingTimeout = 10 * time.Second
+)
+
+// PrimingDivergence - a host whose addresses resolved by new nameservers differ from the cached ones
+// or could not be resolved by them, see WithNameserverPriming
+type PrimingDivergence struct {
+	Host string
+
+	// Nameservers - the new nameservers
+	Nameservers []string
+
+	// Old4, Old6 - the addresses before the change, New4, New6 - the addresses resolved by the new nameservers
+	Old4, Old6 []net.IP
+	New4, New6 []net.IP
+
+	// Err - the error of the resolution, the old addresses are kept
+	Err error
+}
+
+// priming ...
+type priming struct {
+	fn func(d PrimingDivergence)
+}
+
+// WithNameserverPriming - when WithNameservers replaces nameservers, re-resolves all explicitly added
+// hosts against the new ones at once instead of waiting for their TTLs, and logs the hosts whose
+// addresses differ from the cached ones or fail to resolve. fn, if set, receives every such host,
+// e.g. to alert on a misconfigured nameserver; it is called from a background goroutine.
+// Addresses of the hosts are replaced by the new ones like by a refresh
+func (r *Resolver) WithNameserverPriming(enabled bool, fn func(d PrimingDivergence)) *Resolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.priming = nil
+	if enabled {
+		r.priming = &priming{fn: fn}
+	}
+	return r
+}
+
+// primeOnChange starts priming if it is enabled and nameservers changed from old to cur,
+// setting the first nameservers is not a change
+func (r *Resolver) primeOnChange(old, cur []string) {
+	r.mu.RLock()
+	p := r.priming
+	r.mu.RUnlock()
+	if p == nil || len(old) == 0 || sameStrings(old, cur) {
+		return
+	}
+	r.scheduler.goroutines.spawn("priming", "", func() { r.prime(p, cur) })
+}
+
+// prime re-resolves explicitly added hosts resolved by the default nameservers and reports divergences
+func (r *Resolver) prime(p *priming, nameServers []string) {
+	var hosts []*host
+	r.mu.RLock()
+	for _, h := range r.hosts {
+		if !h.static && h.dnsClient == r.dnsClient && h.isExplicitlyAdded() && h.isReady() && h.policy.resolvesAddrs() {
+			hosts = append(hosts, h)
+		}
+	}
+	r.mu.RUnlock()
+	if len(hosts) == 0 {
+		return
+	}
+
+	divergences := make([]*PrimingDivergence, len(hosts))
+	var g errgroup.Group
+	g.SetLimit(primingParallelism)
+	for i, h := range hosts {
+		i, h := i, h
+		g.Go(func() error {
+			divergences[i] = primeHost(h)
+			return nil
+		})
+	}
+	g.Wait()
+
+	diverged := 0
+	for _, d := range divergences {
+		if d == nil {
+			continue
+		}
+		diverged++
+		d.Nameservers = nameServers
+		logError(r.logger, r.tag, "Addresses of host diverged after nameserver change", r.clientCfg.privacy.redact(d.Host),
+			"old:", d.Old4, d.Old6, "new:", d.New4, d.New6, r.clientCfg.privacy.redactErr(d.Err, d.Host))
+		if p.fn != nil {
+			p.fn(*d)
+		}
+	}
+	logInfo(r.logger, r.tag, "Re-resolved hosts after nameserver change:", len(hosts), "diverged:", diverged)
+}
+
+// primeHost re-resolves addresses of h and reschedules their refreshes,
+// returns the divergence or nil if the addresses are the same
+func primeHost(h *host) *PrimingDivergence {
+	family := h.policy.family()
+	old4, old6 := h.ip4.getList(), h.ip6.getList()
+
+	ctx, cancel := context.WithTimeout(context.Background(), primingTimeout)
+	ttl4, ttl6 := h.reloadIPs(ctx, family)
+	cancel()
+
+	now := h.clock.Now()
+	if family.hasV4() {
+		h.scheduler.reschedule(h, FamilyV4, now.Add(h.refreshDelay(ttl4)))
+	}
+	if family.hasV6() {
+		h.scheduler.reschedule(h, FamilyV6, now.Add(h.refreshDelay(ttl6)))
+	}
+
+	d := &PrimingDivergence{Host: h.hostName, Old4: old4, Old6: old6, Err: h.getErr()}
+	if d.Err != nil {
+		return d
+	}
+	d.New4, d.New6 = h.ip4.getList(), h.ip6.getList()
+	if sameIPSet(old4, d.New4) && sameIPSet(old6, d.New6) {
+		return nil
+	}
+	return d
+}
+
+// sameIPSet reports whether a and b have the same addresses in any order
+func sameIPSet(a, b []net.IP) bool {
+	return len(diffIPs(a, b)) == 0 && len(diffIPs(b, a)) == 0
+}
+
+// sameStrings ...
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
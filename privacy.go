@@ -0,0 +1,144 @@
+package resolver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+)
+
+const (
+	// redactedName - the replacement of names with NamesRedacted
+	redactedName = "[redacted]"
+
+	// nameHashLen - the number of hex digits of hashed names
+	nameHashLen = 16
+
+	// maxNameHashes - the max number of hashed names kept for UnhashName, the mapping is reset when it is full
+	maxNameHashes = 65536
+)
+
+// NamePrivacy - how host names are written to logs, Dump and metrics labels, see WithNamePrivacy
+type NamePrivacy int
+
+const (
+	// NamesPlain - names are written as is
+	NamesPlain NamePrivacy = iota
+	// NamesHashed - names are replaced by "h-" followed by 16 hex digits of their salted HMAC-SHA256,
+	// the same name always has the same hash, see UnhashName
+	NamesHashed
+	// NamesRedacted - names are replaced by "[redacted]"
+	NamesRedacted
+)
+
+// namePrivacy - the name privacy mode of a resolver
+type namePrivacy struct {
+	mu     sync.RWMutex
+	mode   NamePrivacy
+	salt   []byte
+	hashes map[string]string
+}
+
+// WithNamePrivacy - sets how host names are written to logs, query logs, history stores, Dump, DumpPrefix,
+// DumpZone and host labels of DumpMetrics, for deployments where visited names are personal data.
+// With NamesHashed names are hashed with salt, a random secret kept by the caller, and hashes seen may be
+// mapped back to names by UnhashName and the /names debug endpoint. Names in API results are not changed
+func (r *Resolver) WithNamePrivacy(mode NamePrivacy, salt []byte) *Resolver {
+	p := &r.clientCfg.privacy
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mode = mode
+	p.salt = append([]byte(nil), salt...)
+	p.hashes = nil
+	return r
+}
+
+// UnhashName returns the name of a hash written with NamesHashed, ok is false if the name
+// was not seen since the mapping was last reset
+func (r *Resolver) UnhashName(hash string) (name string, ok bool) {
+	p := &r.clientCfg.privacy
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	name, ok = p.hashes[hash]
+	return name, ok
+}
+
+// redact returns name as it should be written per the privacy mode
+func (p *namePrivacy) redact(name string) string {
+	p.mu.RLock()
+	mode, salt := p.mode, p.salt
+	p.mu.RUnlock()
+
+	switch mode {
+	case NamesHashed:
+		mac := hmac.New(sha256.New, salt)
+		mac.Write([]byte(strings.ToLower(strings.TrimSuffix(name, "."))))
+		hash := "h-" + hex.EncodeToString(mac.Sum(nil))[:nameHashLen]
+		p.remember(hash, name)
+		return hash
+	case NamesRedacted:
+		return redactedName
+	}
+	return name
+}
+
+// redactList ...
+func (p *namePrivacy) redactList(names []string) []string {
+	if !p.enabled() {
+		return names
+	}
+	ret := make([]string, len(names))
+	for i, name := range names {
+		ret[i] = p.redact(name)
+	}
+	return ret
+}
+
+// redactErr returns err with name replaced per the privacy mode, errors of lookups often contain names
+func (p *namePrivacy) redactErr(err error, name string) interface{} {
+	if err == nil || !p.enabled() || name == "" {
+		return err
+	}
+	return strings.ReplaceAll(err.Error(), strings.TrimSuffix(name, "."), p.redact(name))
+}
+
+// enabled ...
+func (p *namePrivacy) enabled() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.mode != NamesPlain
+}
+
+// remember keeps the name of hash for UnhashName
+func (p *namePrivacy) remember(hash, name string) {
+	p.mu.RLock()
+	_, ok := p.hashes[hash]
+	p.mu.RUnlock()
+	if ok {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.hashes == nil || len(p.hashes) >= maxNameHashes {
+		p.hashes = make(map[string]string)
+	}
+	p.hashes[hash] = name
+}
+
+// logName returns the name of the host as it should be logged
+func (h *host) logName() string {
+	if h.dnsClient == nil {
+		return h.hostName
+	}
+	return h.dnsClient.cfg.privacy.redact(h.hostName)
+}
+
+// logErr returns err of the host as it should be logged
+func (h *host) logErr(err error) interface{} {
+	if h.dnsClient == nil {
+		return err
+	}
+	return h.dnsClient.cfg.privacy.redactErr(err, h.hostName)
+}
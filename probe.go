@@ -0,0 +1,122 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// defaultTimedLookups - the number of cached lookups TimeLookup averages if n is not set
+const defaultTimedLookups = 100
+
+// NameserverProbe - the answer of a nameserver to a query sent directly to it, see ProbeNameservers
+type NameserverProbe struct {
+	Nameserver string
+	Transport  string
+	RTT        time.Duration
+
+	// Rcode - the response code, e.g. NOERROR, empty if there is no response
+	Rcode string
+
+	// Answers - records of the answer section in the zone file format
+	Answers []string
+
+	// AuthenticatedData - the AD bit of the response
+	AuthenticatedData bool
+
+	Err error
+}
+
+// ProbeNameservers queries each nameserver of the resolver for name and qtype concurrently
+// the way refreshes do, over UDP with fallbacks to TCP or over the designated transport,
+// with the nameserver timeout. The queries are counted in NameserverStats. Probes are returned
+// in the order of nameservers
+func (r *Resolver) ProbeNameservers(ctx context.Context, name string, qtype uint16) []NameserverProbe {
+	nameServers := r.dnsClient.getNameServers()
+	probes := make([]NameserverProbe, len(nameServers))
+
+	var wg sync.WaitGroup
+	for i, nServer := range nameServers {
+		i, nServer := i, nServer
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probes[i] = r.dnsClient.probe(ctx, nServer, name, qtype)
+		}()
+	}
+	wg.Wait()
+	return probes
+}
+
+// probe queries nServer for name and qtype
+func (d *dnsClient) probe(ctx context.Context, nServer, name string, qtype uint16) NameserverProbe {
+	ctx, cancel := context.WithTimeout(ctx, d.queryTimeout(nServer))
+	defer cancel()
+
+	start := time.Now()
+	in, src, err := d.exchange(ctx, nServer, name, qtype, false)
+	p := NameserverProbe{Nameserver: nServer, Transport: src.Transport, RTT: time.Since(start), Err: err}
+	if in == nil {
+		return p
+	}
+	p.Rcode = dns.RcodeToString[in.Rcode]
+	p.AuthenticatedData = in.AuthenticatedData
+	for _, rr := range in.Answer {
+		p.Answers = append(p.Answers, rr.String())
+	}
+	return p
+}
+
+// LookupTiming - times of resolving a host, see TimeLookup
+type LookupTiming struct {
+	Host string
+
+	// Uncached - the time of resolving the host from nameservers bypassing the cache
+	Uncached    time.Duration
+	UncachedErr error
+
+	// Cached - whether the host was maintained before the first lookup through the cache
+	Cached bool
+
+	// First - the time of the first lookup through the cache, including the first resolution
+	// of the host if it was not cached
+	First time.Duration
+
+	// Hit - the mean time of the following lookups served from the cache
+	Hit time.Duration
+
+	// Result - the result of the first lookup through the cache
+	Result Result
+}
+
+// TimeLookup measures resolving a host with name hostName: once from nameservers like
+// ResolveUncached, then through the cache like ResolveMany, adding the host if it is not
+// maintained, and n more times from the cache, 100 if n is not positive
+func (r *Resolver) TimeLookup(ctx context.Context, hostName string, n int) LookupTiming {
+	if n <= 0 {
+		n = defaultTimedLookups
+	}
+	hostName = hostOnly(hostName)
+	t := LookupTiming{Host: hostName}
+
+	start := time.Now()
+	_, t.UncachedErr = r.ResolveUncached(ctx, hostName)
+	t.Uncached = time.Since(start)
+
+	r.mu.RLock()
+	_, t.Cached = r.hosts[hostName]
+	r.mu.RUnlock()
+
+	start = time.Now()
+	t.Result = r.resolveCached(ctx, hostName)
+	t.First = time.Since(start)
+
+	start = time.Now()
+	for i := 0; i < n; i++ {
+		r.resolveCached(ctx, hostName)
+	}
+	t.Hit = time.Since(start) / time.Duration(n)
+	return t
+}
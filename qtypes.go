@@ -0,0 +1,136 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// hostRRset - an RRset of a host refreshed per Policy.Qtypes
+type hostRRset struct {
+	rrs    []dns.RR
+	src    Source
+	expire time.Time
+}
+
+// hostRRsets - RRsets of a host by type
+type hostRRsets struct {
+	mu sync.RWMutex
+	m  map[uint16]hostRRset
+}
+
+// set ...
+func (s *hostRRsets) set(qtype uint16, rrset hostRRset) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = make(map[uint16]hostRRset)
+	}
+	s.m[qtype] = rrset
+}
+
+// get ...
+func (s *hostRRsets) get(qtype uint16) (hostRRset, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rrset, ok := s.m[qtype]
+	return rrset, ok
+}
+
+// dump writes RRsets sorted by type like DumpPrefix does with addresses
+func (s *hostRRsets) dump(w io.Writer, prefix, hostName string, privacy *namePrivacy, now time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	qtypes := make([]uint16, 0, len(s.m))
+	for qtype := range s.m {
+		qtypes = append(qtypes, qtype)
+	}
+	sort.Slice(qtypes, func(i, j int) bool {
+		return qtypes[i] < qtypes[j]
+	})
+
+	for _, qtype := range qtypes {
+		rrset := s.m[qtype]
+		name := strings.ToLower(dns.TypeToString[qtype])
+		for idx, rr := range rrset.rrs {
+			if privacy.enabled() {
+				rr = dns.Copy(rr)
+				rr.Header().Name = privacy.redact(rr.Header().Name) + "."
+			}
+			fmt.Fprintf(w, "%sresolver.%s.%s.%d: %s\n", prefix, name, hostName, idx, rr)
+		}
+		ttl := rrset.expire.Unix() - now.Unix()
+		if ttl < 0 {
+			ttl = 0
+		}
+		fmt.Fprintf(w, "%sresolver.%s.%s.ttl: %d\n", prefix, name, hostName, ttl)
+		fmt.Fprintf(w, "%sresolver.%s.%s.expires: %s\n", prefix, name, hostName, rrset.expire.Format(time.RFC3339))
+		fmt.Fprintf(w, "%sresolver.%s.%s.source: %s\n", prefix, name, hostName, rrset.src)
+	}
+}
+
+// resolvesAddrs reports whether addresses of the host are resolved
+func (h *host) resolvesAddrs() bool {
+	return h.rrsetType == dns.TypeNone && h.policy.resolvesAddrs()
+}
+
+// rrsetTypes returns types of RRsets refreshed with the host, the type of a record maintained by Maintain
+func (h *host) rrsetTypes() []uint16 {
+	if h.rrsetType != dns.TypeNone {
+		return []uint16{h.rrsetType}
+	}
+	return h.policy.rrsetTypes()
+}
+
+// reloadRRset reloads the RRset of qtype and returns its refresh interval,
+// the previous RRset is kept on errors
+func (h *host) reloadRRset(ctx context.Context, qtype uint16) uint32 {
+	rrs, ttl, src, err := h.dnsClient.lookupRecords(ctx, dns.Fqdn(h.hostName), qtype)
+	if err != nil {
+		logError(h.logger, h.tag, "Error reloading records for host", h.logName(), dns.TypeToString[qtype], h.logErr(err))
+		return retryIntervalSec
+	}
+	ttl = h.adjustTtl(ttl)
+	h.rrsets.set(qtype, hostRRset{
+		rrs:    rrs,
+		src:    src,
+		expire: h.clock.Now().Add(time.Duration(ttl) * time.Second),
+	})
+	return ttl
+}
+
+// GetHostRecords returns the RRset of type qtype of a maintained host kept fresh per Policy.Qtypes,
+// nil if the host is not maintained or qtype is not in the Qtypes of its policy. The host is not
+// added to maintaining and the returned records must not be modified
+func (r *Resolver) GetHostRecords(hostName string, qtype uint16) []dns.RR {
+	rrset, ok := r.hostRRset(hostName, qtype)
+	if !ok {
+		return nil
+	}
+	return rrset.rrs
+}
+
+// hostRRset returns the RRset of type qtype of a maintained host, see GetHostRecords
+func (r *Resolver) hostRRset(hostName string, qtype uint16) (hostRRset, bool) {
+	r.mu.RLock()
+	h, ok := r.hosts[hostName]
+	r.mu.RUnlock()
+
+	if !ok || h.static {
+		return hostRRset{}, false
+	}
+	h.awaitReady()
+	h.resume()
+	rrset, ok := h.rrsets.get(qtype)
+	if !ok {
+		return hostRRset{}, false
+	}
+	h.updLastTime()
+	return rrset, true
+}
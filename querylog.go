@@ -0,0 +1,132 @@
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// QueryLogRecord - a query log record of an upstream query or a cache lookup
+type QueryLogRecord struct {
+	Time time.Time `json:"time"`
+
+	// Tag - the tag of the resolver
+	Tag string `json:"tag"`
+
+	Name  string `json:"name"`
+	Qtype string `json:"qtype"`
+
+	// Nameserver, Transport, Rcode, RTT - set for upstream queries only
+	Nameserver string        `json:"nameserver,omitempty"`
+	Transport  string        `json:"transport,omitempty"`
+	Rcode      string        `json:"rcode,omitempty"`
+	RTT        time.Duration `json:"rtt,omitempty"`
+	Error      string        `json:"error,omitempty"`
+
+	// Cache - "miss" for upstream queries, a CacheEvent name for cache lookups
+	Cache string `json:"cache"`
+}
+
+// queryLog ...
+type queryLog struct {
+	mu         sync.Mutex
+	fn         func(QueryLogRecord)
+	sampleRate float64
+	rnd        *rand.Rand
+	clock      Clock
+
+	// privacy - names of records are written per the name privacy mode
+	privacy *namePrivacy
+}
+
+// sampled reports whether the next record should be logged
+func (l *queryLog) sampled() bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.fn == nil {
+		return false
+	}
+	return l.sampleRate >= 1 || l.rnd.Float64() < l.sampleRate
+}
+
+// log ...
+func (l *queryLog) log(rec QueryLogRecord) {
+	l.mu.Lock()
+	fn := l.fn
+	l.mu.Unlock()
+	fn(rec)
+}
+
+// logQuery logs an upstream query if it is sampled
+func (l *queryLog) logQuery(tag, nServer, transport string, m, in *dns.Msg, err error, rtt time.Duration) {
+	if !l.sampled() {
+		return
+	}
+	rec := QueryLogRecord{
+		Time:       l.clock.Now(),
+		Tag:        tag,
+		Name:       l.privacy.redact(m.Question[0].Name),
+		Qtype:      dns.TypeToString[m.Question[0].Qtype],
+		Nameserver: nServer,
+		Transport:  transport,
+		RTT:        rtt,
+		Cache:      "miss",
+	}
+	if in != nil {
+		rec.Rcode = dns.RcodeToString[in.Rcode]
+	}
+	if err != nil {
+		rec.Error = fmt.Sprint(l.privacy.redactErr(err, m.Question[0].Name))
+	}
+	l.log(rec)
+}
+
+// logCache logs a cache lookup if it is sampled
+func (l *queryLog) logCache(tag, hostName string, qtype uint16, ev CacheEvent) {
+	if !l.sampled() {
+		return
+	}
+	l.log(QueryLogRecord{
+		Time:  l.clock.Now(),
+		Tag:   tag,
+		Name:  l.privacy.redact(dns.Fqdn(hostName)),
+		Qtype: dns.TypeToString[qtype],
+		Cache: ev.String(),
+	})
+}
+
+// WithQueryLog - writes query log records as JSON lines into w, sampleRate is the share
+// of records written from 0 to 1
+func (r *Resolver) WithQueryLog(w io.Writer, sampleRate float64) *Resolver {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return r.WithQueryLogFunc(func(rec QueryLogRecord) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := enc.Encode(rec); err != nil {
+			logError(r.logger, r.tag, "Error writing query log", err)
+		}
+	}, sampleRate)
+}
+
+// WithQueryLogFunc - calls fn with query log records of upstream queries and cache lookups,
+// sampleRate is the share of records passed from 0 to 1. The function is called synchronously
+func (r *Resolver) WithQueryLogFunc(fn func(QueryLogRecord), sampleRate float64) *Resolver {
+	l := r.clientCfg.qlog
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fn = fn
+	l.sampleRate = sampleRate
+	if l.rnd == nil {
+		l.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return r
+}
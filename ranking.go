@@ -0,0 +1,113 @@
+package resolver
+
+import (
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxAddrRTTs - the max number of addresses RTTs are kept for
+const maxAddrRTTs = 4096
+
+// addrRTTs - smoothed connect RTTs of addresses used to rank answers of capped hosts
+type addrRTTs struct {
+	mu sync.RWMutex
+	m  map[netip.Addr]time.Duration
+}
+
+// observe adds an RTT sample of addr, an exponential moving average like SRTT of nameservers
+func (s *addrRTTs) observe(addr netip.Addr, rtt time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = make(map[netip.Addr]time.Duration)
+	}
+	srtt, ok := s.m[addr]
+	if !ok {
+		if len(s.m) >= maxAddrRTTs {
+			for a := range s.m {
+				delete(s.m, a)
+				break
+			}
+		}
+		s.m[addr] = rtt
+		return
+	}
+	s.m[addr] = srtt + (rtt-srtt)/8
+}
+
+// get ...
+func (s *addrRTTs) get(addr netip.Addr) (time.Duration, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rtt, ok := s.m[addr]
+	return rtt, ok
+}
+
+// ReportRTT adds a round trip time measured to ip, e.g. the time to connect or the first byte time,
+// to the smoothed RTT of the address. Addresses of hosts with a cap on answers are ranked by it,
+// see Policy.MaxAnswers. DialContext reports connect times itself
+func (r *Resolver) ReportRTT(ip string, rtt time.Duration) {
+	addr, ok := parseIPAddr(ip)
+	if !ok || rtt <= 0 {
+		return
+	}
+	r.addrRTTs.observe(addr, rtt)
+}
+
+// answerCap returns the max number of addresses of each family kept, zero means no limit
+func (o *hostOptions) answerCap() int {
+	if o.policy != nil && o.policy.MaxAnswers > 0 {
+		return o.policy.MaxAnswers
+	}
+	return o.maxAnswers
+}
+
+// rank orders ipList by the preferred prefixes of the policy, then by smoothed RTTs ascending.
+// Addresses with no RTTs go after measured ones in the upstream order
+func (o *hostOptions) rank(ipList []net.IP) []net.IP {
+	type scored struct {
+		ip       net.IP
+		pref     int
+		rtt      time.Duration
+		measured bool
+	}
+	var prefixes []netip.Prefix
+	if o.policy != nil {
+		prefixes = o.policy.PreferPrefixes
+	}
+
+	list := make([]scored, len(ipList))
+	for i, ip := range ipList {
+		addr := ipKey(ip)
+		s := scored{ip: ip, pref: len(prefixes)}
+		for j, prefix := range prefixes {
+			if prefix.Contains(addr) {
+				s.pref = j
+				break
+			}
+		}
+		if o.rtts != nil {
+			s.rtt, s.measured = o.rtts.get(addr)
+		}
+		list[i] = s
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		switch {
+		case a.pref != b.pref:
+			return a.pref < b.pref
+		case a.measured != b.measured:
+			return a.measured
+		}
+		return a.rtt < b.rtt
+	})
+
+	ret := make([]net.IP, len(list))
+	for i, s := range list {
+		ret[i] = s.ip
+	}
+	return ret
+}
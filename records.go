@@ -0,0 +1,121 @@
+package resolver
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// recordKey ...
+type recordKey struct {
+	qname string
+	qtype uint16
+}
+
+// newRecordKey ...
+func newRecordKey(qname string, qtype uint16) recordKey {
+	return recordKey{
+		qname: dns.Fqdn(strings.ToLower(qname)),
+		qtype: qtype,
+	}
+}
+
+// String ...
+func (k recordKey) String() string {
+	return k.qname + " " + dns.TypeToString[k.qtype]
+}
+
+// record - a maintained RRset of arbitrary type, it is kept by a host which is not stored
+// in the resolver and resolves no addresses, so the scheduler refreshes it per its TTL
+// like RRsets of hosts per Policy.Qtypes
+type record struct {
+	key recordKey
+	h   *host
+}
+
+// newRecord creates a record and schedules its first resolution
+func (r *Resolver) newRecord(key recordKey) *record {
+	opts := hostOptions{
+		rrsetType: key.qtype,
+		clock:     r.clock,
+		scheduler: r.scheduler,
+	}
+	rec := &record{
+		key: key,
+		h:   newHost(r.tag, strings.TrimSuffix(key.qname, "."), true, opts, r.dnsClient, r.logger),
+	}
+	rec.h.start()
+	return rec
+}
+
+// getRRs returns the RRset and where it was obtained from waiting for its first resolution
+func (rec *record) getRRs() ([]dns.RR, Source) {
+	rec.h.ready.Wait()
+	rrset, _ := rec.h.rrsets.get(rec.key.qtype)
+	return rrset.rrs, rrset.src
+}
+
+// stop ...
+func (rec *record) stop() {
+	rec.h.stop()
+}
+
+// Maintain adds an RRset of type qtype (dns.TypeTXT, dns.TypeSRV etc) for qname to maintaining,
+// the RRset is refreshed per its TTL using nameservers passed to WithNameservers
+func (r *Resolver) Maintain(qname string, qtype uint16) {
+	key := newRecordKey(qname, qtype)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Stopped() {
+		return
+	}
+	if _, ok := r.records[key]; !ok {
+		r.records[key] = r.newRecord(key)
+	}
+}
+
+// Unmaintain deletes an RRset of type qtype for qname from maintaining
+func (r *Resolver) Unmaintain(qname string, qtype uint16) {
+	key := newRecordKey(qname, qtype)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rec, ok := r.records[key]; ok {
+		rec.stop()
+		delete(r.records, key)
+	}
+}
+
+// GetRecords returns a maintained RRset of type qtype for qname, nil if it is not maintained.
+// The returned records must not be modified
+func (r *Resolver) GetRecords(qname string, qtype uint16) []dns.RR {
+	rrs, _ := r.getRecords(qname, qtype)
+	return rrs
+}
+
+// getRecords returns an RRset maintained by Maintain and where it was obtained from
+func (r *Resolver) getRecords(qname string, qtype uint16) ([]dns.RR, Source) {
+	r.mu.RLock()
+	rec := r.records[newRecordKey(qname, qtype)]
+	r.mu.RUnlock()
+
+	if rec == nil {
+		return nil, Source{}
+	}
+	return rec.getRRs()
+}
+
+// RecordSource returns where an RRset of type qtype for qname maintained by Maintain was obtained from,
+// ok is false if the RRset is not maintained
+func (r *Resolver) RecordSource(qname string, qtype uint16) (src Source, ok bool) {
+	r.mu.RLock()
+	rec := r.records[newRecordKey(qname, qtype)]
+	r.mu.RUnlock()
+
+	if rec == nil {
+		return Source{}, false
+	}
+	_, src = rec.getRRs()
+	return src, true
+}
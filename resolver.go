@@ -1,13 +1,18 @@
 package resolver
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/miekg/dns"
 	logApi "github.com/ndmsystems/go/api/log"
 )
 
@@ -26,136 +31,421 @@ type Resolver struct {
 	// hosts - a map with maintained hosts
 	hosts map[string]*host
 
+	// records - a map with maintained RRsets of arbitrary types
+	records map[recordKey]*record
+
 	// dnsClient - a network client that can use a list of nameservers to lookup hosts and retrieve its ip addresses with ttl
 	dnsClient *dnsClient
 
 	// logger - a logger which used in this package
 	logger logApi.Logger
 
+	// policies - pattern policies applied to hosts when they are created
+	policies policies
+
+	// ttlOverrides - pattern refresh intervals applied to hosts when they are created
+	ttlOverrides ttlOverrides
+
+	// blocklist - patterns of hosts which are not resolved
+	blocklist patternList
+
+	// views - views of clients in the server modes
+	views views
+
+	// zones - zones mirrored by MirrorZone
+	zones mirroredZones
+
+	// memLimit - the limit of memory used by the cache set by WithMemoryLimit, memCh signals it to be checked
+	memLimit int64
+	memCh    chan struct{}
+	memOnce  sync.Once
+
+	// maxAnswers - the max number of addresses of each family kept per host
+	maxAnswers int
+
+	// hardExpiryFactor - addresses are unusable this number of TTLs after they were resolved, see WithHardExpiry
+	hardExpiryFactor int
+
+	// holdDown - the number of refreshes an address must be absent from before it is removed, see WithHoldDown
+	holdDown int
+
+	// addrRTTs - smoothed RTTs of addresses reported by ReportRTT and DialContext
+	addrRTTs addrRTTs
+
+	// dialAutoAdd - the number of dials after which a host becomes explicitly added, zero means never
+	dialAutoAdd uint64
+
+	// exclusions - addresses excluded from hosts by ExcludeIP
+	exclusions exclusions
+
+	// rewrites - rewrite rules added by AddRewrite
+	rewrites rewrites
+
+	// ipsetHooks - hooks added by WithIPSetHook
+	ipsetHooks ipsetHooks
+
+	// historySize - the number of address changes kept per host, see WithHistory
+	historySize int32
+
+	// store - a storage of query logs and address changes set by WithHistoryStore, guarded by mu
+	store HistoryStore
+
+	// cnames - hosts by names of their CNAME chains
+	cnames cnameIndex
+
+	// federation - peers exchanging cache updates set by WithFederation, guarded by mu
+	federation *federation
+
+	// priming - re-resolution of hosts on nameserver changes set by WithNameserverPriming, guarded by mu
+	priming *priming
+
+	// classes - priority classes of hosts set by SetHostClass, guarded by mu
+	classes map[string]HostClass
+
+	// gcPolicy - the rule deleting hosts added non-explicitly, guarded by mu
+	gcPolicy GCPolicy
+
+	// hasWildcards - set to 1 when a static wildcard host is added
+	hasWildcards int32
+
+	// noAutoAdd - set to 1 when lookups do not add hosts which are not maintained, see WithAutoAdd
+	noAutoAdd int32
+
+	// fixedRotation - set to 1 when rotations of hosts start at the first address, see WithRandomRotationStart
+	fixedRotation int32
+
+	// metricsHostsLimit - the max number of hosts exposed with their own metrics label
+	metricsHostsLimit int
+
+	// stats - lookup counters
+	stats stats
+
+	// clientCfg - settings shared by all dns clients
+	clientCfg *clientConfig
+
+	// rootHints - root servers configured by WithRootHints
+	rootHints rootHints
+
+	// trustAnchors - DNSSEC trust anchors configured by WithTrustAnchors
+	trustAnchors trustAnchors
+
+	// cacheHook - a function called on every cache event, may be nil, guarded by mu
+	cacheHook func(hostName string, ev CacheEvent)
+
+	// clock - a source of time
+	clock Clock
+
+	// scheduler - refreshes addresses of hosts
+	scheduler *scheduler
+
 	// stopCh ...
-	stopCh chan struct{}
+	stopCh   chan struct{}
+	stopOnce sync.Once
 }
 
-// New returns ResolverService instance
+// ErrStopped - the resolver is stopped
+var ErrStopped = errors.New("resolver is stopped")
+
+// New returns ResolverService instance, logger may be nil to disable logging
 func New(tag string, logger logApi.Logger) *Resolver {
+	return NewWithClock(tag, logger, realClock{})
+}
+
+// NewWithClock returns ResolverService instance using clock as a source of time
+func NewWithClock(tag string, logger logApi.Logger, clock Clock) *Resolver {
+	clientCfg := &clientConfig{
+		tag:   tag,
+		clock: clock,
+		qlog:  &queryLog{clock: clock},
+		wire:  newWireCapture(clock),
+
+		sysResolver: &net.Resolver{},
+	}
+	clientCfg.qlog.privacy = &clientCfg.privacy
 	r := &Resolver{
 		tag:       tag,
 		hosts:     make(map[string]*host),
-		dnsClient: newDnsClient(logger),
+		records:   make(map[recordKey]*record),
+		dnsClient: newDnsClient(logger, clientCfg),
 		logger:    logger,
+		clientCfg: clientCfg,
+		clock:     clock,
 		stopCh:    make(chan struct{}),
+		memCh:     make(chan struct{}, 1),
 	}
+	r.trustAnchors.clock = clock
+	clientCfg.anchors = &r.trustAnchors
+	r.scheduler = newScheduler(tag, clock, r.stopCh)
 
-	go r.oldHostsDeleteLoop()
+	r.scheduler.goroutines.spawn("gc", "", r.oldHostsDeleteLoop)
 
 	return r
 }
 
-// WithNameservers - sets nameservers to resolve hosts
+// WithNameservers - sets nameservers to resolve hosts, as IP addresses or ip:port pairs.
+// Explicitly added hosts are re-resolved against the new nameservers if WithNameserverPriming is set
 func (r *Resolver) WithNameservers(nameServers ...string) *Resolver {
+	old := r.dnsClient.getNameServers()
 	r.dnsClient.setNameServers(nameServers)
+	r.primeOnChange(old, r.dnsClient.getNameServers())
 	return r
 }
 
-// AddHost adds a host to maintaining
-func (r *Resolver) AddHost(hostName string) {
-	r.mu.RLock()
-	_, ok := r.hosts[hostName]
-	r.mu.RUnlock()
+// WithRetriesPerNameserver - sets the number of extra attempts to the same nameserver before
+// trying the next one, the delay between attempts starts at 100ms and doubles each time.
+// Attempts stop when the deadline of the lookup is exceeded
+func (r *Resolver) WithRetriesPerNameserver(n int) *Resolver {
+	atomic.StoreInt32(&r.clientCfg.retries, int32(n))
+	return r
+}
 
-	if ok {
-		return
+// WithPolicy - sets a policy for all hosts matching the glob pattern (e.g. "*.cdn.example.com").
+// Policies are checked in the order they were added, the first matching one is applied
+// to hosts created after this call. A policy with a malformed pattern is logged and not added
+func (r *Resolver) WithPolicy(pattern string, policy Policy) *Resolver {
+	if len(r.validPatterns(pattern)) == 0 {
+		return r
+	}
+	e := &policyEntry{
+		pattern: pattern,
+		policy:  policy,
+	}
+	if len(policy.Nameservers) > 0 {
+		e.dnsClient = newDnsClient(r.logger, r.clientCfg)
+		e.dnsClient.setNameServers(policy.Nameservers)
 	}
+	r.policies.add(e)
+	return r
+}
+
+// WithTTLOverride - forces the refresh interval of hosts matching the glob pattern to ttl
+// regardless of the upstream TTL and policies. Overrides are checked in the order they were added
+// and apply to hosts created after this call, malformed patterns are logged and ignored
+func (r *Resolver) WithTTLOverride(pattern string, ttl time.Duration) *Resolver {
+	if len(r.validPatterns(pattern)) == 0 {
+		return r
+	}
+	r.ttlOverrides.add(pattern, ttl)
+	return r
+}
+
+// WithBlocklist - blocks hosts matching the glob patterns, blocked hosts are not resolved:
+// GetNextIP*, GetIPs* and DialContext return no addresses, LookupIP and the server modes answer NXDOMAIN.
+// Malformed patterns are logged and skipped
+func (r *Resolver) WithBlocklist(patterns ...string) *Resolver {
+	r.blocklist.add(r.validPatterns(patterns...)...)
+	return r
+}
 
+// WithMaxAnswersPerHost - limits the number of addresses of each family kept per host to n
+// after policy filtering, addresses ranked last by RTT are dropped, see ReportRTT.
+// Policy.MaxAnswers overrides it. Applies to hosts created after this call
+func (r *Resolver) WithMaxAnswersPerHost(n int) *Resolver {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if _, ok := r.hosts[hostName]; !ok {
-		r.hosts[hostName] = newHost(r.tag, hostName, true, r.dnsClient, r.logger)
+	r.maxAnswers = n
+	return r
+}
+
+// WithAutoAdd - sets whether lookups of hosts which are not maintained add them to maintaining
+// non-explicitly, enabled by default. When disabled such lookups return empty addresses
+// like with the WithNoAutoAdd option, the server modes resolve them without caching
+func (r *Resolver) WithAutoAdd(enabled bool) *Resolver {
+	var flag int32
+	if !enabled {
+		flag = 1
+	}
+	atomic.StoreInt32(&r.noAutoAdd, flag)
+	return r
+}
+
+// AddHost adds a host to maintaining, a host added non-explicitly before becomes explicit.
+// Host names passed to AddHost*, DelHost, GetNextIP* and GetIP* may be host:port, the port is ignored
+func (r *Resolver) AddHost(hostName string) {
+	hostName = hostOnly(hostName)
+	if h, loaded := r.loadOrStoreHost(hostName, true); loaded {
+		h.promote()
+	}
+}
+
+// AddHostAsync adds a host to maintaining like AddHost and calls onReady with the error
+// of the first resolution of the host when it is done, or ErrStopped if the resolver is stopped.
+// onReady is called on its own goroutine, also for hosts resolved before
+func (r *Resolver) AddHostAsync(hostName string, onReady func(err error)) {
+	hostName = hostOnly(hostName)
+	h, loaded := r.loadOrStoreHost(hostName, true)
+	if loaded {
+		h.promote()
+	}
+
+	r.scheduler.goroutines.spawn("wait-ready", r.clientCfg.privacy.redact(hostName), func() {
+		defer r.scheduler.trackWaiter()()
+		if h == nil {
+			onReady(ErrStopped)
+			return
+		}
+		h.ready.Wait()
+		onReady(h.getErr())
+	})
+}
+
+// AddHostAwait adds a host to maintaining like AddHost and waits for its first resolution, returns
+// IPv4 and IPv6 addresses of the host or an error of type *net.DNSError if it has no addresses:
+// the error of the resolution, a not found error or the error of ctx
+func (r *Resolver) AddHostAwait(ctx context.Context, hostName string) ([]net.IP, []net.IP, error) {
+	hostName = hostOnly(hostName)
+	h, loaded := r.loadOrStoreHost(hostName, true)
+	if h == nil {
+		return nil, nil, &net.DNSError{Err: ErrStopped.Error(), Name: hostName}
+	}
+	if loaded {
+		h.promote()
+	}
+	if _, err := h.waitReady(ctx); err != nil {
+		return nil, nil, &net.DNSError{Err: err.Error(), Name: hostName, IsTimeout: isTimeout(err), IsTemporary: true}
+	}
+	h.updLastTime()
+
+	ip4, ip6 := h.getIPs()
+	if len(ip4) == 0 && len(ip6) == 0 {
+		if err := h.getErr(); err != nil {
+			return nil, nil, &net.DNSError{Err: err.Error(), Name: hostName, IsTimeout: isTimeout(err), IsTemporary: true}
+		}
+		return nil, nil, &net.DNSError{Err: errNoSuchHost, Name: hostName, IsNotFound: true}
 	}
+	return ip4, ip6, nil
 }
 
 // DelHost deletes a host with name hostName from maintaining
 func (r *Resolver) DelHost(hostName string) {
-	r.delHosts([]string{hostName})
+	r.delHosts([]string{hostOnly(hostName)})
 }
 
-// Stop - stops maintaining for all hosts
+// Stop - stops maintaining for all hosts and views, hosts are not added after the resolver is stopped.
+// Stop may be called more than once
 func (r *Resolver) Stop() {
-	close(r.stopCh)
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+		r.views.stop()
+	})
+}
+
+// Stopped returns true if the resolver is stopped
+func (r *Resolver) Stopped() bool {
+	select {
+	case <-r.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// Done returns a channel closed when the resolver is stopped
+func (r *Resolver) Done() <-chan struct{} {
+	return r.stopCh
 }
 
 // GetNextIP returns next IPv4 for host with name hostName
-func (r *Resolver) GetNextIP(hostName string) string {
-	ip, _ := r.GetNextIPWithIdx(hostName)
+func (r *Resolver) GetNextIP(hostName string, opts ...QueryOption) string {
+	ip, _ := r.GetNextIPWithIdx(hostName, opts...)
 	return ip
 }
 
 // GetNextIPWithIdx returns next IPv4 and index for host with name hostName
-func (r *Resolver) GetNextIPWithIdx(hostName string) (string, int) {
-	r.mu.RLock()
-	h, ok := r.hosts[hostName]
-	r.mu.RUnlock()
-
-	if ok {
-		ip, idx := h.getNextIP4WithIndex()
-		return ipStrIdx(ip, idx)
-	}
-
-	r.mu.Lock()
-	if h, ok = r.hosts[hostName]; !ok {
-		h = newHost(r.tag, hostName, false, r.dnsClient, r.logger)
-		r.hosts[hostName] = h
-	}
-	r.mu.Unlock()
-
-	ip, idx := h.getNextIP4WithIndex()
-	return ipStrIdx(ip, idx)
+func (r *Resolver) GetNextIPWithIdx(hostName string, opts ...QueryOption) (string, int) {
+	return r.getNextIPWithIdx(hostName, FamilyV4, newQueryOptions(opts))
 }
 
 // GetNextIP6 returns next IPv6 for host with name hostName
-func (r *Resolver) GetNextIP6(hostName string) string {
-	ip, _ := r.GetNextIP6WithIdx(hostName)
+func (r *Resolver) GetNextIP6(hostName string, opts ...QueryOption) string {
+	ip, _ := r.GetNextIP6WithIdx(hostName, opts...)
 	return ip
 }
 
 // GetNextIP6WithIdx returns next IPv6 and index for host with name hostName
-func (r *Resolver) GetNextIP6WithIdx(hostName string) (string, int) {
-	r.mu.RLock()
-	h, ok := r.hosts[hostName]
-	r.mu.RUnlock()
+func (r *Resolver) GetNextIP6WithIdx(hostName string, opts ...QueryOption) (string, int) {
+	return r.getNextIPWithIdx(hostName, FamilyV6, newQueryOptions(opts))
+}
 
-	if ok {
-		ip, idx := h.getNextIP6WithIndex()
-		return ipStrIdx(ip, idx)
-	}
+// GetNextIPIfPresent returns next IPv4 for host with name hostName if the host is maintained,
+// the host is never added to maintaining. ok is false if the host is not maintained or has no addresses
+func (r *Resolver) GetNextIPIfPresent(hostName string, opts ...QueryOption) (ip string, ok bool) {
+	o := newQueryOptions(opts)
+	o.noAutoAdd = true
+	ip, _ = r.getNextIPWithIdx(hostName, FamilyV4, o)
+	return ip, ip != ""
+}
 
-	r.mu.Lock()
-	if h, ok = r.hosts[hostName]; !ok {
-		h = newHost(r.tag, hostName, false, r.dnsClient, r.logger)
-		r.hosts[hostName] = h
+// GetIPs returns a list of IPv4 and IPv6, IPv6 zones are dropped, see GetIPAddrs.
+// The host is not added to maintaining
+func (r *Resolver) GetIPs(hostName string) ([]net.IP, []net.IP) {
+	res := r.lookupMaintained(hostName)
+	return res.ip4, res.ip6
+}
+
+// GetIPsWithTTL returns a list of IPv4 and IPv6 like GetIPs and the time left until each list expires,
+// decremented since the addresses were fetched, so callers caching them do not hold them past their TTLs
+func (r *Resolver) GetIPsWithTTL(hostName string) (ip4, ip6 []net.IP, ttl4, ttl6 time.Duration) {
+	res := r.lookupMaintained(hostName)
+	if len(res.ip4) == 0 && len(res.ip6) == 0 {
+		return nil, nil, 0, 0
 	}
-	r.mu.Unlock()
+	return res.ip4, res.ip6, time.Duration(res.ttl4) * time.Second, time.Duration(res.ttl6) * time.Second
+}
 
-	ip, idx := h.getNextIP6WithIndex()
-	return ipStrIdx(ip, idx)
+// Version returns a counter incremented whenever the set of addresses of host with name hostName changes,
+// zero if the host is not maintained or has never had addresses
+func (r *Resolver) Version(hostName string) uint64 {
+	r.mu.RLock()
+	h := r.hosts[hostOnly(hostName)]
+	r.mu.RUnlock()
+
+	if h == nil {
+		return 0
+	}
+	return h.getVersion()
 }
 
-// GetIPs returns a list of IPv4 and IPv6
-func (r *Resolver) GetIPs(hostName string) ([]net.IP, []net.IP) {
+// LastError returns the error of the last resolution of host with name hostName, nil on success
+// or if the host is not maintained
+func (r *Resolver) LastError(hostName string) error {
 	r.mu.RLock()
-	h := r.hosts[hostName]
+	h := r.hosts[hostOnly(hostName)]
 	r.mu.RUnlock()
 
 	if h == nil {
-		return nil, nil
+		return nil
 	}
+	return h.getErr()
+}
 
-	return h.getIPs()
+// GetIPAddrs returns a list of IPv4 and IPv6 addresses with IPv6 zones
+func (r *Resolver) GetIPAddrs(hostName string) ([]net.IPAddr, []net.IPAddr) {
+	res := r.lookupMaintained(hostName)
+	switch {
+	case res.host != nil:
+		return res.host.getIPAddrs()
+	case res.rewrite != nil:
+		return res.rewrite.ip4.getAddrList(), res.rewrite.ip6.getAddrList()
+	}
+	return ipAddrs(res.ip4), ipAddrs(res.ip6)
 }
 
-// GetIPsStr returns a string list of IPv4 and IPv6
+// ipAddrs ...
+func ipAddrs(ipList []net.IP) []net.IPAddr {
+	if len(ipList) == 0 {
+		return nil
+	}
+	ret := make([]net.IPAddr, len(ipList))
+	for i, ip := range ipList {
+		ret[i].IP = ip
+	}
+	return ret
+}
+
+// GetIPsStr returns a string list of IPv4 and IPv6, IPv6 addresses include zones (fe80::1%eth0)
 func (r *Resolver) GetIPsStr(hostName string) ([]string, []string) {
-	ip4, ip6 := r.GetIPs(hostName)
+	ip4, ip6 := r.GetIPAddrs(hostName)
 	var ip4Str, ip6Str []string
 	for _, ip := range ip4 {
 		ip4Str = append(ip4Str, ip.String())
@@ -168,6 +458,9 @@ func (r *Resolver) GetIPsStr(hostName string) ([]string, []string) {
 
 // LookupSRV makes LookupSRV request to one of nameserver passed to WithNameservers
 func (r *Resolver) LookupSRV(service, proto, name string) (string, []*net.SRV, error) {
+	if r.Stopped() {
+		return "", nil, ErrStopped
+	}
 	return r.dnsClient.lookupSRV(service, proto, name)
 }
 
@@ -176,66 +469,225 @@ func (r *Resolver) Dump(w io.Writer) {
 	r.DumpPrefix(w, "")
 }
 
-// DumpPrefix dumps with prefix into writer all hosts with theirs ips
+// DumpPrefix dumps with prefix into writer all hosts with theirs ips, remaining ttls, expiration times
+// and sources
 func (r *Resolver) DumpPrefix(w io.Writer, prefix string) {
 	r.mu.RLock()
-	defer r.mu.RUnlock()
-
+	hostsMap := make(map[string]*host, len(r.hosts))
 	hosts := make([]string, 0, len(r.hosts))
-	for host := range r.hosts {
-		hosts = append(hosts, host)
+	for hostName, h := range r.hosts {
+		hostsMap[hostName] = h
+		hosts = append(hosts, hostName)
 	}
+	r.mu.RUnlock()
 	sort.Strings(hosts)
 
-	for _, hostName := range hosts {
-		ip4, ip6 := r.GetIPsStr(hostName)
+	for _, name := range hosts {
+		h := hostsMap[name]
+		src4, src6 := h.sources.get()
+		ip4, ip6 := r.GetIPsStr(name)
 		sort.Strings(ip4)
 		sort.Strings(ip6)
+		hostName := r.clientCfg.privacy.redact(name)
 
 		for idx, ip := range ip4 {
 			fmt.Fprintf(w, "%sresolver.v4.%s.%d: %s\n", prefix, hostName, idx, ip)
 		}
+		if expire := h.expiry(FamilyV4); !expire.IsZero() {
+			fmt.Fprintf(w, "%sresolver.v4.%s.ttl: %d\n", prefix, hostName, h.remainingTtl(FamilyV4))
+			fmt.Fprintf(w, "%sresolver.v4.%s.expires: %s\n", prefix, hostName, expire.Format(time.RFC3339))
+			fmt.Fprintf(w, "%sresolver.v4.%s.source: %s\n", prefix, hostName, src4)
+		}
 		for idx, ip := range ip6 {
 			fmt.Fprintf(w, "%sresolver.v6.%s.%d: %s\n", prefix, hostName, idx, ip)
 		}
+		if expire := h.expiry(FamilyV6); !expire.IsZero() {
+			fmt.Fprintf(w, "%sresolver.v6.%s.ttl: %d\n", prefix, hostName, h.remainingTtl(FamilyV6))
+			fmt.Fprintf(w, "%sresolver.v6.%s.expires: %s\n", prefix, hostName, expire.Format(time.RFC3339))
+			fmt.Fprintf(w, "%sresolver.v6.%s.source: %s\n", prefix, hostName, src6)
+		}
+		h.rrsets.dump(w, prefix, hostName, &r.clientCfg.privacy, h.clock.Now())
 	}
 }
 
-// oldHostsDeleteLoop runs a loop that deletes old hosts that were added non-explicitly
+// oldHostsDeleteLoop runs a loop that deletes hosts added non-explicitly per the GC policy
 func (r *Resolver) oldHostsDeleteLoop() {
-	ticker := time.NewTicker(time.Minute)
+	ticker := r.clock.NewTicker(time.Minute)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-r.stopCh:
-			r.logger.Info().Println(r.tag, "Stop resolving hosts")
+			logInfo(r.logger, r.tag, "Stop resolving hosts")
 			r.emptyHosts()
 			return
-		case <-ticker.C:
-			hostsToDel := make([]string, 0)
-			r.mu.RLock()
-			for hostName := range r.hosts {
-				if r.hosts[hostName].isOld() && !r.hosts[hostName].isExplicitlyAdded() && !r.hosts[hostName].static {
-					hostsToDel = append(hostsToDel, hostName)
-				}
+		case <-ticker.C():
+			if r.InOutage() {
+				continue
 			}
-			r.mu.RUnlock()
+			r.deleteOldHosts()
+			r.checkMemory()
+		}
+	}
+}
 
-			if len(hostsToDel) > 0 {
-				r.delHosts(hostsToDel)
-				r.logger.Info().Println(r.tag, "Deleted old hosts:", hostsToDel)
-			}
+// deleteOldHosts deletes hosts added non-explicitly which are garbage per the GC policy
+func (r *Resolver) deleteOldHosts() {
+	hostsToDel := make([]string, 0)
+	r.mu.Lock()
+	now := r.clock.Now()
+	for hostName, h := range r.hosts {
+		if !h.isExplicitlyAdded() && !h.static && r.gcPolicy.isGarbage(h, now) {
+			delete(r.hosts, hostName)
+			h.stop()
+			hostsToDel = append(hostsToDel, hostName)
 		}
 	}
+	r.mu.Unlock()
+
+	if len(hostsToDel) > 0 {
+		logInfo(r.logger, r.tag, "Deleted old hosts:", r.clientCfg.privacy.redactList(hostsToDel))
+	}
+}
+
+// getNextIPWithIdx returns next IP of family and its index applying query options, the host is passed
+// through the lookup pipeline and addresses of its host or rewrite rule are rotated. Names which are
+// not maintained and are not added per the options are not resolved
+func (r *Resolver) getNextIPWithIdx(hostName string, family Family, o queryOptions) (string, int) {
+	qtype := dns.TypeA
+	if family == FamilyV6 {
+		qtype = dns.TypeAAAA
+	}
+	l := apiLookup(hostName, qtype)
+	l.noAutoAdd, l.cacheOnly, l.sourceOnly = o.noAutoAdd, true, true
+
+	res := r.resolve(context.Background(), l)
+	switch {
+	case res.rewrite != nil:
+		return ipStrIdx(res.rewrite.getNextIPWithIndex(family, o, r.clock.Now()))
+	case res.host != nil:
+		if ip, idx := res.host.nextIP(family, o); ip != "" {
+			return ip, idx
+		}
+		return res.host.emptyIP(family, o)
+	}
+
+	// addresses of mirrored zones are not rotated
+	first, second := res.ip4, res.ip6
+	if (o.family == FamilyAll && family == FamilyV6) || o.family == FamilyV6 {
+		first, second = second, first
+	}
+	if len(first) > 0 {
+		return first[0].String(), 0
+	}
+	if o.family != FamilyAll && len(second) > 0 {
+		return second[0].String(), 0
+	}
+	return "", -1
+}
+
+// getHost returns a maintained host creating it non-explicitly if it does not exist and autoAdd is set
+// and not disabled by WithAutoAdd, returns nil if the host does not exist and is not created
+// or the resolver is stopped. Blocked names are answered by the lookup pipeline before the cache.
+// Returns the cache event of the lookup
+func (r *Resolver) getHost(hostName string, autoAdd bool) (*host, CacheEvent) {
+	if r.Stopped() {
+		return nil, CacheHit
+	}
+
+	r.mu.RLock()
+	h, ok := r.staticHost(hostName)
+	hook := r.cacheHook
+	r.mu.RUnlock()
+
+	if ok {
+		return h, r.stats.countAccess(hostName, h, hook)
+	}
+	if !autoAdd || atomic.LoadInt32(&r.noAutoAdd) == 1 {
+		return nil, CacheHit
+	}
+
+	h, loaded := r.loadOrStoreHost(hostName, false)
+	if h == nil {
+		return nil, CacheHit
+	}
+	if loaded {
+		return h, r.stats.countAccess(hostName, h, hook)
+	}
+	r.stats.countCreated(hostName, hook)
+	r.stats.countAccess(hostName, h, hook)
+	r.checkMemory()
+	return h, CacheHostCreated
+}
+
+// loadOrStoreHost returns the host maintained for hostName if there is one,
+// otherwise it stores a new host and starts maintaining it, loaded reports whether the host existed.
+// Only the stored host is ever started, so concurrent callers share one host and its initial resolution.
+// Returns nil if the resolver is stopped
+func (r *Resolver) loadOrStoreHost(hostName string, eaFlag bool) (h *host, loaded bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if h, ok := r.hosts[hostName]; ok {
+		return h, true
+	}
+	if r.Stopped() {
+		return nil, false
+	}
+	hostName = internName(hostName)
+	h = r.newHost(hostName, eaFlag)
+	r.hosts[hostName] = h
+	h.start()
+	return h, false
+}
+
+// newHost creates a host applying the first policy matching hostName
+func (r *Resolver) newHost(hostName string, eaFlag bool) *host {
+	dnsClient, policy := r.hostClient(hostName)
+	opts := hostOptions{
+		policy:           policy,
+		anchors:          &r.trustAnchors,
+		ttlOverride:      r.ttlOverrides.match(hostName),
+		maxAnswers:       r.maxAnswers,
+		holdDown:         r.holdDown,
+		hardExpiryFactor: r.hardExpiryFactor,
+		rtts:             &r.addrRTTs,
+		clock:            r.clock,
+		scheduler:        r.scheduler,
+		exclusions:       &r.exclusions,
+		ipsetFuncs:       r.ipsetHooks.match(hostName),
+		historySize:      int(atomic.LoadInt32(&r.historySize)),
+		store:            r.store,
+		peers:            r.federation,
+		cnames:           &r.cnames,
+	}
+	h := newHost(r.tag, hostName, eaFlag, opts, dnsClient, r.logger)
+	h.setClass(r.hostClass(hostName, policy))
+	r.randomizeRotation(h)
+	return h
+}
+
+// hostClient returns the first policy matching hostName and a dns client to resolve the host with
+func (r *Resolver) hostClient(hostName string) (*dnsClient, *Policy) {
+	e := r.policies.match(hostName)
+	if e == nil {
+		return r.dnsClient, nil
+	}
+	if e.dnsClient != nil {
+		return e.dnsClient, &e.policy
+	}
+	return r.dnsClient, &e.policy
 }
 
 // delHosts deletes hosts from maintaining
 func (r *Resolver) delHosts(hosts []string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	for _, h := range hosts {
-		delete(r.hosts, h)
+	for _, hostName := range hosts {
+		if h, ok := r.hosts[hostName]; ok {
+			delete(r.hosts, hostName)
+			h.stop()
+		}
 	}
 }
 
@@ -247,22 +699,38 @@ func (r *Resolver) emptyHosts() {
 		r.hosts[hostName].stop()
 	}
 	r.hosts = make(map[string]*host)
+	for _, rec := range r.records {
+		rec.stop()
+	}
+	r.records = make(map[recordKey]*record)
 }
 
-func ipStrIdx(ip net.IP, idx int) (string, int) {
-	if ip == nil {
+func ipStrIdx(ip netip.Addr, idx int) (string, int) {
+	if !ip.IsValid() {
 		return "", -1
 	}
 	return ip.String(), idx
 }
 
+// UpdateHostsFromMaping sets static hosts with addresses of mapping["ip4"] and mapping["ip6"]
+// replacing maintained hosts with the same names. A name starting with "*." sets a wildcard host
+// answering for all subdomains, e.g. "*.lab.local" for "a.lab.local" and "a.b.lab.local",
+// the most specific wildcard is used and hosts set by their own names take precedence
 func (r *Resolver) UpdateHostsFromMaping(mapping map[string]map[string][]string) {
+	if r.Stopped() {
+		return
+	}
 	for k, v := range mapping {
 		r.mu.Lock()
-		if _, ok := r.hosts[k]; ok {
+		if h, ok := r.hosts[k]; ok {
 			delete(r.hosts, k)
+			h.stop()
+		}
+		k = internName(k)
+		r.hosts[k] = newStaticHost(r.tag, k, true, v, r.clock, r.logger)
+		if isWildcard(k) {
+			atomic.StoreInt32(&r.hasWildcards, 1)
 		}
-		r.hosts[k] = newStaticHost(r.tag, k, true, v, r.logger)
 		r.mu.Unlock()
 	}
 
@@ -0,0 +1,218 @@
+// Package resolvertest provides a fake upstream DNS server for integration tests of the resolver
+package resolvertest
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Server - a DNS server on a random local port answering from programmable zones,
+// with injectable delays, truncation and failures
+type Server struct {
+	// Addr - the ip:port the server listens at over both UDP and TCP,
+	// pass it to resolver.WithNameservers
+	Addr string
+
+	mu       sync.RWMutex
+	records  map[string][]dns.RR
+	delay    time.Duration
+	truncate bool
+	rcode    int
+	drop     bool
+
+	queries uint64
+
+	udp *dns.Server
+	tcp *dns.Server
+}
+
+// NewServer starts a server at 127.0.0.1 on a random port
+func NewServer() (*Server, error) {
+	s := &Server{
+		records: make(map[string][]dns.RR),
+		rcode:   dns.RcodeSuccess,
+	}
+
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = s.listen(); err == nil {
+			return s, nil
+		}
+	}
+	return nil, err
+}
+
+// listen starts UDP and TCP servers on the same random port
+func (s *Server) listen() error {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		return err
+	}
+
+	s.Addr = pc.LocalAddr().String()
+	s.udp = &dns.Server{PacketConn: pc, Handler: s}
+	s.tcp = &dns.Server{Listener: l, Handler: s}
+
+	started := make(chan struct{}, 2)
+	s.udp.NotifyStartedFunc = func() { started <- struct{}{} }
+	s.tcp.NotifyStartedFunc = func() { started <- struct{}{} }
+	go s.udp.ActivateAndServe()
+	go s.tcp.ActivateAndServe()
+	<-started
+	<-started
+
+	return nil
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	errUDP := s.udp.Shutdown()
+	if err := s.tcp.Shutdown(); err != nil {
+		return err
+	}
+	return errUDP
+}
+
+// AddRecords adds records given in zone file format, one per line
+func (s *Server) AddRecords(zone string) error {
+	zp := dns.NewZoneParser(strings.NewReader(zone), ".", "")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		name := strings.ToLower(rr.Header().Name)
+		s.records[name] = append(s.records[name], rr)
+	}
+	return zp.Err()
+}
+
+// SetRecords replaces all records of qtype for name with rrs
+func (s *Server) SetRecords(name string, qtype uint16, rrs ...dns.RR) {
+	name = dns.Fqdn(strings.ToLower(name))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.records[name][:0]
+	for _, rr := range s.records[name] {
+		if rr.Header().Rrtype != qtype {
+			kept = append(kept, rr)
+		}
+	}
+	s.records[name] = append(kept, rrs...)
+}
+
+// Reset removes all records and injected behaviors
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = make(map[string][]dns.RR)
+	s.delay, s.truncate, s.rcode, s.drop = 0, false, dns.RcodeSuccess, false
+}
+
+// SetDelay delays every response by d
+func (s *Server) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// SetTruncate makes UDP responses truncated (TC bit set, no records) forcing clients to TCP
+func (s *Server) SetTruncate(truncate bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.truncate = truncate
+}
+
+// SetFailure makes the server answer every query with rcode, dns.RcodeSuccess restores normal answers
+func (s *Server) SetFailure(rcode int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rcode = rcode
+}
+
+// SetDrop makes the server not answer at all
+func (s *Server) SetDrop(drop bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drop = drop
+}
+
+// Queries returns the number of queries received
+func (s *Server) Queries() int {
+	return int(atomic.LoadUint64(&s.queries))
+}
+
+// maxChain - the max number of CNAME records followed in an answer
+const maxChain = 8
+
+// answer returns records of q following CNAME records of other names, like a recursive resolver
+func (s *Server) answer(q dns.Question) ([]dns.RR, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var answer []dns.RR
+	name := strings.ToLower(q.Name)
+	for i := 0; i <= maxChain; i++ {
+		rrs, ok := s.records[name]
+		if !ok {
+			return answer, dns.RcodeNameError
+		}
+		var target string
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == q.Qtype || q.Qtype == dns.TypeANY {
+				answer = append(answer, dns.Copy(rr))
+			} else if cname, ok := rr.(*dns.CNAME); ok && q.Qtype != dns.TypeCNAME {
+				answer = append(answer, dns.Copy(rr))
+				target = strings.ToLower(cname.Target)
+			}
+		}
+		if target == "" {
+			break
+		}
+		name = target
+	}
+	return answer, dns.RcodeSuccess
+}
+
+// ServeDNS implements dns.Handler
+func (s *Server) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	atomic.AddUint64(&s.queries, 1)
+
+	s.mu.RLock()
+	delay, truncate, rcode, drop := s.delay, s.truncate, s.rcode, s.drop
+	s.mu.RUnlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	if drop || len(req.Question) != 1 {
+		return
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.RecursionAvailable = true
+	if opt := req.IsEdns0(); opt != nil {
+		resp.SetEdns0(opt.UDPSize(), opt.Do())
+	}
+
+	_, isUDP := w.RemoteAddr().(*net.UDPAddr)
+	switch {
+	case rcode != dns.RcodeSuccess:
+		resp.Rcode = rcode
+	case truncate && isUDP:
+		resp.Truncated = true
+	default:
+		resp.Answer, resp.Rcode = s.answer(req.Question[0])
+	}
+
+	_ = w.WriteMsg(resp)
+}
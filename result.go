@@ -0,0 +1,53 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Result - a result of a host resolution
+type Result struct {
+	// IP4, IP6 - resolved addresses
+	IP4 []net.IP
+	IP6 []net.IP
+
+	// TTL - time the addresses are valid for
+	TTL time.Duration
+
+	// Source4, Source6 - where IPv4 and IPv6 addresses were obtained from
+	Source4 Source
+	Source6 Source
+
+	// ReadyWait - the time ResolveMany blocked waiting for the first resolution of the host,
+	// zero if it was resolved before
+	ReadyWait time.Duration
+
+	// Err - the error of the host resolved by ResolveMany, of type *net.DNSError
+	Err error
+}
+
+// ResolveUncached resolves a host with name hostName querying nameservers directly,
+// the cache is neither read nor updated. Nameservers of a matching policy are used
+func (r *Resolver) ResolveUncached(ctx context.Context, hostName string) (Result, error) {
+	if r.Stopped() {
+		return Result{}, ErrStopped
+	}
+	dnsClient, policy := r.hostClient(hostName)
+	secure := policy.requireDNSSEC() && !r.trustAnchors.isNegative(hostName)
+	l, err := dnsClient.lookupHost(ctx, hostName, FamilyAll, secure)
+	if err != nil {
+		return Result{}, err
+	}
+	ttl := l.ttl4
+	if l.ttl6 < ttl {
+		ttl = l.ttl6
+	}
+	return Result{
+		IP4:     l.ip4,
+		IP6:     l.ip6,
+		TTL:     time.Duration(ttl) * time.Second,
+		Source4: l.src4,
+		Source6: l.src6,
+	}, nil
+}
@@ -0,0 +1,225 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// rewriteTtl - the TTL of answers made by rewrite rules
+const rewriteTtl = defaultTtl
+
+// RewriteRule - a rule answering queries of a name with addresses or with records of another name,
+// like the address= and cname= directives of dnsmasq
+type RewriteRule struct {
+	// Name - the name matched exactly in any case, or a regular expression matched against
+	// the name in lower case without the trailing dot if Regexp is set
+	Name   string
+	Regexp bool
+
+	// Target - the name resolved instead of the matched one, with Regexp it may refer
+	// to submatches as $1 or ${1}. Targets are not rewritten again
+	Target string
+
+	// Addrs - addresses answered for the matched name if Target is empty, IPv6 addresses may have a zone
+	Addrs []string
+}
+
+// rewriteEntry ...
+type rewriteEntry struct {
+	rule RewriteRule
+	re   *regexp.Regexp
+
+	// ip4, ip6 - addresses of the rule rotated by GetNextIP*
+	ip4, ip6 *ips
+}
+
+// rewrites - rewrite rules of a resolver: exact names and regular expressions in the order they were added
+type rewrites struct {
+	mu     sync.RWMutex
+	exact  map[string]*rewriteEntry
+	regexp []*rewriteEntry
+}
+
+// add ...
+func (rw *rewrites) add(e *rewriteEntry) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if e.re == nil {
+		if rw.exact == nil {
+			rw.exact = make(map[string]*rewriteEntry)
+		}
+		rw.exact[e.rule.Name] = e
+		return
+	}
+	for i, re := range rw.regexp {
+		if re.rule.Name == e.rule.Name {
+			rw.regexp[i] = e
+			return
+		}
+	}
+	rw.regexp = append(rw.regexp, e)
+}
+
+// remove ...
+func (rw *rewrites) remove(name string) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	delete(rw.exact, normalizeName(name))
+	for i, re := range rw.regexp {
+		if re.rule.Name == name {
+			rw.regexp = append(rw.regexp[:i:i], rw.regexp[i+1:]...)
+			return
+		}
+	}
+}
+
+// match returns the rule matching name and the target name, nil if there is no such rule
+func (rw *rewrites) match(name string) (*rewriteEntry, string) {
+	rw.mu.RLock()
+	defer rw.mu.RUnlock()
+	if len(rw.exact) == 0 && len(rw.regexp) == 0 {
+		return nil, ""
+	}
+
+	name = normalizeName(name)
+	if e, ok := rw.exact[name]; ok {
+		return e, e.rule.Target
+	}
+	for _, e := range rw.regexp {
+		m := e.re.FindStringSubmatchIndex(name)
+		if m == nil {
+			continue
+		}
+		if e.rule.Target == "" {
+			return e, ""
+		}
+		return e, normalizeName(string(e.re.ExpandString(nil, e.rule.Target, name, m)))
+	}
+	return nil, ""
+}
+
+// list ...
+func (rw *rewrites) list() []RewriteRule {
+	rw.mu.RLock()
+	defer rw.mu.RUnlock()
+	ret := make([]RewriteRule, 0, len(rw.exact)+len(rw.regexp))
+	for _, e := range rw.exact {
+		ret = append(ret, e.rule)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	for _, e := range rw.regexp {
+		ret = append(ret, e.rule)
+	}
+	return ret
+}
+
+// normalizeName returns name in lower case without the trailing dot
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// AddRewrite adds a rewrite rule applied to the Go API lookups, DialContext and the server modes,
+// a rule with the same Name replaces the existing one. Rules may be added and removed at any time
+func (r *Resolver) AddRewrite(rule RewriteRule) error {
+	if rule.Name == "" {
+		return errors.New("rewrite rule has no name")
+	}
+	if rule.Target == "" && len(rule.Addrs) == 0 {
+		return fmt.Errorf("rewrite rule %q has neither target nor addresses", rule.Name)
+	}
+
+	e := &rewriteEntry{rule: rule}
+	if rule.Regexp {
+		re, err := regexp.Compile(rule.Name)
+		if err != nil {
+			return fmt.Errorf("rewrite rule %q: %w", rule.Name, err)
+		}
+		e.re = re
+	} else {
+		e.rule.Name = normalizeName(rule.Name)
+		e.rule.Target = normalizeName(rule.Target)
+	}
+
+	var ip4, ip6 []string
+	for _, addr := range rule.Addrs {
+		parsed, ok := parseIPAddr(addr)
+		if !ok {
+			return fmt.Errorf("rewrite rule %q: invalid address %q", rule.Name, addr)
+		}
+		if parsed.Is4() {
+			ip4 = append(ip4, addr)
+		} else {
+			ip6 = append(ip6, addr)
+		}
+	}
+	e.ip4, e.ip6 = newIpsFromList(ip4), newIpsFromList(ip6)
+
+	r.rewrites.add(e)
+	return nil
+}
+
+// RemoveRewrite removes the rewrite rule with Name name
+func (r *Resolver) RemoveRewrite(name string) {
+	r.rewrites.remove(name)
+}
+
+// Rewrites returns rewrite rules, exact ones sorted by name go first
+func (r *Resolver) Rewrites() []RewriteRule {
+	return r.rewrites.list()
+}
+
+// getNextIPWithIndex returns next address of the rule of family applying the family preference of o
+func (e *rewriteEntry) getNextIPWithIndex(family Family, o queryOptions, now time.Time) (netip.Addr, int) {
+	fallback := o.family != FamilyAll
+	if fallback {
+		family = o.family
+	}
+	first, second := e.ip4, e.ip6
+	if family == FamilyV6 {
+		first, second = e.ip6, e.ip4
+	}
+	ip, idx := first.getNextIPWithIndex(now)
+	if !ip.IsValid() && fallback {
+		ip, idx = second.getNextIPWithIndex(now)
+	}
+	return ip, idx
+}
+
+// lookupRewritten answers with addresses of a matching rewrite rule or with the answer for its target
+func (r *Resolver) lookupRewritten(ctx context.Context, l lookup) (lookupResult, bool) {
+	if l.rewritten {
+		return lookupResult{}, false
+	}
+	e, target := r.rewrites.match(l.name)
+	if e == nil {
+		return lookupResult{}, false
+	}
+
+	if target != "" {
+		tl := l
+		tl.name, tl.rewritten = target, true
+		res := r.resolve(ctx, tl)
+		res.cname = target
+		return res, true
+	}
+	if !l.isAddr() {
+		return lookupResult{rcode: dns.RcodeSuccess}, true
+	}
+	if l.sourceOnly {
+		return lookupResult{rcode: dns.RcodeSuccess, rewrite: e}, true
+	}
+	res := addrResult(e.ip4.getList(), e.ip6.getList(), rewriteTtl, rewriteTtl)
+	res.rewrite = e
+	return res, true
+}
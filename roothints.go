@@ -0,0 +1,217 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// rootPrimingInterval - the interval of priming queries if the root NS TTL is unknown
+	rootPrimingInterval = 12 * time.Hour
+)
+
+// errNoRootGlue - a priming response has no root servers with addresses
+var errNoRootGlue = errors.New("no root servers with addresses in priming response")
+
+// RootServer - a root name server with its addresses
+type RootServer struct {
+	Name string
+	IP4  []net.IP
+	IP6  []net.IP
+}
+
+// rootHints - root servers loaded from a hints file and updated by priming queries
+type rootHints struct {
+	mu      sync.RWMutex
+	servers []RootServer
+}
+
+// set ...
+func (h *rootHints) set(servers []RootServer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.servers = servers
+}
+
+// get ...
+func (h *rootHints) get() []RootServer {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.servers
+}
+
+// WithRootHints - loads root hints from the file at path (named.root format) and starts periodic
+// priming queries to learn the current root server set, see RootServers
+func (r *Resolver) WithRootHints(path string) *Resolver {
+	f, err := os.Open(path)
+	if err != nil {
+		logError(r.logger, r.tag, "Error loading root hints", err)
+		return r
+	}
+	defer f.Close()
+
+	servers, _, err := parseRootServers(dns.NewZoneParser(f, ".", path))
+	if err != nil {
+		logError(r.logger, r.tag, "Error parsing root hints", path, err)
+		return r
+	}
+	if len(servers) == 0 {
+		logError(r.logger, r.tag, "No root servers found in root hints", path)
+		return r
+	}
+
+	r.rootHints.set(servers)
+	r.scheduler.goroutines.spawn("root-priming", "", r.rootPrimingLoop)
+
+	return r
+}
+
+// RootServers returns the current root server set, nil if root hints are not configured
+func (r *Resolver) RootServers() []RootServer {
+	return r.rootHints.get()
+}
+
+// rootPrimingLoop ...
+func (r *Resolver) rootPrimingLoop() {
+	for {
+		interval := rootPrimingInterval
+		servers, ttl, err := r.primeRoots(context.Background())
+		if err == nil && len(servers) == 0 {
+			err = errNoRootGlue
+		}
+		if err != nil {
+			logError(r.logger, r.tag, "Error priming root servers", err)
+			interval = retryIntervalSec * time.Second
+		} else {
+			r.rootHints.set(servers)
+			if ttl > 0 {
+				interval = time.Duration(ttl) * time.Second
+			}
+		}
+
+		select {
+		case <-r.stopCh:
+			return
+		case <-r.clock.After(interval):
+		}
+	}
+}
+
+// primeRoots sends the priming query to the known root servers until one answers with root servers
+// and their addresses, returns the error of the last one otherwise
+func (r *Resolver) primeRoots(ctx context.Context) ([]RootServer, uint32, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(".", dns.TypeNS)
+	m.RecursionDesired = false
+	m.SetEdns0(ednsBufSize, false)
+
+	err := errNoNameServers
+	for _, rs := range r.rootHints.get() {
+		for _, ip := range append(append([]net.IP{}, rs.IP4...), rs.IP6...) {
+			qCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+			var in *dns.Msg
+			in, err = exchangeNet(qCtx, &r.clientCfg.conns, "udp", net.JoinHostPort(ip.String(), "53"), m)
+			cancel()
+			if err != nil {
+				continue
+			}
+
+			rrs := append(append([]dns.RR{}, in.Answer...), in.Extra...)
+			var servers []RootServer
+			var ttl uint32
+			servers, ttl, err = parseRootServers(&rrList{rrs: rrs})
+			if err == nil && len(servers) == 0 {
+				err = errNoRootGlue
+			}
+			if err != nil {
+				continue
+			}
+			return servers, ttl, nil
+		}
+	}
+	return nil, 0, err
+}
+
+// rrSource - a source of resource records
+type rrSource interface {
+	Next() (dns.RR, bool)
+	Err() error
+}
+
+// rrList - an rrSource over a slice
+type rrList struct {
+	rrs []dns.RR
+}
+
+// Next ...
+func (l *rrList) Next() (dns.RR, bool) {
+	if len(l.rrs) == 0 {
+		return nil, false
+	}
+	rr := l.rrs[0]
+	l.rrs = l.rrs[1:]
+	return rr, true
+}
+
+// Err ...
+func (l *rrList) Err() error {
+	return nil
+}
+
+// parseRootServers collects root NS records with their addresses and returns them with the minimal NS TTL
+func parseRootServers(src rrSource) ([]RootServer, uint32, error) {
+	var ttl uint32 = math.MaxUint32
+	byName := make(map[string]*RootServer)
+	addrs := make(map[string][]dns.RR)
+	for rr, ok := src.Next(); ok; rr, ok = src.Next() {
+		switch rec := rr.(type) {
+		case *dns.NS:
+			if rec.Hdr.Name != "." {
+				continue
+			}
+			name := strings.ToLower(rec.Ns)
+			byName[name] = &RootServer{Name: name}
+			if rec.Hdr.Ttl < ttl {
+				ttl = rec.Hdr.Ttl
+			}
+		case *dns.A, *dns.AAAA:
+			name := strings.ToLower(rr.Header().Name)
+			addrs[name] = append(addrs[name], rr)
+		}
+	}
+	if err := src.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	servers := make([]RootServer, 0, len(byName))
+	for name, rs := range byName {
+		for _, rr := range addrs[name] {
+			switch rec := rr.(type) {
+			case *dns.A:
+				rs.IP4 = append(rs.IP4, rec.A)
+			case *dns.AAAA:
+				rs.IP6 = append(rs.IP6, rec.AAAA)
+			}
+		}
+		if len(rs.IP4)+len(rs.IP6) > 0 {
+			servers = append(servers, *rs)
+		}
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].Name < servers[j].Name
+	})
+
+	if ttl == math.MaxUint32 {
+		ttl = 0
+	}
+	return servers, ttl, nil
+}
@@ -0,0 +1,45 @@
+package resolver
+
+import (
+	"math"
+	"math/rand"
+	"sync/atomic"
+)
+
+// WithRandSource - sets the random source of the resolver: rotation start offsets of hosts,
+// NameserverRandom, canary names, query ids of NetBIOS and the order of SRV targets of DialService.
+// A seeded source makes them reproducible. The source is used under a lock, it need not be safe
+// for concurrent use
+func (r *Resolver) WithRandSource(src rand.Source) *Resolver {
+	r.clientCfg.rnd.setSource(src)
+	return r
+}
+
+// WithRandomRotationStart - sets whether the rotation of addresses of each host starts at a random
+// offset, enabled by default, so many short-lived processes resolving the same name do not all
+// start with its first address. When disabled rotations start at the first address.
+// Static hosts always start at the first address. Applies to hosts created after this call
+func (r *Resolver) WithRandomRotationStart(enabled bool) *Resolver {
+	var flag int32
+	if !enabled {
+		flag = 1
+	}
+	atomic.StoreInt32(&r.fixedRotation, flag)
+	return r
+}
+
+// setSource ...
+func (l *lockedRand) setSource(src rand.Source) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rnd = rand.New(src)
+}
+
+// randomizeRotation sets random rotation start offsets of a new host unless it is disabled
+func (r *Resolver) randomizeRotation(h *host) {
+	if atomic.LoadInt32(&r.fixedRotation) == 1 {
+		return
+	}
+	atomic.StoreUint64(&h.ip4.ipIdx, uint64(r.clientCfg.rnd.intn(math.MaxInt32)))
+	atomic.StoreUint64(&h.ip6.ipIdx, uint64(r.clientCfg.rnd.intn(math.MaxInt32)))
+}
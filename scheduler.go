@@ -0,0 +1,630 @@
+package resolver
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultRefreshWorkers - the default number of refreshes run at once
+	defaultRefreshWorkers = 32
+
+	// recentAccessDuration - hosts looked up within this duration are refreshed before cold ones
+	recentAccessDuration = 5 * time.Minute
+
+	// refreshTimeout - the max duration of a refresh, it covers retries and all nameservers tried
+	refreshTimeout = 30 * time.Second
+)
+
+// refreshPriority - the order of due refreshes waiting for a worker, lower values go first
+type refreshPriority int
+
+const (
+	// priorityInitial - first resolutions, lookups are blocked on them
+	priorityInitial refreshPriority = iota
+	// priorityCritical - hosts of ClassCritical
+	priorityCritical
+	// priorityHot - explicitly added and recently looked up hosts
+	priorityHot
+	// priorityCold - implicit hosts not looked up recently
+	priorityCold
+	// priorityBackground - hosts of ClassBackground
+	priorityBackground
+)
+
+// refreshTask - a scheduled refresh of addresses of a host or of its RRset of qtype
+type refreshTask struct {
+	h      *host
+	family Family
+	at     time.Time
+
+	// qtype - the type of the RRset refreshed, dns.TypeNone for addresses
+	qtype uint16
+
+	// initial - the first resolution of the host, the host is ready when it is done
+	initial bool
+
+	// priority - the priority of the task set when it is due
+	priority refreshPriority
+
+	// index - the index in the queue or in the due queue if due is set, -1 if the task is not queued
+	index int
+
+	// due - the task is due and waits for a worker
+	due bool
+}
+
+// refreshQueue - a heap of tasks ordered by time, implements heap.Interface
+type refreshQueue []*refreshTask
+
+func (q refreshQueue) Len() int { return len(q) }
+
+func (q refreshQueue) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+
+func (q refreshQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+// Push ...
+func (q *refreshQueue) Push(x interface{}) {
+	t := x.(*refreshTask)
+	t.index = len(*q)
+	*q = append(*q, t)
+}
+
+// Pop ...
+func (q *refreshQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*q = old[:n-1]
+	return t
+}
+
+// dueQueue - a heap of due tasks ordered by priority and then by time, implements heap.Interface
+type dueQueue []*refreshTask
+
+func (q dueQueue) Len() int { return len(q) }
+
+func (q dueQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority < q[j].priority
+	}
+	return q[i].at.Before(q[j].at)
+}
+
+func (q dueQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+// Push ...
+func (q *dueQueue) Push(x interface{}) {
+	t := x.(*refreshTask)
+	t.index = len(*q)
+	*q = append(*q, t)
+}
+
+// Pop ...
+func (q *dueQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*q = old[:n-1]
+	return t
+}
+
+// scheduler - refreshes addresses of all hosts of a resolver: one goroutine waits for the earliest
+// refresh and due refreshes are run by at most workers goroutines, so the number of goroutines
+// does not grow with the number of hosts
+type scheduler struct {
+	clock Clock
+
+	mu sync.Mutex
+
+	// queue - scheduled tasks, due - tasks waiting for a worker
+	queue refreshQueue
+	due   dueQueue
+
+	// running - the number of tasks being run, workers - the max number of them
+	running int
+	workers int
+
+	// refreshes - the number of refreshes done
+	refreshes uint64
+
+	// lazy - refreshes of hosts not looked up since their last refresh are suspended, see WithLazyRefresh
+	lazy bool
+
+	// suspensions - the number of refreshes suspended
+	suspensions uint64
+
+	// paused - no refreshes but first resolutions are started, see Resolver.Suspend
+	paused bool
+
+	// waiters - the number of goroutines waiting for first resolutions or watching hosts
+	waiters int64
+
+	// readyWait - the total time in nanoseconds callers blocked waiting for first resolutions of hosts
+	readyWait int64
+
+	// apex - the state of WithApexCoalescing, nil if refreshes are not coalesced
+	apex *apexCoalescer
+
+	// active - running tasks and the time they started at
+	active map[*refreshTask]time.Time
+
+	// goroutines - labeled goroutines of the resolver, see DiagnosticsDump
+	goroutines *goroutines
+
+	// ctx - the parent of refresh contexts, canceled when the scheduler stops
+	ctx           context.Context
+	stopRefreshes context.CancelFunc
+
+	wakeCh chan struct{}
+	stopCh <-chan struct{}
+}
+
+// newScheduler returns a scheduler of the resolver tagged tag running until stopCh is closed
+func newScheduler(tag string, clock Clock, stopCh <-chan struct{}) *scheduler {
+	s := &scheduler{
+		clock:      clock,
+		workers:    defaultRefreshWorkers,
+		active:     make(map[*refreshTask]time.Time),
+		goroutines: newGoroutines(tag),
+		wakeCh:     make(chan struct{}, 1),
+		stopCh:     stopCh,
+	}
+	s.ctx, s.stopRefreshes = context.WithCancel(context.Background())
+	s.goroutines.spawn("scheduler", "", s.loop)
+	return s
+}
+
+// seed schedules refreshes of a host whose addresses were set without the first resolution:
+// addresses per their TTLs and RRsets of the policy for now
+func (s *scheduler) seed(h *host, ttl4, ttl6 uint32) {
+	now := s.clock.Now()
+	family := h.policy.family()
+	s.mu.Lock()
+	s.track(h)
+	if h.resolvesAddrs() {
+		if family.hasV4() {
+			s.push(&refreshTask{h: h, family: FamilyV4, at: now.Add(h.refreshDelay(ttl4))})
+		}
+		if family.hasV6() {
+			s.push(&refreshTask{h: h, family: FamilyV6, at: now.Add(h.refreshDelay(ttl6))})
+		}
+	}
+	for _, qtype := range h.rrsetTypes() {
+		s.push(&refreshTask{h: h, qtype: qtype, at: now})
+	}
+	s.mu.Unlock()
+	s.wake()
+}
+
+// start schedules the first resolution of h for now
+func (s *scheduler) start(h *host) {
+	s.mu.Lock()
+	s.track(h)
+	s.push(&refreshTask{h: h, family: h.policy.family(), at: s.clock.Now(), initial: true})
+	s.mu.Unlock()
+	s.wake()
+}
+
+// cancel removes tasks of a stopped host, a host whose first resolution is cancelled becomes ready
+func (s *scheduler) cancel(h *host) {
+	s.mu.Lock()
+	tasks := h.tasks
+	h.tasks, h.suspended = nil, nil
+	if s.apex != nil && h.apex != "" {
+		s.apex.remove(h.apex)
+		h.apex = ""
+	}
+	initial := false
+	for _, t := range tasks {
+		switch {
+		case t.due:
+			heap.Remove(&s.due, t.index)
+			t.due = false
+		case t.index >= 0:
+			heap.Remove(&s.queue, t.index)
+		}
+		initial = initial || t.initial
+	}
+	s.mu.Unlock()
+
+	if initial {
+		h.markReady()
+	}
+}
+
+// setWorkers ...
+func (s *scheduler) setWorkers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	s.mu.Lock()
+	s.workers = n
+	s.mu.Unlock()
+	s.wake()
+}
+
+// counts returns the numbers of scheduled and running refreshes
+func (s *scheduler) counts() (scheduled, running int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue) + len(s.due), s.running
+}
+
+// trackWaiter counts a waiting goroutine, the returned function must be called when it exits
+func (s *scheduler) trackWaiter() func() {
+	atomic.AddInt64(&s.waiters, 1)
+	return func() {
+		atomic.AddInt64(&s.waiters, -1)
+	}
+}
+
+// getReadyWait ...
+func (s *scheduler) getReadyWait() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.readyWait))
+}
+
+// getRefreshes ...
+func (s *scheduler) getRefreshes() uint64 {
+	return atomic.LoadUint64(&s.refreshes)
+}
+
+// track counts a host in its apex if refreshes are coalesced, s.mu must be held
+func (s *scheduler) track(h *host) {
+	if s.apex != nil && h.apex == "" {
+		h.apex = apexOf(h.hostName, s.apex.cfg.Labels)
+		s.apex.add(h.apex)
+	}
+}
+
+// setApex ...
+func (s *scheduler) setApex(c *apexCoalescer) {
+	s.mu.Lock()
+	old := s.apex
+	s.apex = c
+	s.mu.Unlock()
+	if old != nil {
+		old.closeConns()
+	}
+}
+
+// refreshContext returns the context of a refresh of h, it times out after refreshTimeout
+// and is canceled when the scheduler stops
+func (s *scheduler) refreshContext(h *host) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(s.ctx, refreshTimeout)
+	s.mu.Lock()
+	c, apex := s.apex, h.apex
+	s.mu.Unlock()
+	if c == nil || apex == "" {
+		return ctx, cancel
+	}
+	return c.context(ctx, apex, &h.dnsClient.cfg.conns), cancel
+}
+
+// getApex ...
+func (s *scheduler) getApex() *apexCoalescer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.apex
+}
+
+// getCoalesced ...
+func (s *scheduler) getCoalesced() uint64 {
+	c := s.getApex()
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&c.coalesced)
+}
+
+// push queues t moving it to join a refresh of its apex if they are coalesced, a first resolution
+// is due at once, s.mu must be held
+func (s *scheduler) push(t *refreshTask) {
+	if apex := t.h.apex; s.apex != nil && apex != "" && !t.initial && s.apex.active(apex) {
+		if now := s.clock.Now(); t.at.After(now) {
+			t.at = s.apex.align(apex, t.at, now)
+		}
+	}
+	if t.initial {
+		// first resolutions are due at once, even while refreshes are paused
+		t.priority, t.due = priorityInitial, true
+		heap.Push(&s.due, t)
+	} else {
+		heap.Push(&s.queue, t)
+	}
+	t.h.tasks = append(t.h.tasks, t)
+}
+
+// wake makes the loop check the queue
+func (s *scheduler) wake() {
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// loop moves tasks whose time has come to due ones and starts them by priority while there are free workers.
+// A timer is set for the earliest task only when it is earlier than the one already set
+func (s *scheduler) loop() {
+	var (
+		timerCh  <-chan time.Time
+		deadline time.Time
+	)
+	for {
+		s.mu.Lock()
+		now := s.clock.Now()
+		for !s.paused && len(s.queue) > 0 && !s.queue[0].at.After(now) {
+			t := heap.Pop(&s.queue).(*refreshTask)
+			if s.lazy && t.h.isUnread(t) {
+				s.suspend(t)
+				continue
+			}
+			t.priority = t.h.refreshPriority(t, now)
+			t.due = true
+			heap.Push(&s.due, t)
+		}
+		var limited []*refreshTask
+		for len(s.due) > 0 && s.running < s.workers && (!s.paused || s.due[0].initial) {
+			t := heap.Pop(&s.due).(*refreshTask)
+			if s.apex != nil && t.h.apex != "" && s.apex.limited(t.h.apex) {
+				limited = append(limited, t)
+				continue
+			}
+			t.due = false
+			t.h.removeTask(t)
+			s.running++
+			s.active[t] = now
+			if s.apex != nil && t.h.apex != "" {
+				s.apex.started(t.h.apex)
+			}
+			s.goroutines.spawn("refresh", t.h.logName(), func() { s.run(t) })
+		}
+		// refreshes of apexes running MaxConcurrent ones wait for them to finish
+		for _, t := range limited {
+			heap.Push(&s.due, t)
+		}
+		if s.paused {
+			timerCh = nil
+		} else if len(s.queue) > 0 && (timerCh == nil || s.queue[0].at.Before(deadline)) {
+			deadline = s.queue[0].at
+			timerCh = s.clock.After(deadline.Sub(now))
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.stopCh:
+			s.stopRefreshes()
+			if c := s.getApex(); c != nil {
+				c.closeConns()
+			}
+			return
+		case <-s.wakeCh:
+		case <-timerCh:
+			timerCh = nil
+		}
+	}
+}
+
+// run refreshes the task and schedules the next refreshes per their TTLs
+func (s *scheduler) run(t *refreshTask) {
+	h := t.h
+	next := s.refresh(t)
+
+	s.mu.Lock()
+	s.running--
+	delete(s.active, t)
+	if s.apex != nil && h.apex != "" {
+		s.apex.finished(h.apex)
+	}
+	// a host stopped after the check has its tasks removed by cancel
+	if !h.isStopped() {
+		for _, nt := range next {
+			s.push(nt)
+		}
+	}
+	s.mu.Unlock()
+	s.wake()
+
+	if t.initial {
+		h.markReady()
+	}
+}
+
+// refresh reloads addresses of the task family or the RRset of the task qtype and returns the next
+// refreshes of them per their TTLs. The first resolution also reloads all RRsets of the host
+func (s *scheduler) refresh(t *refreshTask) []*refreshTask {
+	h := t.h
+	ctx, cancel := s.refreshContext(h)
+	defer cancel()
+
+	var next []*refreshTask
+	if t.qtype == dns.TypeNone && h.resolvesAddrs() {
+		ttl4, ttl6 := h.reloadIPs(ctx, t.family)
+		now := s.clock.Now()
+		if t.family.hasV4() {
+			next = append(next, &refreshTask{h: h, family: FamilyV4, at: now.Add(h.refreshDelay(ttl4))})
+		}
+		if t.family.hasV6() {
+			next = append(next, &refreshTask{h: h, family: FamilyV6, at: now.Add(h.refreshDelay(ttl6))})
+		}
+	}
+	qtypes := []uint16{t.qtype}
+	if t.initial {
+		qtypes = h.rrsetTypes()
+	}
+	for _, qtype := range qtypes {
+		if qtype == dns.TypeNone {
+			continue
+		}
+		ttl := h.reloadRRset(ctx, qtype)
+		next = append(next, &refreshTask{h: h, qtype: qtype, at: s.clock.Now().Add(h.refreshDelay(ttl))})
+	}
+	atomic.StoreInt64(&h.refreshTime, s.clock.Now().Unix())
+	atomic.AddUint64(&s.refreshes, 1)
+	return next
+}
+
+// suspend keeps the due task of a host not looked up since its last refresh until the next lookup, s.mu must be held
+func (s *scheduler) suspend(t *refreshTask) {
+	h := t.h
+	h.removeTask(t)
+	h.suspended = append(h.suspended, t)
+	atomic.StoreInt32(&h.suspendedFlag, 1)
+	atomic.AddUint64(&s.suspensions, 1)
+}
+
+// resume refreshes suspended tasks of h at once and schedules their next refreshes.
+// Lookups of h wait for it, so they are answered with fresh addresses
+func (s *scheduler) resume(h *host) {
+	if atomic.LoadInt32(&h.suspendedFlag) == 0 {
+		return
+	}
+	h.resumeMu.Lock()
+	defer h.resumeMu.Unlock()
+
+	s.mu.Lock()
+	tasks := h.suspended
+	h.suspended = nil
+	atomic.StoreInt32(&h.suspendedFlag, 0)
+	s.mu.Unlock()
+
+	var next []*refreshTask
+	for _, t := range tasks {
+		next = append(next, s.refresh(t)...)
+	}
+
+	s.mu.Lock()
+	if !h.isStopped() {
+		for _, nt := range next {
+			s.push(nt)
+		}
+	}
+	s.mu.Unlock()
+	s.wake()
+}
+
+// reschedule moves scheduled address refreshes of family of h to at, due and running refreshes are not moved
+func (s *scheduler) reschedule(h *host, family Family, at time.Time) {
+	s.mu.Lock()
+	for _, t := range h.tasks {
+		if t.qtype != dns.TypeNone || t.due || t.index < 0 || (family != FamilyAll && t.family != family) {
+			continue
+		}
+		t.at = at
+		heap.Fix(&s.queue, t.index)
+	}
+	s.mu.Unlock()
+	s.wake()
+}
+
+// setPaused pauses or resumes starting refreshes. On resume refreshes whose time passed by the wall
+// clock are due at once: the monotonic clock which the times are compared by may stop while the system sleeps
+func (s *scheduler) setPaused(paused bool) {
+	s.mu.Lock()
+	s.paused = paused
+	if !paused {
+		now := s.clock.Now()
+		wallNow := now.Round(0)
+		for _, t := range s.queue {
+			if !t.at.Round(0).After(wallNow) {
+				t.at = now
+			}
+		}
+		heap.Init(&s.queue)
+	}
+	s.mu.Unlock()
+	s.wake()
+}
+
+// isPaused ...
+func (s *scheduler) isPaused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// setLazy ...
+func (s *scheduler) setLazy(lazy bool) {
+	s.mu.Lock()
+	s.lazy = lazy
+	s.mu.Unlock()
+}
+
+// getSuspensions ...
+func (s *scheduler) getSuspensions() uint64 {
+	return atomic.LoadUint64(&s.suspensions)
+}
+
+// isUnread reports whether the due task t may be suspended: the host is added non-explicitly
+// and it was not looked up since its last refresh
+func (h *host) isUnread(t *refreshTask) bool {
+	return !t.initial && !h.isExplicitlyAdded() && h.getClass() != ClassCritical && atomic.LoadInt64(&h.lastTime) < atomic.LoadInt64(&h.refreshTime)
+}
+
+// refreshPriority returns the priority of a due refresh of the host
+func (h *host) refreshPriority(t *refreshTask, now time.Time) refreshPriority {
+	switch {
+	case t.initial:
+		return priorityInitial
+	case h.getClass() == ClassCritical:
+		return priorityCritical
+	case h.getClass() == ClassBackground:
+		return priorityBackground
+	case h.isExplicitlyAdded() || atomic.LoadInt64(&h.lastTime) >= now.Add(-recentAccessDuration).Unix():
+		return priorityHot
+	}
+	return priorityCold
+}
+
+// WithLazyRefresh - suspends refreshes of hosts added non-explicitly which were not looked up since
+// their last refresh, so idle hosts of bursty workloads cause no queries until they are deleted.
+// The next lookup of a suspended host refreshes its expired addresses at once and waits for them
+func (r *Resolver) WithLazyRefresh(enabled bool) *Resolver {
+	r.scheduler.setLazy(enabled)
+	return r
+}
+
+// Suspend pauses refreshes of all hosts, e.g. before the system sleeps, refreshes running finish.
+// Cached addresses are served as usual, first resolutions of new hosts are not paused
+func (r *Resolver) Suspend() {
+	r.scheduler.setPaused(true)
+	logInfo(r.logger, r.tag, "Refreshes suspended")
+}
+
+// Resume resumes refreshes paused by Suspend, e.g. when the system wakes up: hosts whose TTLs elapsed
+// during the pause are refreshed at once in the order of their priorities, the others at their TTLs
+func (r *Resolver) Resume() {
+	r.scheduler.setPaused(false)
+	logInfo(r.logger, r.tag, "Refreshes resumed")
+}
+
+// Suspended reports whether refreshes are paused by Suspend
+func (r *Resolver) Suspended() bool {
+	return r.scheduler.isPaused()
+}
+
+// WithRefreshWorkers - sets the max number of host refreshes run at once, 32 by default.
+// Refreshes due while all workers are busy wait for a free one: first resolutions go first,
+// then refreshes of ClassCritical hosts, then of explicitly added hosts and hosts looked up
+// within the last 5 minutes, then of the other hosts and ClassBackground hosts go last
+func (r *Resolver) WithRefreshWorkers(n int) *Resolver {
+	r.scheduler.setWorkers(n)
+	return r
+}
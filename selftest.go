@@ -0,0 +1,218 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// selfTestQueries - the number of queries the latency of a nameserver is measured with
+	selfTestQueries = 3
+
+	// selfTestTimeout - the timeout of each query of the self-test
+	selfTestTimeout = 3 * time.Second
+)
+
+// CheckStatus - the outcome of a check of SelfTest
+type CheckStatus int
+
+const (
+	// CheckSkipped - the check was not done, e.g. the nameserver is unreachable
+	CheckSkipped CheckStatus = iota
+	// CheckPassed - the nameserver has the capability
+	CheckPassed
+	// CheckFailed - the nameserver lacks the capability or the check failed
+	CheckFailed
+)
+
+// String ...
+func (s CheckStatus) String() string {
+	switch s {
+	case CheckSkipped:
+		return "skipped"
+	case CheckPassed:
+		return "passed"
+	case CheckFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// MarshalText ...
+func (s CheckStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Check - the result of a check of SelfTest, Detail explains a failure or adds facts found
+type Check struct {
+	Status CheckStatus
+	Detail string
+}
+
+// UpstreamReport - results of SelfTest for a nameserver
+type UpstreamReport struct {
+	Nameserver string
+
+	// Reachability - whether the nameserver answers queries the way refreshes send them,
+	// Transport is the transport of the last answer
+	Reachability Check
+	Transport    string
+
+	// Answered - the number of the latency queries answered, RTT - their mean round trip time
+	Answered int
+	RTT      time.Duration
+
+	// EDNS - whether the nameserver supports EDNS over UDP, TCP - whether it answers over TCP
+	EDNS Check
+	TCP  Check
+
+	// DNSSEC - whether the nameserver validates DNSSEC, i.e. sets the AD bit for the signed root zone
+	DNSSEC Check
+}
+
+// SelfTestReport - results of SelfTest
+type SelfTestReport struct {
+	Time      time.Time
+	Duration  time.Duration
+	Upstreams []UpstreamReport
+}
+
+// OK reports whether at least one nameserver is reachable
+func (rep SelfTestReport) OK() bool {
+	for _, u := range rep.Upstreams {
+		if u.Reachability.Status == CheckPassed {
+			return true
+		}
+	}
+	return false
+}
+
+// SelfTest checks every nameserver of the resolver concurrently, e.g. for an onboarding wizard
+// or a support bundle: reachability and latency with queries for the root NS RRset the way refreshes
+// send them, EDNS support over UDP and answers over TCP without fallbacks, and DNSSEC validation.
+// The checks of an unreachable nameserver are skipped. Queries are counted in NameserverStats
+func (r *Resolver) SelfTest(ctx context.Context) SelfTestReport {
+	rep := SelfTestReport{Time: r.clock.Now()}
+	start := time.Now()
+
+	nameServers := r.dnsClient.getNameServers()
+	rep.Upstreams = make([]UpstreamReport, len(nameServers))
+	var wg sync.WaitGroup
+	for i, nServer := range nameServers {
+		i, nServer := i, nServer
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rep.Upstreams[i] = r.dnsClient.selfTest(ctx, nServer)
+		}()
+	}
+	wg.Wait()
+
+	rep.Duration = time.Since(start)
+	logInfo(r.logger, r.tag, "Self-test done in", rep.Duration, "ok:", rep.OK())
+	return rep
+}
+
+// selfTest checks nServer
+func (d *dnsClient) selfTest(ctx context.Context, nServer string) UpstreamReport {
+	u := UpstreamReport{Nameserver: nServer}
+
+	var total time.Duration
+	var lastErr error
+	for i := 0; i < selfTestQueries; i++ {
+		qctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+		start := time.Now()
+		in, src, err := d.exchange(qctx, nServer, ".", dns.TypeNS, false)
+		rtt := time.Since(start)
+		cancel()
+		if err == nil && in.Rcode != dns.RcodeSuccess {
+			err = fmt.Errorf("rcode %s", dns.RcodeToString[in.Rcode])
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		u.Answered++
+		u.Transport = src.Transport
+		total += rtt
+	}
+	if u.Answered == 0 {
+		u.Reachability = Check{Status: CheckFailed, Detail: lastErr.Error()}
+		return u
+	}
+	u.RTT = total / time.Duration(u.Answered)
+	u.Reachability = Check{Status: CheckPassed, Detail: fmt.Sprintf("%d of %d queries answered", u.Answered, selfTestQueries)}
+
+	addr := nameServerAddr(nServer)
+	u.EDNS = d.checkEDNS(ctx, addr)
+	u.TCP = d.checkTCP(ctx, addr)
+	u.DNSSEC = d.checkDNSSEC(ctx, addr)
+	return u
+}
+
+// selfTestExchange sends a query for the root zone RRset of qtype to addr over network
+func (d *dnsClient) selfTestExchange(ctx context.Context, network, addr string, qtype uint16, edns, dnssecOK bool) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(".", qtype)
+	if edns {
+		m.SetEdns0(ednsBufSize, dnssecOK)
+	}
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+	return exchangeNet(ctx, &d.cfg.conns, network, addr, m)
+}
+
+// checkEDNS checks the nameserver answers a query with EDNS over UDP with an OPT record
+func (d *dnsClient) checkEDNS(ctx context.Context, addr string) Check {
+	in, err := d.selfTestExchange(ctx, TransportUDP, addr, dns.TypeNS, true, false)
+	switch {
+	case err != nil:
+		return Check{Status: CheckFailed, Detail: err.Error()}
+	case in.Rcode == dns.RcodeFormatError || in.Rcode == dns.RcodeNotImplemented:
+		return Check{Status: CheckFailed, Detail: "rcode " + dns.RcodeToString[in.Rcode]}
+	}
+	opt := in.IsEdns0()
+	if opt == nil {
+		return Check{Status: CheckFailed, Detail: "no OPT record in the response"}
+	}
+	return Check{Status: CheckPassed, Detail: fmt.Sprintf("UDP size %d", opt.UDPSize())}
+}
+
+// checkTCP checks the nameserver answers over TCP
+func (d *dnsClient) checkTCP(ctx context.Context, addr string) Check {
+	in, err := d.selfTestExchange(ctx, TransportTCP, addr, dns.TypeNS, true, false)
+	if err != nil {
+		return Check{Status: CheckFailed, Detail: err.Error()}
+	}
+	if in.Rcode != dns.RcodeSuccess {
+		return Check{Status: CheckFailed, Detail: "rcode " + dns.RcodeToString[in.Rcode]}
+	}
+	return Check{Status: CheckPassed}
+}
+
+// checkDNSSEC checks the nameserver validates the signed root SOA setting the AD bit
+func (d *dnsClient) checkDNSSEC(ctx context.Context, addr string) Check {
+	in, err := d.selfTestExchange(ctx, TransportUDP, addr, dns.TypeSOA, true, true)
+	if err == nil && in.Truncated {
+		in, err = d.selfTestExchange(ctx, TransportTCP, addr, dns.TypeSOA, true, true)
+	}
+	if err != nil {
+		return Check{Status: CheckFailed, Detail: err.Error()}
+	}
+	if in.Rcode != dns.RcodeSuccess {
+		return Check{Status: CheckFailed, Detail: "rcode " + dns.RcodeToString[in.Rcode]}
+	}
+	if in.AuthenticatedData {
+		return Check{Status: CheckPassed}
+	}
+	for _, rr := range in.Answer {
+		if _, ok := rr.(*dns.RRSIG); ok {
+			return Check{Status: CheckFailed, Detail: "signatures are returned but not validated"}
+		}
+	}
+	return Check{Status: CheckFailed, Detail: "no AD bit nor signatures"}
+}
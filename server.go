@@ -0,0 +1,131 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// serverTimeout - the max time to answer a query of a client
+const serverTimeout = 5 * time.Second
+
+// ServeDNS implements dns.Handler answering queries through the lookup pipeline: local hosts,
+// the blocklist, the cache and the nameservers, so the resolver can be served by dns.Server
+func (r *Resolver) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	ctx, cancel := context.WithTimeout(context.Background(), serverTimeout)
+	defer cancel()
+
+	var resp *dns.Msg
+	if req.Opcode == dns.OpcodeNotify {
+		resp = r.notify(req, w.RemoteAddr())
+	} else {
+		resp = r.viewResolver(w.RemoteAddr()).answer(ctx, req)
+	}
+	// answers over UDP which do not fit the buffer of the client are truncated, so it retries over TCP
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		resp.Truncate(udpSize(req))
+	}
+	if err := w.WriteMsg(resp); err != nil {
+		logError(r.logger, r.tag, "Error writing answer to", w.RemoteAddr(), err)
+	}
+}
+
+// udpSize returns the UDP buffer size of the client of req, 512 bytes without EDNS0
+func udpSize(req *dns.Msg) int {
+	if opt := req.IsEdns0(); opt != nil {
+		return int(opt.UDPSize())
+	}
+	return dns.MinMsgSize
+}
+
+// answer returns a response to a query of a client
+func (r *Resolver) answer(ctx context.Context, req *dns.Msg) *dns.Msg {
+	resp := new(dns.Msg)
+	if req.Opcode != dns.OpcodeQuery || len(req.Question) != 1 {
+		return resp.SetRcode(req, dns.RcodeNotImplemented)
+	}
+	resp.SetReply(req)
+	resp.RecursionAvailable = true
+
+	q := req.Question[0]
+	if q.Qclass != dns.ClassINET || q.Qtype == dns.TypeNone {
+		return resp.SetRcode(req, dns.RcodeNotImplemented)
+	}
+
+	l := newLookup(q.Name, q.Qtype)
+	opt := req.IsEdns0()
+	l.dnssecOK = opt != nil && opt.Do()
+
+	res := r.resolve(ctx, l)
+	if res.err != nil {
+		resp.Rcode = dns.RcodeServerFailure
+	} else {
+		resp.Rcode = res.rcode
+		resp.AuthenticatedData = res.ad
+		resp.Answer, resp.Ns, resp.Extra = res.answer, res.ns, res.extra
+		if l.isAddr() {
+			tq := q
+			if res.cname != "" {
+				tq.Name = dns.Fqdn(res.cname)
+			}
+			var chain []dns.RR
+			chain, tq.Name = chainRRs(tq, res)
+			resp.Answer = append(chain, addrRRs(tq, res)...)
+		}
+		if res.cname != "" {
+			cname := &dns.CNAME{
+				Hdr:    dns.RR_Header{Name: q.Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: rewriteTtl},
+				Target: dns.Fqdn(res.cname),
+			}
+			resp.Answer = append([]dns.RR{cname}, resp.Answer...)
+		}
+	}
+
+	if opt != nil {
+		resp.SetEdns0(ednsBufSize, opt.Do())
+		if res.stale {
+			ede := &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeStaleAnswer}
+			resp.IsEdns0().Option = append(resp.IsEdns0().Option, ede)
+		}
+	}
+	return resp
+}
+
+// chainRRs returns CNAME records of the chain of a lookup result starting at the name of q
+// with the TTL of its addresses and the name the chain ends with
+func chainRRs(q dns.Question, res lookupResult) ([]dns.RR, string) {
+	if len(res.chain) == 0 || res.chain[0].Name != normalizeName(q.Name) {
+		return nil, q.Name
+	}
+	ttl := res.ttl4
+	if q.Qtype == dns.TypeAAAA {
+		ttl = res.ttl6
+	}
+	rrs := make([]dns.RR, 0, len(res.chain))
+	for _, link := range res.chain {
+		rrs = append(rrs, &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: dns.Fqdn(link.Name), Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: ttl},
+			Target: dns.Fqdn(link.Target),
+		})
+	}
+	return rrs, dns.Fqdn(res.chain[len(res.chain)-1].Target)
+}
+
+// addrRRs returns A or AAAA records of addresses of a lookup result as asked by q
+func addrRRs(q dns.Question, res lookupResult) []dns.RR {
+	var rrs []dns.RR
+	if q.Qtype == dns.TypeA {
+		hdr := dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: res.ttl4}
+		for _, ip := range res.ip4 {
+			rrs = append(rrs, &dns.A{Hdr: hdr, A: ip})
+		}
+		return rrs
+	}
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: res.ttl6}
+	for _, ip := range res.ip6 {
+		rrs = append(rrs, &dns.AAAA{Hdr: hdr, AAAA: ip})
+	}
+	return rrs
+}
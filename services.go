@@ -0,0 +1,205 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultConsulDomain - the default domain of the Consul DNS interface
+	defaultConsulDomain = "consul"
+
+	// defaultClusterDomain - the default cluster domain of Kubernetes
+	defaultClusterDomain = "cluster.local"
+)
+
+// Endpoint - an address of a service instance resolved from an SRV record
+type Endpoint struct {
+	// Target - the target host of the SRV record
+	Target string
+
+	// Addr - the address in the host:port form
+	Addr string
+
+	Priority uint16
+	Weight   uint16
+}
+
+// ConsulServiceName returns the name of SRV records of a service of the Consul DNS interface:
+// [tag.]service.service[.datacenter].domain, domain is "consul" if empty
+func ConsulServiceName(service, tag, datacenter, domain string) string {
+	if domain == "" {
+		domain = defaultConsulDomain
+	}
+	parts := make([]string, 0, 5)
+	if tag != "" {
+		parts = append(parts, tag)
+	}
+	parts = append(parts, service, "service")
+	if datacenter != "" {
+		parts = append(parts, datacenter)
+	}
+	parts = append(parts, domain)
+	return strings.Join(parts, ".")
+}
+
+// KubernetesServiceName returns the name of SRV records of a named port of a Kubernetes service:
+// _port._proto.service.namespace.svc.clusterDomain, clusterDomain is "cluster.local" if empty.
+// For headless services the records point to the pods
+func KubernetesServiceName(port, proto, service, namespace, clusterDomain string) string {
+	if clusterDomain == "" {
+		clusterDomain = defaultClusterDomain
+	}
+	return "_" + port + "._" + proto + "." + service + "." + namespace + ".svc." + clusterDomain
+}
+
+// ResolveEndpoints resolves the SRV records of qname into endpoints ordered by priority and weight
+// descending. The SRV RRset is maintained like with Maintain and addresses of the targets are served
+// from the cache like with LookupIP, so repeated calls do not query nameservers.
+// Errors are of type *net.DNSError
+func (r *Resolver) ResolveEndpoints(ctx context.Context, qname string) ([]Endpoint, error) {
+	if r.Stopped() {
+		return nil, ErrStopped
+	}
+	r.Maintain(qname, dns.TypeSRV)
+
+	var srvs []*dns.SRV
+	for _, rr := range r.GetRecords(qname, dns.TypeSRV) {
+		if srv, ok := rr.(*dns.SRV); ok {
+			srvs = append(srvs, srv)
+		}
+	}
+	sort.SliceStable(srvs, func(i, j int) bool {
+		if srvs[i].Priority != srvs[j].Priority {
+			return srvs[i].Priority < srvs[j].Priority
+		}
+		return srvs[i].Weight > srvs[j].Weight
+	})
+
+	var endpoints []Endpoint
+	for _, srv := range srvs {
+		target := strings.TrimSuffix(srv.Target, ".")
+		ipList, err := r.LookupIP(ctx, "ip", target)
+		if err != nil {
+			logError(r.logger, r.tag, "Error resolving target of", r.clientCfg.privacy.redact(qname),
+				r.clientCfg.privacy.redact(target), r.clientCfg.privacy.redactErr(err, target))
+			continue
+		}
+		port := strconv.Itoa(int(srv.Port))
+		for _, ip := range ipList {
+			endpoints = append(endpoints, Endpoint{
+				Target:   target,
+				Addr:     net.JoinHostPort(ip.String(), port),
+				Priority: srv.Priority,
+				Weight:   srv.Weight,
+			})
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil, &net.DNSError{Err: errNoSuchHost, Name: qname, IsNotFound: true}
+	}
+	return endpoints, nil
+}
+
+// ConsulEndpoints resolves endpoints of a service registered in Consul, see ConsulServiceName
+// and ResolveEndpoints
+func (r *Resolver) ConsulEndpoints(ctx context.Context, service, tag, datacenter string) ([]Endpoint, error) {
+	return r.ResolveEndpoints(ctx, ConsulServiceName(service, tag, datacenter, ""))
+}
+
+// KubernetesEndpoints resolves endpoints of a named port of a Kubernetes service in the default
+// cluster domain, see KubernetesServiceName and ResolveEndpoints
+func (r *Resolver) KubernetesEndpoints(ctx context.Context, port, proto, service, namespace string) ([]Endpoint, error) {
+	return r.ResolveEndpoints(ctx, KubernetesServiceName(port, proto, service, namespace, ""))
+}
+
+// srvQname returns the name of SRV records of service over proto of domain name like net.LookupSRV:
+// _service._proto.name, or name as is if service and proto are empty
+func srvQname(service, proto, name string) string {
+	if service == "" && proto == "" {
+		return name
+	}
+	return "_" + service + "._" + proto + "." + name
+}
+
+// DialService connects to an instance of service over proto ("tcp" or "udp") of domain name, the SRV
+// records are named like with net.LookupSRV. The SRV RRset is maintained like with Maintain and targets
+// are ordered per RFC 2782: by priority ascending, and randomly by weight within a priority. Targets
+// are resolved and dialed through the cache like with DialContext, failing over to the next target
+// if all addresses of one fail to connect. Returns the last dial error, or an error of type
+// *net.DNSError if there are no targets
+func (r *Resolver) DialService(ctx context.Context, service, proto, name string) (net.Conn, error) {
+	if r.Stopped() {
+		return nil, ErrStopped
+	}
+	qname := srvQname(service, proto, name)
+	r.Maintain(qname, dns.TypeSRV)
+
+	var srvs []*dns.SRV
+	for _, rr := range r.GetRecords(qname, dns.TypeSRV) {
+		// the target "." means the service is not available at the domain
+		if srv, ok := rr.(*dns.SRV); ok && srv.Target != "." {
+			srvs = append(srvs, srv)
+		}
+	}
+	if len(srvs) == 0 {
+		return nil, &net.DNSError{Err: errNoSuchHost, Name: qname, IsNotFound: true}
+	}
+
+	network := proto
+	if network == "" {
+		network = "tcp"
+	}
+	var lastErr error
+	for _, srv := range r.orderSRVs(srvs) {
+		target := strings.TrimSuffix(srv.Target, ".")
+		conn, err := r.DialContext(ctx, network, net.JoinHostPort(target, strconv.Itoa(int(srv.Port))))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+		logInfo(r.logger, r.tag, "Target of", r.clientCfg.privacy.redact(qname), "failed to connect:",
+			r.clientCfg.privacy.redact(target), r.clientCfg.privacy.redactErr(err, target))
+	}
+	return nil, lastErr
+}
+
+// orderSRVs returns srvs sorted by priority ascending, records of the same priority are picked
+// randomly in proportion to their weights, records of zero weight have a small chance to go first
+func (r *Resolver) orderSRVs(srvs []*dns.SRV) []*dns.SRV {
+	srvs = append([]*dns.SRV(nil), srvs...)
+	sort.SliceStable(srvs, func(i, j int) bool {
+		return srvs[i].Priority < srvs[j].Priority
+	})
+
+	for start := 0; start < len(srvs); {
+		end := start + 1
+		for end < len(srvs) && srvs[end].Priority == srvs[start].Priority {
+			end++
+		}
+		group := srvs[start:end]
+		sum := 0
+		for _, srv := range group {
+			sum += int(srv.Weight)
+		}
+		for i := range group[:len(group)-1] {
+			n := r.clientCfg.rnd.intn(sum + 1)
+			j := i
+			for acc := int(group[j].Weight); acc < n && j < len(group)-1; acc += int(group[j].Weight) {
+				j++
+			}
+			group[i], group[j] = group[j], group[i]
+			sum -= int(group[i].Weight)
+		}
+		start = end
+	}
+	return srvs
+}
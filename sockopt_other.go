@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package resolver
+
+import "syscall"
+
+// setBroadcast does nothing on platforms without socket options
+func setBroadcast(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package resolver
+
+import "syscall"
+
+// setBroadcast allows a socket to send broadcasts, it is used as net.ListenConfig.Control
+func setBroadcast(network, address string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
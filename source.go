@@ -0,0 +1,80 @@
+package resolver
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// TransportUDP, TransportTCP - a record set was received from a nameserver over UDP or TCP
+	TransportUDP = "udp"
+	TransportTCP = "tcp"
+
+	// TransportTLS, TransportHTTPS - a record set was received from a designated resolver
+	// over DNS over TLS or DNS over HTTPS, see WithDDR
+	TransportTLS   = "tls"
+	TransportHTTPS = "https"
+
+	// TransportSystem - a record set was obtained from the system resolver, no nameservers are set
+	TransportSystem = "system"
+
+	// TransportStatic - a record set was set by UpdateHostsFromMaping
+	TransportStatic = "static"
+
+	// TransportLLMNR - a record set was received from an LLMNR responder, see WithLLMNR
+	TransportLLMNR = "llmnr"
+
+	// TransportPeer - a record set was received from a peer resolver, see WithFederation
+	TransportPeer = "peer"
+
+	// TransportImport - a record set was imported by ImportRRs or ImportZoneText
+	TransportImport = "import"
+
+	// TransportNetBIOS - a record set was received from a NetBIOS name service responder, see WithNetBIOS
+	TransportNetBIOS = "netbios"
+)
+
+// Source - where a record set was obtained from, useful to diagnose upstreams answering differently
+type Source struct {
+	// Nameserver - the nameserver answered, empty for the system resolver and static hosts
+	Nameserver string
+
+	// Transport - one of Transport* constants
+	Transport string
+
+	// Time - the time the record set was obtained
+	Time time.Time
+}
+
+// String ...
+func (s Source) String() string {
+	if s.Nameserver == "" {
+		return s.Transport
+	}
+	return s.Nameserver + "/" + s.Transport
+}
+
+// sources - sources of addresses of a host
+type sources struct {
+	mu         sync.RWMutex
+	src4, src6 Source
+}
+
+// set sets sources of families reloaded
+func (s *sources) set(family Family, src4, src6 Source) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if family.hasV4() {
+		s.src4 = src4
+	}
+	if family.hasV6() {
+		s.src6 = src6
+	}
+}
+
+// get ...
+func (s *sources) get() (Source, Source) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.src4, s.src6
+}
@@ -0,0 +1,211 @@
+package resolver
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// CacheEvent - a kind of host lookup from the cache point of view
+type CacheEvent int
+
+const (
+	// CacheHit - addresses were served from the cache
+	CacheHit CacheEvent = iota
+	// CacheBlocked - the lookup blocked waiting for the first resolution of the host
+	CacheBlocked
+	// CacheHostCreated - the host did not exist and was created non-explicitly,
+	// such a lookup is followed by CacheBlocked
+	CacheHostCreated
+)
+
+// String ...
+func (e CacheEvent) String() string {
+	switch e {
+	case CacheHit:
+		return "hit"
+	case CacheBlocked:
+		return "blocked"
+	case CacheHostCreated:
+		return "created"
+	}
+	return "unknown"
+}
+
+// Stats - resolver statistics
+type Stats struct {
+	// Hosts - the number of maintained hosts
+	Hosts int
+
+	// CacheHits - the number of lookups served from the cache
+	CacheHits uint64
+
+	// CacheBlocked - the number of lookups blocked on the first resolution of a host
+	CacheBlocked uint64
+
+	// HostsCreated - the number of hosts created non-explicitly by lookups
+	HostsCreated uint64
+
+	// ReadyWait - the total time lookups blocked waiting for first resolutions of hosts,
+	// the cold-start penalty avoided by adding hosts in advance or ImportRRs
+	ReadyWait time.Duration
+
+	// EdnsFallbacks - the number of queries repeated without EDNS on FORMERR or NOTIMP
+	EdnsFallbacks uint64
+
+	// TcpFallbacks - the number of queries repeated over TCP on truncated or suspicious UDP responses
+	TcpFallbacks uint64
+
+	// Refreshes - the number of resolutions of hosts done by the scheduler, including first ones
+	Refreshes uint64
+
+	// SuspendedRefreshes - the number of refreshes suspended by WithLazyRefresh
+	SuspendedRefreshes uint64
+
+	// CoalescedRefreshes - the number of refreshes moved earlier to join refreshes of their apex,
+	// see WithApexCoalescing
+	CoalescedRefreshes uint64
+
+	// CrossCheckDivergences - the number of divergent answers found by the cross-check mode
+	CrossCheckDivergences uint64
+
+	// UnexpectedAnswers - the number of answers rejected for addresses outside of Policy.ExpectedPrefixes
+	UnexpectedAnswers uint64
+
+	// ScheduledRefreshes - the number of refreshes waiting for their time or for a worker
+	ScheduledRefreshes int
+
+	// RunningRefreshes - the number of goroutines running refreshes, at most WithRefreshWorkers
+	RunningRefreshes int
+
+	// RecordLoops - the number of RRsets maintained by Maintain, the scheduler refreshes them
+	RecordLoops int
+
+	// Waiters - the number of goroutines waiting for first resolutions of hosts
+	// by lookups and AddHostAsync or watching hosts by Watch
+	Waiters int64
+
+	// PendingQueries - the number of queries to nameservers in flight
+	PendingQueries int64
+
+	// Conns - the number of open connections to nameservers and responders per transport
+	Conns map[string]int
+}
+
+// stats ...
+type stats struct {
+	cacheHits    uint64
+	cacheBlocked uint64
+	hostsCreated uint64
+}
+
+// countAccess ...
+func (s *stats) countAccess(hostName string, h *host, hook func(string, CacheEvent)) CacheEvent {
+	ev := CacheHit
+	if h.isReady() {
+		atomic.AddUint64(&s.cacheHits, 1)
+	} else {
+		atomic.AddUint64(&s.cacheBlocked, 1)
+		ev = CacheBlocked
+	}
+	if hook != nil {
+		hook(hostName, ev)
+	}
+	return ev
+}
+
+// countCreated ...
+func (s *stats) countCreated(hostName string, hook func(string, CacheEvent)) {
+	atomic.AddUint64(&s.hostsCreated, 1)
+	if hook != nil {
+		hook(hostName, CacheHostCreated)
+	}
+}
+
+// WithCacheHook - sets a function called on every cache event of GetNextIP* lookups.
+// The function is called synchronously and must not block
+func (r *Resolver) WithCacheHook(hook func(hostName string, ev CacheEvent)) *Resolver {
+	r.mu.Lock()
+	r.cacheHook = hook
+	r.mu.Unlock()
+	return r
+}
+
+// Stats returns resolver statistics
+func (r *Resolver) Stats() Stats {
+	r.mu.RLock()
+	hosts, records := len(r.hosts), len(r.records)
+	r.mu.RUnlock()
+	scheduled, running := r.scheduler.counts()
+
+	return Stats{
+		Hosts:                 hosts,
+		CacheHits:             atomic.LoadUint64(&r.stats.cacheHits),
+		CacheBlocked:          atomic.LoadUint64(&r.stats.cacheBlocked),
+		HostsCreated:          atomic.LoadUint64(&r.stats.hostsCreated),
+		ReadyWait:             r.scheduler.getReadyWait(),
+		EdnsFallbacks:         atomic.LoadUint64(&r.clientCfg.ednsFallbacks),
+		TcpFallbacks:          atomic.LoadUint64(&r.clientCfg.tcpFallbacks),
+		Refreshes:             r.scheduler.getRefreshes(),
+		SuspendedRefreshes:    r.scheduler.getSuspensions(),
+		CoalescedRefreshes:    r.scheduler.getCoalesced(),
+		CrossCheckDivergences: atomic.LoadUint64(&r.clientCfg.crossCheck.divergences),
+		UnexpectedAnswers:     atomic.LoadUint64(&r.clientCfg.unexpectedAnswers),
+		ScheduledRefreshes:    scheduled,
+		RunningRefreshes:      running,
+		RecordLoops:           records,
+		Waiters:               atomic.LoadInt64(&r.scheduler.waiters),
+		PendingQueries:        atomic.LoadInt64(&r.clientCfg.pending),
+		Conns:                 r.clientCfg.conns.get(),
+	}
+}
+
+// HostStat - a maintained host state
+type HostStat struct {
+	Host string
+
+	// Lookups - the number of lookups of the host addresses
+	Lookups uint64
+
+	// TTL4, TTL6 - time left until IPv4 and IPv6 addresses expire
+	TTL4 time.Duration
+	TTL6 time.Duration
+
+	// Expire4, Expire6 - expiration times of IPv4 and IPv6 addresses,
+	// zero for static hosts and families never resolved
+	Expire4 time.Time
+	Expire6 time.Time
+
+	// Source4, Source6 - where IPv4 and IPv6 addresses were obtained from
+	Source4 Source
+	Source6 Source
+
+	// ReadyWait - the total time lookups blocked waiting for the first resolution of the host
+	ReadyWait time.Duration
+}
+
+// HostStats returns states of all maintained hosts sorted by name
+func (r *Resolver) HostStats() []HostStat {
+	r.mu.RLock()
+	ret := make([]HostStat, 0, len(r.hosts))
+	for hostName, h := range r.hosts {
+		src4, src6 := h.sources.get()
+		ret = append(ret, HostStat{
+			Host:      hostName,
+			Lookups:   h.getLookups(),
+			TTL4:      time.Duration(h.remainingTtl(FamilyV4)) * time.Second,
+			TTL6:      time.Duration(h.remainingTtl(FamilyV6)) * time.Second,
+			Expire4:   h.expiry(FamilyV4),
+			Expire6:   h.expiry(FamilyV6),
+			Source4:   src4,
+			Source6:   src6,
+			ReadyWait: h.getReadyWait(),
+		})
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Host < ret[j].Host
+	})
+	return ret
+}
@@ -0,0 +1,451 @@
+package resolver
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HistoryStore - a persistent storage of query logs and address changes, see WithHistoryStore.
+// Implementations must be safe for concurrent use, e.g. a SQLite or bbolt database
+type HistoryStore interface {
+	AddQuery(rec QueryLogRecord) error
+	AddChange(ch HostChange) error
+
+	// Queries, Changes return records matching q, the oldest first
+	Queries(q HistoryQuery) ([]QueryLogRecord, error)
+	Changes(q HistoryQuery) ([]HostChange, error)
+}
+
+// HostChange - a change of addresses of a host
+type HostChange struct {
+	Host string `json:"host"`
+	AddressChange
+}
+
+// HistoryQuery - a filter of records of a HistoryStore, zero fields match all records
+type HistoryQuery struct {
+	Host  string
+	Since time.Time
+	Until time.Time
+
+	// Limit - the max number of the latest records returned
+	Limit int
+}
+
+// match ...
+func (q HistoryQuery) match(hostName string, t time.Time) bool {
+	if q.Host != "" && !strings.EqualFold(strings.TrimSuffix(hostName, "."), strings.TrimSuffix(q.Host, ".")) {
+		return false
+	}
+	if !q.Since.IsZero() && t.Before(q.Since) {
+		return false
+	}
+	return q.Until.IsZero() || t.Before(q.Until)
+}
+
+// WithHistoryStore - writes query logs and address changes of hosts created after this call to store,
+// sampleRate is the share of query log records written from 0 to 1 and it applies to WithQueryLog too.
+// Records of the store are exposed by DebugHandler
+func (r *Resolver) WithHistoryStore(store HistoryStore, sampleRate float64) *Resolver {
+	r.mu.Lock()
+	r.store = store
+	r.mu.Unlock()
+
+	l := r.clientCfg.qlog
+	l.mu.Lock()
+	prev := l.fn
+	l.mu.Unlock()
+	return r.WithQueryLogFunc(func(rec QueryLogRecord) {
+		if prev != nil {
+			prev(rec)
+		}
+		if err := store.AddQuery(rec); err != nil {
+			logError(r.logger, r.tag, "Error storing query log record", err)
+		}
+	}, sampleRate)
+}
+
+const (
+	// defaultHistoryMaxAge, defaultHistoryMaxRecords - limits of a Retention with zero fields
+	defaultHistoryMaxAge     = 7 * 24 * time.Hour
+	defaultHistoryMaxRecords = 100000
+
+	// storeFlushInterval - how often a FileHistoryStore writes added records to its file
+	storeFlushInterval = time.Second
+
+	// storeMaxPending - the number of added records that makes a FileHistoryStore write them before
+	// storeFlushInterval passes
+	storeMaxPending = 1000
+)
+
+// Retention - limits of records kept by a FileHistoryStore, zero fields take defaults: 7 days
+// and 100000 records of each kind, negative fields mean no limit
+type Retention struct {
+	// MaxAge - records older than this are dropped
+	MaxAge time.Duration
+
+	// MaxQueries, MaxChanges - the max numbers of query log records and address changes kept
+	MaxQueries int
+	MaxChanges int
+}
+
+// withDefaults ...
+func (r Retention) withDefaults() Retention {
+	if r.MaxAge == 0 {
+		r.MaxAge = defaultHistoryMaxAge
+	}
+	if r.MaxQueries == 0 {
+		r.MaxQueries = defaultHistoryMaxRecords
+	}
+	if r.MaxChanges == 0 {
+		r.MaxChanges = defaultHistoryMaxRecords
+	}
+	return r
+}
+
+// storeEntry - a line of a FileHistoryStore file
+type storeEntry struct {
+	Query  *QueryLogRecord `json:"query,omitempty"`
+	Change *HostChange     `json:"change,omitempty"`
+}
+
+// FileHistoryStore - a HistoryStore keeping records in memory and appending them to a file
+// of JSON lines, the file is compacted per the retention limits as it grows.
+// Records are written by a background goroutine, so adding them does no file I/O.
+// It needs no external dependencies, so it suits routers without a database
+type FileHistoryStore struct {
+	clock     Clock
+	retention Retention
+
+	// mu guards records, pending and closed
+	mu      sync.Mutex
+	queries []QueryLogRecord
+	changes []HostChange
+	pending []storeEntry
+	closed  bool
+
+	// writeErr - the last error of writing the file, returned by the next add
+	writeErr error
+
+	// fileMu guards the file
+	fileMu sync.Mutex
+	path   string
+	f      *os.File
+	w      *bufio.Writer
+
+	// lines - the number of lines in the file
+	lines int
+
+	wakeCh chan struct{}
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// NewFileHistoryStore opens or creates the file at path and loads records kept there
+func NewFileHistoryStore(path string, retention Retention) (*FileHistoryStore, error) {
+	return NewFileHistoryStoreWithClock(path, retention, realClock{})
+}
+
+// NewFileHistoryStoreWithClock - NewFileHistoryStore using clock to apply Retention.MaxAge and to time writes
+func NewFileHistoryStoreWithClock(path string, retention Retention, clock Clock) (*FileHistoryStore, error) {
+	s := &FileHistoryStore{
+		clock:     clock,
+		retention: retention.withDefaults(),
+		path:      path,
+		wakeCh:    make(chan struct{}, 1),
+		stopCh:    make(chan struct{}),
+		doneCh:    make(chan struct{}),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.prune()
+	if err := s.compact(s.queries, s.changes); err != nil {
+		return nil, err
+	}
+	go s.writeLoop()
+	return s, nil
+}
+
+// load reads records of the file skipping malformed lines, e.g. a line cut by a power loss
+func (s *FileHistoryStore) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var e storeEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		s.append(e)
+	}
+	return scanner.Err()
+}
+
+// AddQuery ...
+func (s *FileHistoryStore) AddQuery(rec QueryLogRecord) error {
+	return s.add(storeEntry{Query: &rec})
+}
+
+// AddChange ...
+func (s *FileHistoryStore) AddChange(ch HostChange) error {
+	return s.add(storeEntry{Change: &ch})
+}
+
+// add appends e to memory and queues it for writeLoop, an error of a previous write is returned
+func (s *FileHistoryStore) add(e storeEntry) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return os.ErrClosed
+	}
+	s.append(e)
+	s.prune()
+	s.pending = append(s.pending, e)
+	wake := len(s.pending) >= storeMaxPending
+	err := s.writeErr
+	s.writeErr = nil
+	s.mu.Unlock()
+
+	if wake {
+		select {
+		case s.wakeCh <- struct{}{}:
+		default:
+		}
+	}
+	return err
+}
+
+// append ...
+func (s *FileHistoryStore) append(e storeEntry) {
+	if e.Query != nil {
+		s.queries = append(s.queries, *e.Query)
+	}
+	if e.Change != nil {
+		s.changes = append(s.changes, *e.Change)
+	}
+}
+
+// prune drops records exceeding the retention limits, s.mu must be held
+func (s *FileHistoryStore) prune() {
+	if s.retention.MaxAge > 0 {
+		since := s.clock.Now().Add(-s.retention.MaxAge)
+		s.queries = s.queries[sort.Search(len(s.queries), func(i int) bool {
+			return !s.queries[i].Time.Before(since)
+		}):]
+		s.changes = s.changes[sort.Search(len(s.changes), func(i int) bool {
+			return !s.changes[i].Time.Before(since)
+		}):]
+	}
+	// dropped records are freed once append reallocates the slices
+	if n := s.retention.MaxQueries; n > 0 && len(s.queries) > n {
+		s.queries = s.queries[len(s.queries)-n:]
+	}
+	if n := s.retention.MaxChanges; n > 0 && len(s.changes) > n {
+		s.changes = s.changes[len(s.changes)-n:]
+	}
+}
+
+// writeLoop writes added records every storeFlushInterval or once storeMaxPending of them are queued
+func (s *FileHistoryStore) writeLoop() {
+	defer close(s.doneCh)
+	ticker := s.clock.NewTicker(storeFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+		case <-s.wakeCh:
+		case <-s.stopCh:
+			s.setWriteErr(s.flush())
+			return
+		}
+		s.setWriteErr(s.flush())
+	}
+}
+
+// setWriteErr ...
+func (s *FileHistoryStore) setWriteErr(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.writeErr = err
+	s.mu.Unlock()
+}
+
+// flush writes queued records to the file, the file is compacted instead when it has
+// twice as many lines as records kept
+func (s *FileHistoryStore) flush() error {
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+
+	// queued records and the snapshot to compact are taken together, so the file never gets a record twice
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	queries, changes := s.queries, s.changes
+	s.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if s.f == nil || s.lines+len(pending) > 2*(len(queries)+len(changes)) && s.lines+len(pending) > 1000 {
+		return s.compactLocked(queries, changes)
+	}
+	enc := json.NewEncoder(s.w)
+	for _, e := range pending {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+		s.lines++
+	}
+	return s.w.Flush()
+}
+
+// compact ...
+func (s *FileHistoryStore) compact(queries []QueryLogRecord, changes []HostChange) error {
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+	return s.compactLocked(queries, changes)
+}
+
+// compactLocked rewrites the file with records kept and reopens it for appending, s.fileMu must be held
+func (s *FileHistoryStore) compactLocked(queries []QueryLogRecord, changes []HostChange) error {
+	if s.f != nil {
+		s.w.Flush()
+		s.f.Close()
+		s.f = nil
+	}
+
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for i := range queries {
+		if err := enc.Encode(storeEntry{Query: &queries[i]}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	for i := range changes {
+		if err := enc.Encode(storeEntry{Change: &changes[i]}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	s.f, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.w = bufio.NewWriter(s.f)
+	s.lines = len(queries) + len(changes)
+	return nil
+}
+
+// historyRange returns the bounds of records of n sorted by time matching q.Since and q.Until
+func historyRange(n int, at func(i int) time.Time, q HistoryQuery) (lo, hi int) {
+	hi = n
+	if !q.Since.IsZero() {
+		lo = sort.Search(n, func(i int) bool { return !at(i).Before(q.Since) })
+	}
+	if !q.Until.IsZero() {
+		hi = sort.Search(n, func(i int) bool { return !at(i).Before(q.Until) })
+	}
+	if hi < lo {
+		hi = lo
+	}
+	return lo, hi
+}
+
+// Queries returns records matching q, only the time range of q is scanned and with a limit
+// the scan stops at the latest matching records
+func (s *FileHistoryStore) Queries(q HistoryQuery) ([]QueryLogRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lo, hi := historyRange(len(s.queries), func(i int) time.Time { return s.queries[i].Time }, q)
+	ret := make([]QueryLogRecord, 0)
+	for i := hi - 1; i >= lo && (q.Limit <= 0 || len(ret) < q.Limit); i-- {
+		if q.match(s.queries[i].Name, s.queries[i].Time) {
+			ret = append(ret, s.queries[i])
+		}
+	}
+	for i, j := 0, len(ret)-1; i < j; i, j = i+1, j-1 {
+		ret[i], ret[j] = ret[j], ret[i]
+	}
+	return ret, nil
+}
+
+// Changes returns records matching q the way Queries does
+func (s *FileHistoryStore) Changes(q HistoryQuery) ([]HostChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lo, hi := historyRange(len(s.changes), func(i int) time.Time { return s.changes[i].Time }, q)
+	ret := make([]HostChange, 0)
+	for i := hi - 1; i >= lo && (q.Limit <= 0 || len(ret) < q.Limit); i-- {
+		if q.match(s.changes[i].Host, s.changes[i].Time) {
+			ret = append(ret, s.changes[i])
+		}
+	}
+	for i, j := 0, len(ret)-1; i < j; i, j = i+1, j-1 {
+		ret[i], ret[j] = ret[j], ret[i]
+	}
+	return ret, nil
+}
+
+// Close writes queued records and closes the file
+func (s *FileHistoryStore) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stopCh)
+	<-s.doneCh
+
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+	s.mu.Lock()
+	err := s.writeErr
+	s.mu.Unlock()
+	if s.f == nil {
+		return err
+	}
+	if ferr := s.w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.f = nil
+	return err
+}
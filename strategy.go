@@ -0,0 +1,103 @@
+package resolver
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NameserverStrategy - an order nameservers are tried in
+type NameserverStrategy int32
+
+const (
+	// NameserverRoundRobin - nameservers are rotated, the rotation advances when a nameserver fails,
+	// it is the default
+	NameserverRoundRobin NameserverStrategy = iota
+	// NameserverOrdered - nameservers are always tried in the order they were set
+	NameserverOrdered
+	// NameserverRandom - each query starts with a random nameserver
+	NameserverRandom
+	// NameserverFastest - nameservers are tried by the smoothed RTT, nameservers not queried yet
+	// go first, degraded and down nameservers go last, see NameserverStats
+	NameserverFastest
+)
+
+// WithNameserverStrategy - sets the order nameservers are tried in
+func (r *Resolver) WithNameserverStrategy(s NameserverStrategy) *Resolver {
+	atomic.StoreInt32((*int32)(&r.clientCfg.strategy), int32(s))
+	return r
+}
+
+// getStrategy ...
+func (c *clientConfig) getStrategy() NameserverStrategy {
+	return NameserverStrategy(atomic.LoadInt32((*int32)(&c.strategy)))
+}
+
+// lockedRand - a random source safe for concurrent use
+type lockedRand struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// intn ...
+func (l *lockedRand) intn(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rnd == nil {
+		l.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return l.rnd.Intn(n)
+}
+
+// nameServersOrder returns the nameservers in the order to try them in,
+// nameservers found hijacking by WithCanary go last
+func (d *dnsClient) nameServersOrder() []string {
+	return d.demoteHijacking(d.strategyOrder())
+}
+
+// strategyOrder returns the nameservers in the order of the strategy
+func (d *dnsClient) strategyOrder() []string {
+	d.RLock()
+	nameServers := append([]string(nil), d.nameServers...)
+	d.RUnlock()
+
+	n := len(nameServers)
+	if n < 2 {
+		return nameServers
+	}
+
+	switch d.cfg.getStrategy() {
+	case NameserverOrdered:
+		return nameServers
+	case NameserverRandom:
+		return rotate(nameServers, d.cfg.rnd.intn(n))
+	case NameserverFastest:
+		stats := make(map[string]NameserverStat, n)
+		for _, nServer := range nameServers {
+			stats[nServer] = d.cfg.nsStats.get(nServer)
+		}
+		sort.SliceStable(nameServers, func(i, j int) bool {
+			si, sj := stats[nameServers[i]], stats[nameServers[j]]
+			if failing(si.State) != failing(sj.State) {
+				return si.State < sj.State
+			}
+			return si.SRTT < sj.SRTT
+		})
+		return nameServers
+	}
+	return rotate(nameServers, int(atomic.LoadUint64(&d.nsCounter)%uint64(n)))
+}
+
+// failing ...
+func failing(s NameserverState) bool {
+	return s == NameserverDegraded || s == NameserverDown
+}
+
+// rotate returns list rotated to start at idx
+func rotate(list []string, idx int) []string {
+	ret := make([]string, 0, len(list))
+	ret = append(ret, list[idx:]...)
+	return append(ret, list[:idx]...)
+}
@@ -0,0 +1,162 @@
+package resolver
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"strings"
+)
+
+var (
+	// resolvConfPath - the resolver configuration of the system
+	resolvConfPath = "/etc/resolv.conf"
+
+	// stubUpstreamPaths - files listing nameservers behind local stub resolvers in the order they are checked:
+	// systemd-resolved, NetworkManager with and without its dnsmasq plugin, dnsmasq of Debian
+	stubUpstreamPaths = []string{
+		"/run/systemd/resolve/resolv.conf",
+		"/run/NetworkManager/no-stub-resolv.conf",
+		"/run/NetworkManager/resolv.conf",
+		"/var/run/dnsmasq/resolv.conf",
+	}
+
+	// dnsmasqConfPath - the dnsmasq configuration, its server= lines are upstreams
+	dnsmasqConfPath = "/etc/dnsmasq.conf"
+)
+
+// errNoSystemNameservers - the system configuration lists no nameservers
+var errNoSystemNameservers = errors.New("no nameservers in the system configuration")
+
+// IsStubResolver reports whether nameServer is a local stub resolver, such as systemd-resolved
+// on 127.0.0.53 or dnsmasq on 127.0.0.1, which hides TTLs of upstream nameservers
+func IsStubResolver(nameServer string) bool {
+	host := nameServer
+	if h, _, err := net.SplitHostPort(nameServer); err == nil {
+		host = h
+	}
+	if i := strings.LastIndexByte(host, '%'); i > 0 {
+		host = host[:i]
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// SystemNameservers returns nameservers of the system configuration: /etc/resolv.conf on Unix,
+// nameservers of the network adapters on Windows and default resolvers of scutil --dns on macOS,
+// in the order the system tries them. If bypassStub is set
+// and the system uses a local stub resolver, nameservers behind the stub are returned when
+// they are found in configuration files of systemd-resolved, NetworkManager or dnsmasq,
+// otherwise the stub is returned
+func SystemNameservers(bypassStub bool) ([]string, error) {
+	nameServers, err := systemNameservers()
+	if err != nil {
+		return nil, err
+	}
+	if len(nameServers) == 0 {
+		return nil, errNoSystemNameservers
+	}
+	if !bypassStub || !hasStub(nameServers) {
+		return nameServers, nil
+	}
+	if upstreams := stubUpstreams(); len(upstreams) > 0 {
+		return upstreams, nil
+	}
+	return nameServers, nil
+}
+
+// WithSystemNameservers - sets nameservers of the system configuration, see SystemNameservers.
+// A stub resolver used is logged, queries to it get TTLs of its cache instead of upstream TTLs
+func (r *Resolver) WithSystemNameservers(bypassStub bool) *Resolver {
+	nameServers, err := SystemNameservers(bypassStub)
+	if err != nil {
+		logError(r.logger, r.tag, "Error getting system nameservers", err)
+		return r
+	}
+	if hasStub(nameServers) {
+		logInfo(r.logger, r.tag, "System nameservers include a local stub resolver:", nameServers)
+	}
+	return r.WithNameservers(nameServers...)
+}
+
+// hasStub ...
+func hasStub(nameServers []string) bool {
+	for _, nServer := range nameServers {
+		if IsStubResolver(nServer) {
+			return true
+		}
+	}
+	return false
+}
+
+// stubUpstreams returns the first list of nameservers behind a local stub resolver
+// without stubs found in the known locations
+func stubUpstreams() []string {
+	for _, path := range stubUpstreamPaths {
+		if nameServers, err := readResolvConf(path); err == nil && len(nameServers) > 0 && !hasStub(nameServers) {
+			return nameServers
+		}
+	}
+	if nameServers, err := readDnsmasqServers(dnsmasqConfPath); err == nil && len(nameServers) > 0 {
+		return nameServers
+	}
+	return nil
+}
+
+// readResolvConf returns nameservers listed in a file in the resolv.conf format,
+// IPv6 nameservers with zones are skipped as they can not be set by WithNameservers
+func readResolvConf(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var nameServers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		if net.ParseIP(fields[1]) != nil {
+			nameServers = append(nameServers, fields[1])
+		}
+	}
+	return nameServers, scanner.Err()
+}
+
+// readDnsmasqServers returns upstream nameservers of dnsmasq set by server=ip[#port] lines,
+// servers of specific domains (server=/domain/ip) are skipped
+func readDnsmasqServers(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var nameServers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "server=") {
+			continue
+		}
+		value := strings.TrimPrefix(line, "server=")
+		if strings.HasPrefix(value, "/") {
+			continue
+		}
+		host, port := value, ""
+		if i := strings.IndexByte(value, '#'); i >= 0 {
+			host, port = value[:i], value[i+1:]
+		}
+		if net.ParseIP(host) == nil {
+			continue
+		}
+		if port != "" {
+			host = net.JoinHostPort(host, port)
+		}
+		nameServers = append(nameServers, host)
+	}
+	return nameServers, scanner.Err()
+}
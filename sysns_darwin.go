@@ -0,0 +1,101 @@
+//go:build darwin
+
+package resolver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"math"
+	"net"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scutilTimeout - the time scutil --dns is allowed to run
+const scutilTimeout = 5 * time.Second
+
+// scutilResolver - a resolver section of scutil --dns
+type scutilResolver struct {
+	nameServers []string
+	domain      bool
+	order       int
+}
+
+// systemNameservers returns nameservers of the default resolvers of the SystemConfiguration framework
+// as listed by scutil --dns, ordered by their service order. Resolvers of specific domains, such as
+// the ones of VPNs with split DNS or of mDNS, are skipped. /etc/resolv.conf is used if scutil fails
+func systemNameservers() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), scutilTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "scutil", "--dns").Output()
+	if err != nil {
+		return readResolvConf(resolvConfPath)
+	}
+	if nameServers := parseScutilDns(out); len(nameServers) > 0 {
+		return nameServers, nil
+	}
+	return readResolvConf(resolvConfPath)
+}
+
+// parseScutilDns returns nameservers of the default resolvers of the unscoped configuration
+// of scutil --dns output
+func parseScutilDns(out []byte) []string {
+	var resolvers []*scutilResolver
+	var cur *scutilResolver
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "DNS configuration (") {
+			// scoped configurations repeat the resolvers per interface
+			break
+		}
+		if strings.HasPrefix(line, "resolver #") {
+			cur = &scutilResolver{order: math.MaxInt32}
+			resolvers = append(resolvers, cur)
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch {
+		case strings.HasPrefix(key, "nameserver["):
+			if net.ParseIP(value) != nil {
+				cur.nameServers = append(cur.nameServers, value)
+			}
+		case key == "domain":
+			cur.domain = true
+		case key == "order":
+			if order, err := strconv.Atoi(value); err == nil {
+				cur.order = order
+			}
+		}
+	}
+
+	sort.SliceStable(resolvers, func(i, j int) bool {
+		return resolvers[i].order < resolvers[j].order
+	})
+	var nameServers []string
+	seen := make(map[string]bool)
+	for _, res := range resolvers {
+		if res.domain {
+			continue
+		}
+		for _, nServer := range res.nameServers {
+			if !seen[nServer] {
+				seen[nServer] = true
+				nameServers = append(nameServers, nServer)
+			}
+		}
+	}
+	return nameServers
+}
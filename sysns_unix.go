@@ -0,0 +1,8 @@
+//go:build !windows && !darwin
+
+package resolver
+
+// systemNameservers returns nameservers of /etc/resolv.conf
+func systemNameservers() ([]string, error) {
+	return readResolvConf(resolvConfPath)
+}
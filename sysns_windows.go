@@ -0,0 +1,75 @@
+//go:build windows
+
+package resolver
+
+import (
+	"net"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// systemNameservers returns nameservers of the network adapters which are up, in the order
+// of the adapters returned by GetAdaptersAddresses, which follows the binding order
+func systemNameservers() ([]string, error) {
+	adapters, err := adaptersAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	var nameServers []string
+	seen := make(map[string]bool)
+	for aa := adapters; aa != nil; aa = aa.Next {
+		if aa.OperStatus != windows.IfOperStatusUp || aa.IfType == windows.IF_TYPE_SOFTWARE_LOOPBACK {
+			continue
+		}
+		for dns := aa.FirstDnsServerAddress; dns != nil; dns = dns.Next {
+			ip := dns.Address.IP()
+			if ip == nil || ip.IsLinkLocalUnicast() || isDeprecatedSiteLocalDns(ip) {
+				continue
+			}
+			if nServer := ip.String(); !seen[nServer] {
+				seen[nServer] = true
+				nameServers = append(nameServers, nServer)
+			}
+		}
+	}
+	return nameServers, nil
+}
+
+// adaptersAddresses returns the list of adapters, growing the buffer until it fits
+func adaptersAddresses() (*windows.IpAdapterAddresses, error) {
+	l := uint32(15000)
+	for {
+		b := make([]byte, l)
+		aa := (*windows.IpAdapterAddresses)(unsafe.Pointer(&b[0]))
+		err := windows.GetAdaptersAddresses(windows.AF_UNSPEC, windows.GAA_FLAG_INCLUDE_PREFIX, 0, aa, &l)
+		if err == nil {
+			if l == 0 {
+				return nil, nil
+			}
+			return aa, nil
+		}
+		if err.(windows.Errno) != windows.ERROR_BUFFER_OVERFLOW {
+			return nil, os.NewSyscallError("getadaptersaddresses", err)
+		}
+		if l <= uint32(len(b)) {
+			return nil, os.NewSyscallError("getadaptersaddresses", err)
+		}
+	}
+}
+
+// isDeprecatedSiteLocalDns reports whether ip is one of fec0:0:0:ffff::1-3, the well-known
+// site-local nameservers Windows reports for adapters without configured IPv6 nameservers
+func isDeprecatedSiteLocalDns(ip net.IP) bool {
+	if ip.To4() != nil {
+		return false
+	}
+	for i := byte(1); i <= 3; i++ {
+		if ip.Equal(net.IP{0xfe, 0xc0, 0, 0, 0, 0, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, i}) {
+			return true
+		}
+	}
+	return false
+}
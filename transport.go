@@ -0,0 +1,32 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// transportFailureCooldown - addresses failed to connect by a wrapped transport are skipped for this duration
+const transportFailureCooldown = 30 * time.Second
+
+// WrapTransport returns a copy of rt dialing hosts through the cache: addresses of a host are tried
+// in rotation order failing over to the next one, and addresses failed to connect are marked bad
+// for 30 seconds like with MarkIPBad. nil means http.DefaultTransport. Only *http.Transport can be
+// wrapped, other round trippers are returned as is; DialTLSContext of rt is kept if set
+func (r *Resolver) WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		logError(r.logger, r.tag, "Can not wrap transport, it is not *http.Transport:", rt)
+		return rt
+	}
+
+	t = t.Clone()
+	t.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return r.dial(ctx, network, address, transportFailureCooldown)
+	}
+	return t
+}
@@ -0,0 +1,440 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// rootAnchorDS - the DS record of the root KSK-2017 used when no trust anchors file is given
+	rootAnchorDS = ". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"
+
+	// anchorHoldDown - the RFC 5011 add hold-down time
+	anchorHoldDown = 30 * 24 * time.Hour
+
+	// anchorMinRefresh, anchorMaxRefresh - bounds of the RFC 5011 active refresh interval
+	anchorMinRefresh = time.Hour
+	anchorMaxRefresh = 15 * 24 * time.Hour
+
+	// dnskeyFlagRevoke - the REVOKE bit of DNSKEY flags
+	dnskeyFlagRevoke = 0x0080
+)
+
+// TrustAnchorState - an RFC 5011 trust anchor state
+type TrustAnchorState int
+
+const (
+	// TrustAnchorValid - the key is trusted
+	TrustAnchorValid TrustAnchorState = iota
+	// TrustAnchorAddPend - a new key waits for the add hold-down to expire
+	TrustAnchorAddPend
+	// TrustAnchorMissing - a trusted key is absent from the zone DNSKEY RRset
+	TrustAnchorMissing
+	// TrustAnchorRevoked - the key was revoked and is no longer trusted
+	TrustAnchorRevoked
+)
+
+// String ...
+func (s TrustAnchorState) String() string {
+	switch s {
+	case TrustAnchorValid:
+		return "valid"
+	case TrustAnchorAddPend:
+		return "addpend"
+	case TrustAnchorMissing:
+		return "missing"
+	case TrustAnchorRevoked:
+		return "revoked"
+	}
+	return "unknown"
+}
+
+// TrustAnchor - a DNSSEC trust anchor with its RFC 5011 state
+type TrustAnchor struct {
+	Zone   string
+	KeyTag uint16
+	State  TrustAnchorState
+
+	// Since - time of the last state change
+	Since time.Time
+
+	// Key - the anchor key, nil if the anchor was configured as DS and its key has not been seen yet
+	Key *dns.DNSKEY
+
+	// DS - the anchor DS record, nil if the anchor was configured as DNSKEY
+	DS *dns.DS
+}
+
+// trusted reports whether the anchor may be used to validate a DNSKEY RRset
+func (a *TrustAnchor) trusted() bool {
+	return a.State == TrustAnchorValid || a.State == TrustAnchorMissing
+}
+
+// matches reports whether key is the anchor key ignoring the REVOKE bit
+func (a *TrustAnchor) matches(key *dns.DNSKEY) bool {
+	k := *key
+	k.Flags &^= dnskeyFlagRevoke
+	if a.Key != nil {
+		ak := *a.Key
+		ak.Flags &^= dnskeyFlagRevoke
+		return ak.Algorithm == k.Algorithm && ak.Protocol == k.Protocol && ak.Flags == k.Flags &&
+			ak.PublicKey == k.PublicKey
+	}
+	if a.DS != nil && a.DS.KeyTag == k.KeyTag() {
+		ds := k.ToDS(a.DS.DigestType)
+		return ds != nil && strings.EqualFold(ds.Digest, a.DS.Digest)
+	}
+	return false
+}
+
+// trustAnchors - trust anchors and negative trust anchors
+type trustAnchors struct {
+	mu      sync.RWMutex
+	anchors map[string][]*TrustAnchor
+
+	// negative - negative trust anchors: zone -> expiration time
+	negative map[string]time.Time
+
+	// clock - a source of time
+	clock Clock
+}
+
+// add ...
+func (t *trustAnchors) add(a *TrustAnchor) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.anchors == nil {
+		t.anchors = make(map[string][]*TrustAnchor)
+	}
+	t.anchors[a.Zone] = append(t.anchors[a.Zone], a)
+}
+
+// zones ...
+func (t *trustAnchors) zones() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	zones := make([]string, 0, len(t.anchors))
+	for zone := range t.anchors {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	return zones
+}
+
+// list ...
+func (t *trustAnchors) list() []TrustAnchor {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var ret []TrustAnchor
+	for _, anchors := range t.anchors {
+		for _, a := range anchors {
+			ret = append(ret, *a)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Zone != ret[j].Zone {
+			return ret[i].Zone < ret[j].Zone
+		}
+		return ret[i].KeyTag < ret[j].KeyTag
+	})
+	return ret
+}
+
+// isNegative reports whether name is at or below a zone with an active negative trust anchor
+func (t *trustAnchors) isNegative(name string) bool {
+	name = dns.Fqdn(strings.ToLower(name))
+	now := t.clock.Now()
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for zone, expire := range t.negative {
+		if now.Before(expire) && dns.IsSubDomain(zone, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// update applies the RFC 5011 rules to the anchors of zone given a validated DNSKEY RRset
+func (t *trustAnchors) update(zone string, keys []*dns.DNSKEY, revoked map[uint16]bool, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	anchors := t.anchors[zone]
+	seen := make(map[*TrustAnchor]bool)
+	for _, key := range keys {
+		if key.Flags&dns.SEP == 0 {
+			continue
+		}
+
+		var anchor *TrustAnchor
+		for _, a := range anchors {
+			if a.matches(key) {
+				anchor = a
+				break
+			}
+		}
+
+		if anchor == nil {
+			if key.Flags&dnskeyFlagRevoke != 0 {
+				continue
+			}
+			anchor = &TrustAnchor{Zone: zone, KeyTag: key.KeyTag(), State: TrustAnchorAddPend, Since: now, Key: key}
+			anchors = append(anchors, anchor)
+		}
+		seen[anchor] = true
+		if anchor.Key == nil {
+			anchor.Key = key
+		}
+
+		switch {
+		case key.Flags&dnskeyFlagRevoke != 0 && revoked[key.KeyTag()]:
+			if anchor.State != TrustAnchorRevoked {
+				anchor.State, anchor.Since = TrustAnchorRevoked, now
+			}
+		case anchor.State == TrustAnchorAddPend && now.Sub(anchor.Since) >= anchorHoldDown:
+			anchor.State, anchor.Since = TrustAnchorValid, now
+		case anchor.State == TrustAnchorMissing:
+			anchor.State, anchor.Since = TrustAnchorValid, now
+		}
+	}
+
+	kept := anchors[:0]
+	for _, a := range anchors {
+		if !seen[a] {
+			switch a.State {
+			case TrustAnchorAddPend:
+				continue
+			case TrustAnchorValid:
+				a.State, a.Since = TrustAnchorMissing, now
+			}
+		}
+		kept = append(kept, a)
+	}
+	t.anchors[zone] = kept
+}
+
+// trustedKeys returns keys of zone usable to validate its DNSKEY RRset
+func (t *trustAnchors) trustedKeys(zone string, keys []*dns.DNSKEY) []*dns.DNSKEY {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var ret []*dns.DNSKEY
+	for _, key := range keys {
+		for _, a := range t.anchors[zone] {
+			if a.trusted() && a.matches(key) && key.Flags&dnskeyFlagRevoke == 0 {
+				ret = append(ret, key)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// WithTrustAnchors - loads DNSSEC trust anchors (DS or DNSKEY records) from the file at path,
+// the root KSK is used if path is empty. Anchors are kept up to date following RFC 5011
+// by querying nameservers passed to WithNameservers, see TrustAnchors.
+// Anchors validate only the DNSKEY RRsets of these updates, validation of answers is delegated
+// to the nameservers and their AD bit is trusted, see Policy.RequireDNSSEC
+func (r *Resolver) WithTrustAnchors(path string) *Resolver {
+	var src rrSource
+	if path == "" {
+		src = dns.NewZoneParser(strings.NewReader(rootAnchorDS), ".", "")
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			logError(r.logger, r.tag, "Error loading trust anchors", err)
+			return r
+		}
+		defer f.Close()
+		src = dns.NewZoneParser(f, ".", path)
+	}
+
+	now := r.clock.Now()
+	cnt := 0
+	for rr, ok := src.Next(); ok; rr, ok = src.Next() {
+		a := &TrustAnchor{Zone: strings.ToLower(rr.Header().Name), State: TrustAnchorValid, Since: now}
+		switch rec := rr.(type) {
+		case *dns.DS:
+			a.KeyTag, a.DS = rec.KeyTag, rec
+		case *dns.DNSKEY:
+			a.KeyTag, a.Key = rec.KeyTag(), rec
+		default:
+			continue
+		}
+		r.trustAnchors.add(a)
+		cnt++
+	}
+	if err := src.Err(); err != nil {
+		logError(r.logger, r.tag, "Error parsing trust anchors", path, err)
+		return r
+	}
+	if cnt == 0 {
+		logError(r.logger, r.tag, "No trust anchors found in", path)
+		return r
+	}
+
+	r.scheduler.goroutines.spawn("trust-anchors", "", r.trustAnchorsLoop)
+
+	return r
+}
+
+// TrustAnchors returns configured trust anchors with their RFC 5011 states
+func (r *Resolver) TrustAnchors() []TrustAnchor {
+	return r.trustAnchors.list()
+}
+
+// SaveTrustAnchors writes trusted anchor keys into writer in zone file format,
+// the output may be loaded back by WithTrustAnchors
+func (r *Resolver) SaveTrustAnchors(w io.Writer) error {
+	for _, a := range r.trustAnchors.list() {
+		var rr dns.RR
+		switch {
+		case !a.trusted():
+			continue
+		case a.Key != nil:
+			rr = a.Key
+		case a.DS != nil:
+			rr = a.DS
+		default:
+			continue
+		}
+		if _, err := fmt.Fprintln(w, rr.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddNegativeTrustAnchor - disables DNSSEC requirements for zone and its subdomains for lifetime,
+// queries for them are sent with the CD bit so validating nameservers do not answer SERVFAIL
+func (r *Resolver) AddNegativeTrustAnchor(zone string, lifetime time.Duration) {
+	r.trustAnchors.mu.Lock()
+	defer r.trustAnchors.mu.Unlock()
+	if r.trustAnchors.negative == nil {
+		r.trustAnchors.negative = make(map[string]time.Time)
+	}
+	r.trustAnchors.negative[dns.Fqdn(strings.ToLower(zone))] = r.clock.Now().Add(lifetime)
+}
+
+// RemoveNegativeTrustAnchor - removes a negative trust anchor for zone
+func (r *Resolver) RemoveNegativeTrustAnchor(zone string) {
+	r.trustAnchors.mu.Lock()
+	defer r.trustAnchors.mu.Unlock()
+	delete(r.trustAnchors.negative, dns.Fqdn(strings.ToLower(zone)))
+}
+
+// NegativeTrustAnchors returns active negative trust anchors with their expiration times
+func (r *Resolver) NegativeTrustAnchors() map[string]time.Time {
+	r.trustAnchors.mu.Lock()
+	defer r.trustAnchors.mu.Unlock()
+	now := r.clock.Now()
+	ret := make(map[string]time.Time, len(r.trustAnchors.negative))
+	for zone, expire := range r.trustAnchors.negative {
+		if now.Before(expire) {
+			ret[zone] = expire
+		} else {
+			delete(r.trustAnchors.negative, zone)
+		}
+	}
+	return ret
+}
+
+// trustAnchorsLoop ...
+func (r *Resolver) trustAnchorsLoop() {
+	for {
+		interval := anchorMaxRefresh
+		for _, zone := range r.trustAnchors.zones() {
+			zoneInterval, err := r.refreshTrustAnchors(context.Background(), zone)
+			if err != nil {
+				logError(r.logger, r.tag, "Error refreshing trust anchors for", zone, err)
+				zoneInterval = anchorMinRefresh
+			}
+			if zoneInterval < interval {
+				interval = zoneInterval
+			}
+		}
+
+		select {
+		case <-r.stopCh:
+			return
+		case <-r.clock.After(interval):
+		}
+	}
+}
+
+// refreshTrustAnchors validates the DNSKEY RRset of zone with its trusted anchors and applies
+// RFC 5011 rules, returns the interval of the next refresh
+func (r *Resolver) refreshTrustAnchors(ctx context.Context, zone string) (time.Duration, error) {
+	in, err := r.dnsClient.query(ctx, zone, dns.TypeDNSKEY, true)
+	if err != nil {
+		return 0, err
+	}
+	if in.Rcode != dns.RcodeSuccess {
+		return 0, fmt.Errorf("DNSKEY query failed: %s", dns.RcodeToString[in.Rcode])
+	}
+
+	var (
+		keys    []*dns.DNSKEY
+		keySet  []dns.RR
+		sigs    []*dns.RRSIG
+		ttl     uint32
+		now     = r.clock.Now()
+		revoked = make(map[uint16]bool)
+	)
+	for _, rr := range in.Answer {
+		switch rec := rr.(type) {
+		case *dns.DNSKEY:
+			keys = append(keys, rec)
+			keySet = append(keySet, rec)
+			ttl = rec.Hdr.Ttl
+		case *dns.RRSIG:
+			if rec.TypeCovered == dns.TypeDNSKEY {
+				sigs = append(sigs, rec)
+			}
+		}
+	}
+
+	verify := func(key *dns.DNSKEY) bool {
+		for _, sig := range sigs {
+			if sig.KeyTag == key.KeyTag() && sig.ValidityPeriod(now) && sig.Verify(key, keySet) == nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	validated := false
+	for _, key := range r.trustAnchors.trustedKeys(zone, keys) {
+		if verify(key) {
+			validated = true
+			break
+		}
+	}
+	if !validated {
+		return 0, fmt.Errorf("DNSKEY RRset is not signed by a trusted key")
+	}
+
+	for _, key := range keys {
+		if key.Flags&dnskeyFlagRevoke != 0 && verify(key) {
+			revoked[key.KeyTag()] = true
+		}
+	}
+	r.trustAnchors.update(zone, keys, revoked, now)
+
+	interval := time.Duration(ttl) * time.Second / 2
+	if interval < anchorMinRefresh {
+		interval = anchorMinRefresh
+	}
+	if interval > anchorMaxRefresh {
+		interval = anchorMaxRefresh
+	}
+	return interval, nil
+}
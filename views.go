@@ -0,0 +1,121 @@
+package resolver
+
+import (
+	"net"
+	"sync"
+)
+
+// View - settings applied in the server modes to clients from some subnets instead of
+// the settings of the resolver, e.g. to isolate a guest network
+type View struct {
+	// Subnets - CIDRs of the clients, e.g. "192.168.2.0/24"
+	Subnets []string
+
+	// Nameservers - nameservers to resolve hosts with, nameservers of the resolver if empty
+	Nameservers []string
+
+	// Blocklist - glob patterns of hosts which are not resolved, see WithBlocklist
+	Blocklist []string
+
+	// Hosts - local hosts in the format of UpdateHostsFromMaping
+	Hosts map[string]map[string][]string
+}
+
+// viewEntry ...
+type viewEntry struct {
+	name     string
+	nets     []*net.IPNet
+	resolver *Resolver
+}
+
+// views - an ordered list of views, the first matching one wins
+type views struct {
+	mu   sync.RWMutex
+	list []*viewEntry
+}
+
+// add ...
+func (v *views) add(e *viewEntry) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.list = append(v.list, e)
+}
+
+// match returns the resolver of the first view containing ip, nil if there is none
+func (v *views) match(ip net.IP) *Resolver {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for _, e := range v.list {
+		for _, n := range e.nets {
+			if n.Contains(ip) {
+				return e.resolver
+			}
+		}
+	}
+	return nil
+}
+
+// stop stops resolvers of all views
+func (v *views) stop() {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for _, e := range v.list {
+		e.resolver.Stop()
+	}
+}
+
+// WithView - adds a view named name, queries of clients from the view subnets are answered
+// by ServeDNS, DoHHandler and DoTServer with a cache of their own using the view settings.
+// Views are checked in the order they were added, clients matching no view use the resolver
+func (r *Resolver) WithView(name string, view View) *Resolver {
+	e := &viewEntry{name: name}
+	for _, subnet := range view.Subnets {
+		_, n, err := net.ParseCIDR(subnet)
+		if err != nil {
+			logError(r.logger, r.tag, "Subnet of view", name, "is not valid:", subnet)
+			continue
+		}
+		e.nets = append(e.nets, n)
+	}
+
+	nameServers := view.Nameservers
+	if len(nameServers) == 0 {
+		nameServers = r.dnsClient.getNameServers()
+	}
+	e.resolver = NewWithClock(r.tag+"/"+name, r.logger, r.clock).
+		WithNameservers(nameServers...).
+		WithBlocklist(view.Blocklist...)
+	e.resolver.UpdateHostsFromMaping(view.Hosts)
+
+	r.views.add(e)
+	if r.Stopped() {
+		e.resolver.Stop()
+	}
+	return r
+}
+
+// viewResolver returns the resolver answering a client with address addr
+func (r *Resolver) viewResolver(addr net.Addr) *Resolver {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	default:
+		if addr != nil {
+			if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+				ip = net.ParseIP(host)
+			}
+		}
+	}
+	if ip == nil {
+		return r
+	}
+	if v := r.views.match(ip); v != nil {
+		return v
+	}
+	return r
+}
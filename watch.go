@@ -0,0 +1,57 @@
+package resolver
+
+import (
+	"context"
+	"net"
+)
+
+// Watch adds a host with name hostName to maintaining and returns a channel which delivers
+// the current IPv4 and IPv6 addresses of the host and then every subsequent change of them.
+// Changes are coalesced if the receiver is slow. The channel is closed when ctx is done or the resolver is stopped
+func (r *Resolver) Watch(ctx context.Context, hostName string) <-chan []net.IP {
+	hostName = hostOnly(hostName)
+	r.AddHost(hostName)
+
+	r.mu.RLock()
+	h := r.hosts[hostName]
+	r.mu.RUnlock()
+
+	ch := make(chan []net.IP)
+	r.scheduler.goroutines.spawn("watch", r.clientCfg.privacy.redact(hostName), func() {
+		defer r.scheduler.trackWaiter()()
+		defer close(ch)
+		if h == nil {
+			return
+		}
+		// getIPs blocks until the first resolution, so it is awaited only until ctx is done
+		if _, err := h.waitReady(ctx); err != nil {
+			return
+		}
+
+		for {
+			changed := h.changes()
+			ip4, ip6 := h.getIPs()
+			ipList := make([]net.IP, 0, len(ip4)+len(ip6))
+			ipList = append(ipList, ip4...)
+			ipList = append(ipList, ip6...)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.stopCh:
+				return
+			case ch <- ipList:
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.stopCh:
+				return
+			case <-changed:
+			}
+		}
+	})
+
+	return ch
+}
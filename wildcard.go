@@ -0,0 +1,46 @@
+package resolver
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// wildcardPrefix - the prefix of names of static hosts matching all subdomains, e.g. "*.lab.local"
+const wildcardPrefix = "*."
+
+// isWildcard reports whether hostName is a wildcard name
+func isWildcard(hostName string) bool {
+	return strings.HasPrefix(hostName, wildcardPrefix)
+}
+
+// matchWildcard returns the static wildcard host with the longest suffix of hostName,
+// nil if there is no such host. The wildcard does not match its own suffix: "*.lab.local"
+// matches "a.lab.local" and "a.b.lab.local" but not "lab.local". r.mu must be held
+func (r *Resolver) matchWildcard(hostName string) *host {
+	if atomic.LoadInt32(&r.hasWildcards) == 0 {
+		return nil
+	}
+	for name := hostName; ; {
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			return nil
+		}
+		name = name[i+1:]
+		if h, ok := r.hosts[wildcardPrefix+name]; ok && h.static {
+			return h
+		}
+	}
+}
+
+// staticHost returns the host maintained for hostName unless a static wildcard host matches it
+// and the host is not static itself, r.mu must be held
+func (r *Resolver) staticHost(hostName string) (*host, bool) {
+	h, ok := r.hosts[hostName]
+	if ok && h.static {
+		return h, true
+	}
+	if wh := r.matchWildcard(hostName); wh != nil {
+		return wh, true
+	}
+	return h, ok
+}
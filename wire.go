@@ -0,0 +1,131 @@
+package resolver
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// WireExchange - a captured query with its response in wire format
+type WireExchange struct {
+	Time       time.Time
+	Nameserver string
+	Transport  string
+
+	// Request, Response - packed messages, Response is nil on error
+	Request  []byte
+	Response []byte
+
+	Error string
+}
+
+// maxWireRings - the max number of query names exchanges are kept for, the least recently captured
+// name is dropped first
+const maxWireRings = 1024
+
+// wireRing - exchanges of a query name, an element of wireCapture.lru
+type wireRing struct {
+	key       string
+	exchanges []WireExchange
+}
+
+// wireCapture - ring buffers of the last exchanges per query name
+type wireCapture struct {
+	mu    sync.Mutex
+	size  int
+	rings map[string]*list.Element
+	clock Clock
+
+	// lru - wireRing values, the most recently captured first
+	lru list.List
+}
+
+// newWireCapture ...
+func newWireCapture(clock Clock) *wireCapture {
+	return &wireCapture{rings: make(map[string]*list.Element), clock: clock}
+}
+
+// capture stores an exchange if capturing is enabled
+func (c *wireCapture) capture(nServer, transport string, m, in *dns.Msg, err error) {
+	c.mu.Lock()
+	size := c.size
+	c.mu.Unlock()
+	if size <= 0 {
+		return
+	}
+
+	ex := WireExchange{
+		Time:       c.clock.Now(),
+		Nameserver: nServer,
+		Transport:  transport,
+	}
+	ex.Request, _ = m.Pack()
+	if in != nil {
+		ex.Response, _ = in.Pack()
+	}
+	if err != nil {
+		ex.Error = err.Error()
+	}
+
+	key := wireKey(m.Question[0].Name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.rings[key]
+	if ok {
+		c.lru.MoveToFront(el)
+	} else {
+		el = c.lru.PushFront(&wireRing{key: key})
+		c.rings[key] = el
+		if c.lru.Len() > maxWireRings {
+			oldest := c.lru.Back()
+			c.lru.Remove(oldest)
+			delete(c.rings, oldest.Value.(*wireRing).key)
+		}
+	}
+	ring := el.Value.(*wireRing)
+	ring.exchanges = append(ring.exchanges, ex)
+	if len(ring.exchanges) > c.size {
+		ring.exchanges = ring.exchanges[len(ring.exchanges)-c.size:]
+	}
+}
+
+// get ...
+func (c *wireCapture) get(name string) []WireExchange {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.rings[wireKey(name)]
+	if !ok {
+		return []WireExchange{}
+	}
+	ring := el.Value.(*wireRing)
+	ret := make([]WireExchange, len(ring.exchanges))
+	copy(ret, ring.exchanges)
+	return ret
+}
+
+// wireKey ...
+func wireKey(name string) string {
+	return dns.Fqdn(strings.ToLower(name))
+}
+
+// WithWireCapture - keeps the last n raw query/response pairs per host name for up to 1024 names,
+// see WireCapture and DebugHandler. Zero disables capturing
+func (r *Resolver) WithWireCapture(n int) *Resolver {
+	c := r.clientCfg.wire
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = n
+	if n <= 0 {
+		c.rings = make(map[string]*list.Element)
+		c.lru.Init()
+	}
+	return r
+}
+
+// WireCapture returns the captured exchanges for hostName, the oldest first
+func (r *Resolver) WireCapture(hostName string) []WireExchange {
+	return r.clientCfg.wire.get(hostName)
+}
@@ -0,0 +1,88 @@
+package resolver
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/miekg/dns"
+)
+
+// DumpZone dumps into writer all hosts as zone file A/AAAA records with remaining TTLs,
+// owner names are written per WithNamePrivacy
+func (r *Resolver) DumpZone(w io.Writer) {
+	r.mu.RLock()
+	hosts := make(map[string]*host, len(r.hosts))
+	names := make([]string, 0, len(r.hosts))
+	for hostName, h := range r.hosts {
+		hosts[hostName] = h
+		names = append(names, hostName)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+
+	for _, hostName := range names {
+		h := hosts[hostName]
+		if !h.isReady() {
+			continue
+		}
+		addrs := h.addrRRs(r.clientCfg.privacy.redact(hostName))
+		rrs := make([]string, 0, len(addrs))
+		for _, rr := range addrs {
+			rrs = append(rrs, rr.String())
+		}
+		sort.Strings(rrs)
+
+		for _, rr := range rrs {
+			fmt.Fprintln(w, rr)
+		}
+	}
+}
+
+// ExportRRs returns A and AAAA records of the addresses of host with name hostName with remaining TTLs,
+// preceded by CNAME records of the chain of the last answer if the host was resolved through one,
+// the addresses are then owned by the name the chain ends with. Returns nil if the host is not
+// maintained or its first resolution is not done
+func (r *Resolver) ExportRRs(hostName string) []dns.RR {
+	hostName = hostOnly(hostName)
+	r.mu.RLock()
+	h, _ := r.staticHost(hostName)
+	r.mu.RUnlock()
+
+	if h == nil || !h.isReady() {
+		return nil
+	}
+
+	owner := hostName
+	links := h.chain.get()
+	rrs := make([]dns.RR, 0, len(links))
+	if len(links) > 0 && links[0].Name == normalizeName(hostName) {
+		ttl := h.remainingTtl(FamilyAll)
+		for _, link := range links {
+			rrs = append(rrs, &dns.CNAME{
+				Hdr:    dns.RR_Header{Name: dns.Fqdn(link.Name), Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: ttl},
+				Target: dns.Fqdn(link.Target),
+			})
+		}
+		owner = links[len(links)-1].Target
+	}
+	return append(rrs, h.addrRRs(owner)...)
+}
+
+// addrRRs returns A and AAAA records of addresses of the host owned by owner with remaining TTLs
+func (h *host) addrRRs(owner string) []dns.RR {
+	ttl4, ttl6 := h.remainingTtl(FamilyV4), h.remainingTtl(FamilyV6)
+	ip4, ip6 := h.getIPs()
+
+	hdr := func(rrType uint16, ttl uint32) dns.RR_Header {
+		return dns.RR_Header{Name: dns.Fqdn(owner), Rrtype: rrType, Class: dns.ClassINET, Ttl: ttl}
+	}
+	rrs := make([]dns.RR, 0, len(ip4)+len(ip6))
+	for _, ip := range ip4 {
+		rrs = append(rrs, &dns.A{Hdr: hdr(dns.TypeA, ttl4), A: ip})
+	}
+	for _, ip := range ip6 {
+		rrs = append(rrs, &dns.AAAA{Hdr: hdr(dns.TypeAAAA, ttl6), AAAA: ip})
+	}
+	return rrs
+}
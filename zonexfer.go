@@ -0,0 +1,368 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// zoneTransferTimeout - the max time of a SOA query, of dialing the primary server and of
+	// every read and write of a zone transfer
+	zoneTransferTimeout = 30 * time.Second
+
+	// minZoneRefresh - the min interval of zone serial checks, it guards against zero SOA timers
+	minZoneRefresh = time.Minute
+)
+
+var errBadTransfer = errors.New("zone transfer is malformed")
+
+// ZoneTransfer - settings of a zone mirrored from its primary server
+type ZoneTransfer struct {
+	// Zone - the name of the zone, e.g. "lan."
+	Zone string
+
+	// Primary - the primary server as an IP address or ip:port
+	Primary string
+
+	// TSIGName, TSIGSecret, TSIGAlgorithm - a TSIG key to sign transfers with, transfers are not signed
+	// if TSIGName is empty. TSIGSecret is base64 encoded, dns.HmacSHA256 is used if TSIGAlgorithm is empty
+	TSIGName      string
+	TSIGSecret    string
+	TSIGAlgorithm string
+
+	// Refresh - the interval of checking the zone serial, the SOA refresh interval if zero.
+	// Intervals below a minute, e.g. zero SOA timers, are raised to a minute
+	Refresh time.Duration
+}
+
+// mirroredZone - a zone kept in sync with its primary server
+type mirroredZone struct {
+	cfg  ZoneTransfer
+	name string
+
+	mu     sync.RWMutex
+	loaded bool
+	soa    *dns.SOA
+	rrs    map[string][]dns.RR
+
+	// notifyCh - signals a NOTIFY of the primary server
+	notifyCh chan struct{}
+}
+
+// mirroredZones ...
+type mirroredZones struct {
+	mu   sync.RWMutex
+	list []*mirroredZone
+}
+
+// add ...
+func (z *mirroredZones) add(mz *mirroredZone) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.list = append(z.list, mz)
+}
+
+// find returns the most specific zone containing the name, nil if there is none
+func (z *mirroredZones) find(name string) *mirroredZone {
+	name = dns.Fqdn(strings.ToLower(name))
+
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	var found *mirroredZone
+	for _, mz := range z.list {
+		if dns.IsSubDomain(mz.name, name) && (found == nil || len(mz.name) > len(found.name)) {
+			found = mz
+		}
+	}
+	return found
+}
+
+// MirrorZone - mirrors a zone from its primary server by AXFR and keeps it updated by IXFR
+// on serial changes and NOTIFY messages received by ServeDNS. Names of the zone are answered
+// from the mirror by the lookup pipeline, also when nameservers are unreachable
+func (r *Resolver) MirrorZone(zt ZoneTransfer) *Resolver {
+	if zt.TSIGName != "" {
+		zt.TSIGName = dns.Fqdn(zt.TSIGName)
+		if zt.TSIGAlgorithm == "" {
+			zt.TSIGAlgorithm = dns.HmacSHA256
+		}
+	}
+	mz := &mirroredZone{
+		cfg:      zt,
+		name:     dns.Fqdn(strings.ToLower(zt.Zone)),
+		notifyCh: make(chan struct{}, 1),
+	}
+	r.zones.add(mz)
+	r.scheduler.goroutines.spawn("zone-transfer", "", func() { r.zoneTransferLoop(mz) })
+	return r
+}
+
+// zoneTransferLoop ...
+func (r *Resolver) zoneTransferLoop(mz *mirroredZone) {
+	for {
+		interval, err := r.refreshZone(r.scheduler.ctx, mz)
+		if err != nil {
+			logError(r.logger, r.tag, "Error transferring zone", mz.name, err)
+		}
+		if interval < minZoneRefresh {
+			interval = minZoneRefresh
+		}
+
+		select {
+		case <-r.stopCh:
+			return
+		case <-mz.notifyCh:
+		case <-r.clock.After(interval):
+		}
+	}
+}
+
+// refreshZone transfers the zone if its serial changed and returns the interval to the next check,
+// the transfer is aborted when ctx is done
+func (r *Resolver) refreshZone(ctx context.Context, mz *mirroredZone) (time.Duration, error) {
+	soaCtx, cancel := context.WithTimeout(ctx, zoneTransferTimeout)
+	defer cancel()
+
+	mz.mu.RLock()
+	cur := mz.soa
+	mz.mu.RUnlock()
+
+	retry := retryIntervalSec * time.Second
+	if cur != nil {
+		retry = time.Duration(cur.Retry) * time.Second
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion(mz.name, dns.TypeSOA)
+	in, err := exchangeNet(soaCtx, &r.clientCfg.conns, "udp", nameServerAddr(mz.cfg.Primary), q)
+	if err != nil {
+		return retry, err
+	}
+	var soa *dns.SOA
+	for _, rr := range in.Answer {
+		if s, ok := rr.(*dns.SOA); ok {
+			soa = s
+		}
+	}
+	if soa == nil {
+		return retry, errBadTransfer
+	}
+
+	if cur == nil || cur.Serial != soa.Serial {
+		if err = r.transferZone(ctx, mz, cur); err != nil {
+			return retry, err
+		}
+		logInfo(r.logger, r.tag, "Transferred zone", mz.name, "serial", soa.Serial)
+	}
+
+	if mz.cfg.Refresh > 0 {
+		return mz.cfg.Refresh, nil
+	}
+	return time.Duration(soa.Refresh) * time.Second, nil
+}
+
+// transferZone transfers the zone by IXFR from serial of cur or by AXFR if cur is nil,
+// the connection is closed when ctx is done
+func (r *Resolver) transferZone(ctx context.Context, mz *mirroredZone, cur *dns.SOA) error {
+	m := new(dns.Msg)
+	if cur != nil {
+		m.SetIxfr(mz.name, cur.Serial, cur.Ns, cur.Mbox)
+	} else {
+		m.SetAxfr(mz.name)
+	}
+	t := &dns.Transfer{
+		DialTimeout:  zoneTransferTimeout,
+		ReadTimeout:  zoneTransferTimeout,
+		WriteTimeout: zoneTransferTimeout,
+	}
+	if mz.cfg.TSIGName != "" {
+		m.SetTsig(mz.cfg.TSIGName, mz.cfg.TSIGAlgorithm, 300, r.clock.Now().Unix())
+		t.TsigSecret = map[string]string{mz.cfg.TSIGName: mz.cfg.TSIGSecret}
+	}
+
+	d := net.Dialer{Timeout: zoneTransferTimeout}
+	conn, err := d.DialContext(ctx, "tcp", nameServerAddr(mz.cfg.Primary))
+	if err != nil {
+		return err
+	}
+	t.Conn = &dns.Conn{Conn: conn}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	ch, err := t.In(m, nameServerAddr(mz.cfg.Primary))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	var rrs []dns.RR
+	for env := range ch {
+		if env.Error != nil {
+			return env.Error
+		}
+		rrs = append(rrs, env.RR...)
+	}
+	return mz.apply(rrs)
+}
+
+// apply applies records of an AXFR or IXFR response
+func (mz *mirroredZone) apply(rrs []dns.RR) error {
+	if len(rrs) < 2 {
+		return errBadTransfer
+	}
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return errBadTransfer
+	}
+	if last, ok := rrs[len(rrs)-1].(*dns.SOA); !ok || last.Serial != soa.Serial {
+		return errBadTransfer
+	}
+
+	mz.mu.Lock()
+	defer mz.mu.Unlock()
+
+	if _, ok := rrs[1].(*dns.SOA); !ok || len(rrs) == 2 {
+		// AXFR or a full zone in the IXFR response
+		zone := make(map[string][]dns.RR)
+		for _, rr := range rrs[:len(rrs)-1] {
+			name := strings.ToLower(rr.Header().Name)
+			zone[name] = append(zone[name], rr)
+		}
+		mz.rrs, mz.soa, mz.loaded = zone, soa, true
+		return nil
+	}
+
+	// IXFR: sequences of a SOA with deleted records and a SOA with added records
+	if !mz.loaded {
+		return errBadTransfer
+	}
+	zone := make(map[string][]dns.RR, len(mz.rrs))
+	for name, list := range mz.rrs {
+		zone[name] = append([]dns.RR(nil), list...)
+	}
+	// the SOA opening each sequence switches between deleting and adding, deleting goes first
+	adding := true
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		if _, ok := rr.(*dns.SOA); ok {
+			adding = !adding
+			continue
+		}
+		name := strings.ToLower(rr.Header().Name)
+		if adding {
+			zone[name] = append(zone[name], rr)
+			continue
+		}
+		list := zone[name][:0]
+		for _, cur := range zone[name] {
+			if !dns.IsDuplicate(cur, rr) {
+				list = append(list, cur)
+			}
+		}
+		zone[name] = list
+	}
+	apex := mz.name
+	for i, rr := range zone[apex] {
+		if _, ok := rr.(*dns.SOA); ok {
+			zone[apex][i] = soa
+		}
+	}
+	mz.rrs, mz.soa = zone, soa
+	return nil
+}
+
+// isPrimary reports whether addr is an address of the primary server
+func (mz *mirroredZone) isPrimary(addr net.Addr) bool {
+	if addr == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	primary := mz.cfg.Primary
+	if h, _, err := net.SplitHostPort(primary); err == nil {
+		primary = h
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.Equal(net.ParseIP(primary))
+}
+
+// lookup answers a lookup from the zone, ok is false if the zone is not transferred yet
+func (mz *mirroredZone) lookup(l lookup) (lookupResult, bool) {
+	mz.mu.RLock()
+	defer mz.mu.RUnlock()
+	if !mz.loaded {
+		return lookupResult{}, false
+	}
+
+	rrs := mz.rrs[dns.Fqdn(l.name)]
+	if len(rrs) == 0 {
+		return lookupResult{rcode: dns.RcodeNameError, ns: []dns.RR{mz.soa}}, true
+	}
+
+	if l.isAddr() {
+		var (
+			ip4, ip6   []net.IP
+			ttl4, ttl6 uint32
+		)
+		for _, rr := range rrs {
+			switch v := rr.(type) {
+			case *dns.A:
+				ip4, ttl4 = append(ip4, v.A), v.Hdr.Ttl
+			case *dns.AAAA:
+				ip6, ttl6 = append(ip6, v.AAAA), v.Hdr.Ttl
+			}
+		}
+		return lookupResult{rcode: dns.RcodeSuccess, ip4: ip4, ip6: ip6, ttl4: ttl4, ttl6: ttl6}, true
+	}
+
+	res := lookupResult{rcode: dns.RcodeSuccess}
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == l.qtype {
+			res.answer = append(res.answer, rr)
+		}
+	}
+	if len(res.answer) == 0 {
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == dns.TypeCNAME {
+				res.answer = append(res.answer, rr)
+			}
+		}
+	}
+	if len(res.answer) == 0 {
+		res.ns = []dns.RR{mz.soa}
+	}
+	return res, true
+}
+
+// notify handles a NOTIFY message of the primary server of a mirrored zone sent from addr
+func (r *Resolver) notify(req *dns.Msg, addr net.Addr) *dns.Msg {
+	resp := new(dns.Msg)
+	if len(req.Question) != 1 {
+		return resp.SetRcode(req, dns.RcodeFormatError)
+	}
+	mz := r.zones.find(req.Question[0].Name)
+	if mz == nil || mz.name != dns.Fqdn(strings.ToLower(req.Question[0].Name)) || !mz.isPrimary(addr) {
+		return resp.SetRcode(req, dns.RcodeRefused)
+	}
+
+	select {
+	case mz.notifyCh <- struct{}{}:
+	default:
+	}
+	resp.SetReply(req)
+	resp.Authoritative = true
+	return resp
+}